@@ -0,0 +1,314 @@
+package lhw
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/loghole/lhw/internal"
+	"github.com/loghole/lhw/metrics"
+)
+
+// Replayer is implemented by fallback sinks which can return diverted
+// entries to be re-shipped to the collector.
+type Replayer interface {
+	// Replay calls fn for stored entries in order and removes processed
+	// ones, it stops on the first error and keeps the rest of entries.
+	Replay(fn func(entry []byte) error) error
+}
+
+// FileFallback stores diverted entries in a file one per line
+// and replays them after the collector returns.
+type FileFallback struct {
+	path string
+	mu   sync.Mutex
+	file *os.File
+
+	// replayMu serializes replays, mu is not held while replaying
+	// so writes are not blocked by the replay callback.
+	replayMu sync.Mutex
+}
+
+func NewFileFallback(path string) (*FileFallback, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &FileFallback{path: path, file: file}
+
+	if err := f.recover(); err != nil {
+		file.Close()
+
+		return nil, err
+	}
+
+	return f, nil
+}
+
+func (f *FileFallback) Write(p []byte) (n int, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.file.Write(p)
+}
+
+// Replay calls fn for every stored line. The stored entries are moved to
+// the replay file first, so entries written while replaying are kept and
+// replayed after the entries left by failed fn.
+func (f *FileFallback) Replay(fn func(entry []byte) error) error {
+	f.replayMu.Lock()
+	defer f.replayMu.Unlock()
+
+	if err := f.recover(); err != nil {
+		return err
+	}
+
+	if err := f.rotate(); err != nil {
+		return err
+	}
+
+	file, err := os.Open(f.replayPath())
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	var (
+		reader = bufio.NewReader(file)
+		fnErr  error
+	)
+
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+
+		if entry := bytes.TrimSpace(line); len(entry) > 0 {
+			if fnErr = fn(append([]byte{}, entry...)); fnErr != nil {
+				tail, err := ioutil.ReadAll(reader)
+				if err != nil {
+					return err
+				}
+
+				if err := f.restore(append(line, tail...)); err != nil {
+					return err
+				}
+
+				break
+			}
+		}
+
+		if err != nil {
+			break
+		}
+	}
+
+	if err := os.Remove(f.replayPath()); err != nil {
+		return err
+	}
+
+	return fnErr
+}
+
+// recover restores entries of the replay interrupted by the process
+// exit or by failed restore.
+func (f *FileFallback) recover() error {
+	rest, err := ioutil.ReadFile(f.replayPath())
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if err := f.restore(rest); err != nil {
+		return err
+	}
+
+	return os.Remove(f.replayPath())
+}
+
+// rotate moves stored entries to the replay file and opens an empty file.
+func (f *FileFallback) rotate() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := os.Rename(f.path, f.replayPath()); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+
+	f.file.Close()
+	f.file = file
+
+	return nil
+}
+
+// restore puts entries back before the entries stored since rotation.
+func (f *FileFallback) restore(rest []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, err := f.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	written, err := ioutil.ReadAll(f.file)
+	if err != nil {
+		return err
+	}
+
+	if err := f.file.Truncate(0); err != nil {
+		return err
+	}
+
+	if len(rest) > 0 && rest[len(rest)-1] != '\n' {
+		rest = append(rest, '\n')
+	}
+
+	_, err = f.file.Write(append(rest, written...))
+
+	return err
+}
+
+func (f *FileFallback) replayPath() string {
+	return f.path + ".replay"
+}
+
+func (f *FileFallback) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.file.Close()
+}
+
+// waitConnected waits until the transport is connected. During the outage
+// the batch is diverted to the fallback after the fallback delay or when the
+// queue reaches the fallback watermark. It reports false if the batch was
+// diverted or dropped, first is a sequence number of the first batch entry.
+func (w *Writer) waitConnected(batch *internal.Batch, first uint64) bool {
+	if w.transport.IsConnected() {
+		w.outageSince = time.Time{}
+
+		return true
+	}
+
+	if w.outageSince.IsZero() {
+		w.outageSince = time.Now()
+	}
+
+	var divert <-chan time.Time
+
+	if w.fallback != nil {
+		if w.fallbackWatermark > 0 && w.queue.Len() >= w.fallbackWatermark {
+			w.divert(batch.Flush(), first)
+
+			return false
+		}
+
+		timer := time.NewTimer(w.fallbackAfter - time.Since(w.outageSince))
+		defer timer.Stop()
+
+		divert = timer.C
+	}
+
+	select {
+	case <-w.transport.IsReconnected():
+		w.outageSince = time.Time{}
+
+		return true
+	case <-divert:
+	case <-w.divertSignal:
+	case <-w.abort:
+		w.abandon(batch.Flush())
+
+		return false
+	}
+
+	w.divert(batch.Flush(), first)
+
+	return false
+}
+
+// divert writes entries to the fallback sink one per line,
+// entries left after failed write are dropped.
+func (w *Writer) divert(entries [][]byte, first uint64) {
+	// Diverted and dropped entries are no longer kept by the queue.
+	defer w.queue.Ack(first, len(entries))
+	defer w.release(entries)
+
+	for idx, entry := range entries {
+		if _, err := w.fallback.Write(append(bytes.TrimSpace(entry), '\n')); err != nil {
+			if w.logger != nil {
+				w.logger.Printf("[error] write to fallback failed: %v", err)
+			}
+
+			w.drop(entries[idx:], metrics.ReasonSendFailed)
+			entries = entries[:idx]
+
+			break
+		}
+	}
+
+	if len(entries) == 0 {
+		return
+	}
+
+	if w.fallbackReship {
+		atomic.StoreInt32(&w.reshipPending, 1)
+	}
+
+	w.metrics.Diverted(len(entries))
+	atomic.AddInt64(&w.pending, -int64(len(entries)))
+}
+
+// reship pushes entries stored by the fallback back to the queue,
+// entries left after failed push are re-shipped on the next attempt.
+func (w *Writer) reship() {
+	defer w.wg.Done()
+
+	replayer, ok := w.fallback.(Replayer)
+	if !ok {
+		return
+	}
+
+	if err := replayer.Replay(w.rewrite); err != nil {
+		atomic.StoreInt32(&w.reshipPending, 1)
+
+		if w.logger != nil {
+			w.logger.Printf("[error] reship fallback entries failed: %v", err)
+		}
+	}
+}
+
+// rewrite pushes the re-shipped entry to the queue, too large entries
+// are dropped and entries failed to push are kept by the fallback.
+func (w *Writer) rewrite(entry []byte) error {
+	entry, err := w.limit(entry)
+	if err != nil {
+		return nil
+	}
+
+	if err := w.push(entry); err != nil {
+		return err
+	}
+
+	w.metrics.Written(1)
+	w.checkWatermark()
+
+	return nil
+}
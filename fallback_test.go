@@ -0,0 +1,224 @@
+package lhw
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/loghole/lhw/internal"
+	"github.com/loghole/lhw/test"
+)
+
+func TestFileFallback_Replay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fallback.log")
+
+	fallback, err := NewFileFallback(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer fallback.Close()
+
+	for _, entry := range []string{"1\n", "2\n", "3\n"} {
+		_, err := fallback.Write([]byte(entry))
+		assert.Nil(t, err)
+	}
+
+	var res []string
+
+	errStop := errors.New("stop")
+
+	err = fallback.Replay(func(entry []byte) error {
+		if len(res) == 2 {
+			return errStop
+		}
+
+		res = append(res, string(entry))
+
+		return nil
+	})
+	assert.ErrorIs(t, err, errStop)
+	assert.Equal(t, []string{"1", "2"}, res)
+
+	_, err = fallback.Write([]byte("4\n"))
+	assert.Nil(t, err)
+
+	data, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, "3\n4\n", string(data))
+
+	err = fallback.Replay(func(entry []byte) error {
+		res = append(res, string(entry))
+
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"1", "2", "3", "4"}, res)
+
+	data, err = ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Empty(t, data)
+}
+
+func TestFileFallback_ReplayWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fallback.log")
+
+	fallback, err := NewFileFallback(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer fallback.Close()
+
+	_, err = fallback.Write([]byte("1\n2\n"))
+	assert.Nil(t, err)
+
+	errStop := errors.New("stop")
+
+	// Entries written by the callback must not wait for the replay.
+	err = fallback.Replay(func(entry []byte) error {
+		if _, err := fallback.Write([]byte("new-" + string(entry) + "\n")); err != nil {
+			return err
+		}
+
+		if string(entry) == "2" {
+			return errStop
+		}
+
+		return nil
+	})
+	assert.ErrorIs(t, err, errStop)
+
+	data, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, "2\nnew-1\nnew-2\n", string(data))
+
+	// Entries left by the interrupted replay are restored on open.
+	assert.Nil(t, fallback.Close())
+	assert.Nil(t, ioutil.WriteFile(path+".replay", []byte("0"), 0o600))
+
+	fallback, err = NewFileFallback(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer fallback.Close()
+
+	data, err = ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, "0\n2\nnew-1\nnew-2\n", string(data))
+	assert.NoFileExists(t, path+".replay")
+}
+
+func TestWriter_divert(t *testing.T) {
+	tests := []struct {
+		name        string
+		after       time.Duration
+		watermark   int
+		queued      int
+		expectedRes string
+	}{
+		{
+			name:        "Delay",
+			after:       10 * time.Millisecond,
+			expectedRes: "{\"a\":1}\n{\"a\":2}\n",
+		},
+		{
+			name:        "Watermark",
+			after:       time.Hour,
+			watermark:   1,
+			queued:      1,
+			expectedRes: "{\"a\":1}\n{\"a\":2}\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink := &bytes.Buffer{}
+			queue := internal.NewQueue(10)
+
+			for i := 0; i < tt.queued; i++ {
+				assert.Nil(t, queue.Push([]byte("queued")))
+			}
+
+			writer := &Writer{
+				transport:         &test.StubTransport{Disconnected: true},
+				queue:             queue,
+				fallback:          sink,
+				fallbackAfter:     tt.after,
+				fallbackWatermark: tt.watermark,
+				fallbackReship:    true,
+				pending:           2,
+			}
+
+			batch := internal.NewBatch(10, 1024)
+			batch.Append([]byte("{\"a\":1}\n"))
+			batch.Append([]byte("{\"a\":2}"))
+
+			writer.flush(batch)
+
+			assert.Equal(t, tt.expectedRes, sink.String())
+			assert.Equal(t, int64(0), writer.pending)
+			assert.Equal(t, int32(1), writer.reshipPending)
+			assert.Equal(t, 0, batch.Len())
+		})
+	}
+}
+
+func TestWriter_reship(t *testing.T) {
+	fallback, err := NewFileFallback(filepath.Join(t.TempDir(), "fallback.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer fallback.Close()
+
+	_, err = fallback.Write([]byte("1\n2\n"))
+	assert.Nil(t, err)
+
+	writer := &Writer{
+		transport:     &test.StubTransport{},
+		queue:         internal.NewQueue(10),
+		fallback:      fallback,
+		reshipPending: 1,
+	}
+
+	writer.flush(internal.NewBatch(10, 1024))
+	writer.wg.Wait()
+
+	assert.Equal(t, int32(0), writer.reshipPending)
+	assert.Equal(t, []byte("1"), <-writer.queue.Read())
+	assert.Equal(t, []byte("2"), <-writer.queue.Read())
+}
+
+func TestWriter_reshipTooLarge(t *testing.T) {
+	fallback, err := NewFileFallback(filepath.Join(t.TempDir(), "fallback.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer fallback.Close()
+
+	_, err = fallback.Write([]byte("1\n123456\n2\n"))
+	assert.Nil(t, err)
+
+	writer := &Writer{
+		transport:     &test.StubTransport{},
+		queue:         internal.NewQueue(10),
+		fallback:      fallback,
+		maxEntrySize:  3,
+		reshipPending: 1,
+	}
+
+	writer.flush(internal.NewBatch(10, 1024))
+	writer.wg.Wait()
+
+	assert.Equal(t, 2, writer.queue.Len())
+	assert.Equal(t, int64(2), writer.pending)
+	assert.Equal(t, []byte("1"), <-writer.queue.Read())
+	assert.Equal(t, []byte("2"), <-writer.queue.Read())
+}
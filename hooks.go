@@ -0,0 +1,36 @@
+package lhw
+
+import (
+	"github.com/loghole/lhw/metrics"
+	"github.com/loghole/lhw/transport"
+)
+
+// Reasons of dropped entries passed to Hooks.OnDrop.
+const (
+	DropQueueFull  = metrics.ReasonQueueFull
+	DropTooLarge   = metrics.ReasonTooLarge
+	DropSendFailed = metrics.ReasonSendFailed
+	DropAborted    = metrics.ReasonAborted
+	DropRejected   = metrics.ReasonRejected
+)
+
+// Hooks receives delivery events of the writer and its transport.
+// Hooks are called synchronously from writing and sending goroutines
+// and must not block.
+type Hooks interface {
+	transport.Hooks
+
+	// OnDrop is called for every entry lost by the writer.
+	OnDrop(entry []byte, reason string)
+	// OnQueueHighWatermark is called when queue depth reaches the high
+	// watermark, it is called again after depth falls below the watermark.
+	OnQueueHighWatermark(depth int)
+}
+
+// NopHooks ignores all events, it can be embedded to implement only some hooks.
+type NopHooks struct {
+	transport.NopHooks
+}
+
+func (NopHooks) OnDrop([]byte, string)    {}
+func (NopHooks) OnQueueHighWatermark(int) {}
@@ -0,0 +1,51 @@
+package internal
+
+// Batch accumulates queued entries until it reaches its entry or byte limit.
+type Batch struct {
+	entries  [][]byte
+	size     int
+	maxLen   int
+	maxBytes int
+}
+
+func NewBatch(maxLen, maxBytes int) *Batch {
+	return &Batch{
+		entries:  make([][]byte, 0, maxLen),
+		maxLen:   maxLen,
+		maxBytes: maxBytes,
+	}
+}
+
+// Fits reports whether data can be appended without exceeding the byte limit.
+// An empty batch accepts any entry, so oversized entries are sent alone.
+func (b *Batch) Fits(data []byte) bool {
+	return len(b.entries) == 0 || b.size+len(data) <= b.maxBytes
+}
+
+func (b *Batch) Append(data []byte) {
+	b.entries = append(b.entries, data)
+	b.size += len(data)
+}
+
+// IsFull reports whether the batch reached the entry or byte limit.
+func (b *Batch) IsFull() bool {
+	return len(b.entries) >= b.maxLen || b.size >= b.maxBytes
+}
+
+func (b *Batch) Len() int {
+	return len(b.entries)
+}
+
+func (b *Batch) Size() int {
+	return b.size
+}
+
+// Flush returns accumulated entries and resets the batch.
+func (b *Batch) Flush() [][]byte {
+	entries := b.entries
+
+	b.entries = make([][]byte, 0, b.maxLen)
+	b.size = 0
+
+	return entries
+}
@@ -0,0 +1,37 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBatch(t *testing.T) {
+	batch := NewBatch(3, 10)
+
+	assert.True(t, batch.Fits([]byte("12345678901")), "empty batch should accept any entry")
+
+	batch.Append([]byte("1234"))
+	batch.Append([]byte("1234"))
+
+	assert.Equal(t, 2, batch.Len())
+	assert.Equal(t, 8, batch.Size())
+	assert.False(t, batch.IsFull())
+	assert.False(t, batch.Fits([]byte("123")))
+	assert.True(t, batch.Fits([]byte("12")))
+
+	batch.Append([]byte("12"))
+
+	assert.True(t, batch.IsFull())
+
+	assert.Equal(t, [][]byte{[]byte("1234"), []byte("1234"), []byte("12")}, batch.Flush())
+	assert.Equal(t, 0, batch.Len())
+	assert.Equal(t, 0, batch.Size())
+
+	batch.Append([]byte("1"))
+	batch.Append([]byte("2"))
+	batch.Append([]byte("3"))
+
+	assert.True(t, batch.IsFull())
+	assert.Equal(t, [][]byte{[]byte("1"), []byte("2"), []byte("3")}, batch.Flush())
+}
@@ -0,0 +1,36 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package internal
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// lockDir takes the exclusive lock of the directory, the lock is released
+// by the os when the process exits.
+func lockDir(dir string) (*os.File, error) {
+	file, err := os.OpenFile(lockPath(dir), os.O_CREATE|os.O_RDWR, 0o640)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		file.Close()
+
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, ErrIsLocked
+		}
+
+		return nil, err
+	}
+
+	return file, nil
+}
+
+func unlockDir(file *os.File) {
+	_ = syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+	_ = file.Close()
+}
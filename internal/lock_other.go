@@ -0,0 +1,25 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package internal
+
+import (
+	"errors"
+	"os"
+)
+
+// lockDir creates the lock file of the directory, the file is left
+// by a crashed process and has to be removed manually.
+func lockDir(dir string) (*os.File, error) {
+	file, err := os.OpenFile(lockPath(dir), os.O_CREATE|os.O_EXCL|os.O_RDWR, 0o640)
+	if errors.Is(err, os.ErrExist) {
+		return nil, ErrIsLocked
+	}
+
+	return file, err
+}
+
+func unlockDir(file *os.File) {
+	_ = file.Close()
+	_ = os.Remove(file.Name())
+}
@@ -3,6 +3,10 @@ package internal
 import (
 	"errors"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/loghole/lhw/metrics"
 )
 
 var (
@@ -10,17 +14,83 @@ var (
 	ErrIsFull   = errors.New("is full")
 )
 
+// Buffer stores entries until they are read.
+type Buffer interface {
+	Push(data []byte) error
+	Read() <-chan []byte
+	// Release frees space taken by the entry received from Read.
+	Release(data []byte)
+	// Ack acknowledges count entries received from Read starting from the
+	// sequence number of the first one, entries are numbered from 0 in the
+	// order of Read. Acknowledged entries are delivered or finally dropped.
+	Ack(first uint64, count int)
+	Len() int
+	Close()
+}
+
+// OverflowPolicy defines behaviour of Push to the full queue.
+type OverflowPolicy int
+
+const (
+	// DropNewest rejects the pushed entry.
+	DropNewest OverflowPolicy = iota
+	// DropOldest discards the oldest entry in the queue.
+	DropOldest
+	// BlockWithTimeout waits for free space until the timeout expires.
+	BlockWithTimeout
+	// Block waits for free space until the queue is closed.
+	Block
+)
+
+func (p OverflowPolicy) String() string {
+	switch p {
+	case DropNewest:
+		return "drop_newest"
+	case DropOldest:
+		return "drop_oldest"
+	case BlockWithTimeout:
+		return "block_with_timeout"
+	case Block:
+		return "block"
+	default:
+		return "unknown"
+	}
+}
+
+type QueueConfig struct {
+	Capacity int
+	// MaxBytes limits total size of entries, 0 means unlimited.
+	MaxBytes     int64
+	Overflow     OverflowPolicy
+	BlockTimeout time.Duration
+	Metrics      *metrics.Metrics
+	// OnEvict is called for entries evicted by DropOldest policy.
+	OnEvict func(data []byte)
+}
+
 type Queue struct {
-	ch     chan []byte
-	mu     sync.RWMutex
-	wg     sync.WaitGroup
-	sn     sync.Once
-	closed bool
+	ch      chan []byte
+	done    chan struct{}
+	space   Signal
+	config  QueueConfig
+	size    int64
+	dropped int64
+	mu      sync.RWMutex
+	wg      sync.WaitGroup
+	sn      sync.Once
+	closed  bool
 }
 
 func NewQueue(capacity int) *Queue {
+	return NewQueueWithConfig(QueueConfig{Capacity: capacity})
+}
+
+func NewQueueWithConfig(config QueueConfig) *Queue {
 	return &Queue{
-		ch: make(chan []byte, capacity),
+		ch:     make(chan []byte, config.Capacity),
+		done:   make(chan struct{}),
+		space:  make(Signal, 1),
+		config: config,
 	}
 }
 
@@ -35,22 +105,168 @@ func (q *Queue) Push(data []byte) error {
 	q.wg.Add(1)
 	q.mu.RUnlock()
 
-	select {
-	case q.ch <- data:
-		q.wg.Done()
+	defer q.wg.Done()
+
+	if q.config.MaxBytes > 0 && int64(len(data)) > q.config.MaxBytes {
+		q.drop()
 
+		return ErrIsFull
+	}
+
+	if q.tryPush(data) {
 		return nil
+	}
+
+	switch q.config.Overflow {
+	case DropOldest:
+		return q.pushDropOldest(data)
+	case BlockWithTimeout:
+		timer := time.NewTimer(q.config.BlockTimeout)
+		defer timer.Stop()
+
+		return q.pushBlock(data, timer.C)
+	case Block:
+		return q.pushBlock(data, nil)
 	default:
-		q.wg.Done()
+		q.drop()
 
 		return ErrIsFull
 	}
 }
 
+// Dropped returns count of entries dropped by the overflow policy.
+func (q *Queue) Dropped() int64 {
+	return atomic.LoadInt64(&q.dropped)
+}
+
+func (q *Queue) drop() {
+	atomic.AddInt64(&q.dropped, 1)
+
+	q.config.Metrics.QueueDropped(q.config.Overflow.String())
+}
+
+// Size returns total size of entries in the queue.
+func (q *Queue) Size() int64 {
+	return atomic.LoadInt64(&q.size)
+}
+
+// Release frees bytes taken by the entry received from Read.
+func (q *Queue) Release(data []byte) {
+	if q.config.MaxBytes > 0 {
+		atomic.AddInt64(&q.size, -int64(len(data)))
+
+		q.space.Send()
+	}
+
+	q.config.Metrics.QueueState(q.Len(), q.Size())
+}
+
+// tryPush reserves bytes for the entry and sends it to the channel
+// without blocking, it reports false if the queue is full.
+func (q *Queue) tryPush(data []byte) bool {
+	if q.config.MaxBytes > 0 {
+		if atomic.AddInt64(&q.size, int64(len(data))) > q.config.MaxBytes {
+			atomic.AddInt64(&q.size, -int64(len(data)))
+
+			return false
+		}
+	}
+
+	select {
+	case q.ch <- data:
+		q.config.Metrics.QueueState(q.Len(), q.Size())
+
+		return true
+	default:
+		if q.config.MaxBytes > 0 {
+			atomic.AddInt64(&q.size, -int64(len(data)))
+		}
+
+		return false
+	}
+}
+
+func (q *Queue) pushDropOldest(data []byte) error {
+	for !q.tryPush(data) {
+		select {
+		case old := <-q.ch:
+			q.Release(old)
+
+			q.drop()
+
+			if q.config.OnEvict != nil {
+				q.config.OnEvict(old)
+			}
+		default:
+			// The queue was emptied by the reader, push again. Bytes of
+			// entries being sent can not be evicted, so the entry does
+			// not fit if nothing is left to evict.
+			if q.config.MaxBytes > 0 && len(q.ch) == 0 && atomic.LoadInt64(&q.size)+int64(len(data)) > q.config.MaxBytes {
+				q.drop()
+
+				return ErrIsFull
+			}
+		}
+	}
+
+	return nil
+}
+
+// pushBlock waits for free space until timeout or the queue is closed,
+// nil timeout channel means waiting without timeout.
+func (q *Queue) pushBlock(data []byte, timeout <-chan time.Time) error {
+	for {
+		if q.config.MaxBytes <= 0 {
+			select {
+			case q.ch <- data:
+				q.config.Metrics.QueueState(q.Len(), q.Size())
+
+				return nil
+			case <-timeout:
+				q.drop()
+
+				return ErrIsFull
+			case <-q.done:
+				q.drop()
+
+				return ErrIsClosed
+			}
+		}
+
+		if q.tryPush(data) {
+			// Wake up next waiter, space may be left for it.
+			q.space.Send()
+
+			return nil
+		}
+
+		// Byte limited queue waits until some entry is released.
+		select {
+		case <-q.space:
+		case <-timeout:
+			q.drop()
+
+			return ErrIsFull
+		case <-q.done:
+			q.drop()
+
+			return ErrIsClosed
+		}
+	}
+}
+
 func (q *Queue) Read() <-chan []byte {
 	return q.ch
 }
 
+// Ack does nothing, entries of the queue are gone once they are read.
+func (q *Queue) Ack(uint64, int) {}
+
+// Len returns count of entries in the queue.
+func (q *Queue) Len() int {
+	return len(q.ch)
+}
+
 func (q *Queue) Close() {
 	q.sn.Do(q.close)
 }
@@ -58,6 +274,7 @@ func (q *Queue) Close() {
 func (q *Queue) close() {
 	q.mu.Lock()
 	q.closed = true
+	close(q.done)
 	q.wg.Wait()
 	close(q.ch)
 	q.mu.Unlock()
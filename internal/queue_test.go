@@ -137,3 +137,236 @@ func BenchmarkNewQueue(b *testing.B) {
 		})
 	})
 }
+
+func TestQueueOverflow(t *testing.T) {
+	tests := []struct {
+		name            string
+		config          QueueConfig
+		expectedErr     string
+		expectedRes     []string
+		expectedDropped int64
+	}{
+		{
+			name:            "DropNewest",
+			config:          QueueConfig{Capacity: 2, Overflow: DropNewest},
+			expectedErr:     ErrIsFull.Error(),
+			expectedRes:     []string{"1", "2"},
+			expectedDropped: 1,
+		},
+		{
+			name:            "DropOldest",
+			config:          QueueConfig{Capacity: 2, Overflow: DropOldest},
+			expectedRes:     []string{"2", "3"},
+			expectedDropped: 1,
+		},
+		{
+			name:            "BlockWithTimeout",
+			config:          QueueConfig{Capacity: 2, Overflow: BlockWithTimeout, BlockTimeout: 10 * time.Millisecond},
+			expectedErr:     ErrIsFull.Error(),
+			expectedRes:     []string{"1", "2"},
+			expectedDropped: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			queue := NewQueueWithConfig(tt.config)
+
+			assert.Nil(t, queue.Push([]byte("1")))
+			assert.Nil(t, queue.Push([]byte("2")))
+
+			err := queue.Push([]byte("3"))
+			if tt.expectedErr != "" {
+				assert.EqualError(t, err, tt.expectedErr)
+			} else {
+				assert.Nil(t, err)
+			}
+
+			queue.Close()
+
+			var res []string
+
+			for data := range queue.Read() {
+				res = append(res, string(data))
+			}
+
+			assert.Equal(t, tt.expectedRes, res)
+			assert.Equal(t, tt.expectedDropped, queue.Dropped())
+		})
+	}
+}
+
+func TestQueueOverflowBlock(t *testing.T) {
+	queue := NewQueueWithConfig(QueueConfig{Capacity: 1, Overflow: Block})
+
+	assert.Nil(t, queue.Push([]byte("1")))
+
+	pushed := make(chan error)
+
+	go func() { pushed <- queue.Push([]byte("2")) }()
+
+	select {
+	case <-pushed:
+		t.Fatal("push to the full queue should block")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	assert.Equal(t, []byte("1"), <-queue.Read())
+	assert.Nil(t, <-pushed)
+	assert.Equal(t, []byte("2"), <-queue.Read())
+
+	assert.Nil(t, queue.Push([]byte("3")))
+
+	go func() { pushed <- queue.Push([]byte("4")) }()
+
+	time.Sleep(10 * time.Millisecond)
+
+	queue.Close()
+
+	assert.EqualError(t, <-pushed, ErrIsClosed.Error())
+	assert.Equal(t, int64(1), queue.Dropped())
+}
+
+func TestQueueOverflowRace(t *testing.T) {
+	policies := []QueueConfig{
+		{Capacity: 10, Overflow: DropNewest},
+		{Capacity: 10, Overflow: DropOldest},
+		{Capacity: 10, Overflow: BlockWithTimeout, BlockTimeout: time.Millisecond},
+		{Capacity: 10, Overflow: Block},
+	}
+
+	for _, config := range policies {
+		var (
+			queue   = NewQueueWithConfig(config)
+			writers = &sync.WaitGroup{}
+			done    = make(chan struct{})
+
+			pushed, read int64
+		)
+
+		writers.Add(3)
+
+		for i := 0; i < 3; i++ {
+			go func() {
+				defer writers.Done()
+
+				for i := 0; i < 1000; i++ {
+					if err := queue.Push([]byte("msg")); err == nil {
+						atomic.AddInt64(&pushed, 1)
+					}
+				}
+			}()
+		}
+
+		go func() {
+			defer close(done)
+
+			for range queue.Read() {
+				atomic.AddInt64(&read, 1)
+			}
+		}()
+
+		writers.Wait()
+		queue.Close()
+		<-done
+
+		if config.Overflow == DropOldest {
+			// Evicted entries were pushed successfully.
+			assert.Equal(t, pushed-queue.Dropped(), read, "policy %d", config.Overflow)
+		} else {
+			// Rejected entries were not pushed.
+			assert.Equal(t, pushed, read, "policy %d", config.Overflow)
+			assert.Equal(t, int64(3000)-pushed, queue.Dropped(), "policy %d", config.Overflow)
+		}
+	}
+}
+
+func TestQueueMaxBytes(t *testing.T) {
+	queue := NewQueueWithConfig(QueueConfig{Capacity: 10, MaxBytes: 10})
+
+	assert.Nil(t, queue.Push([]byte("12345")))
+	assert.Nil(t, queue.Push([]byte("1234")))
+	assert.EqualError(t, queue.Push([]byte("12")), ErrIsFull.Error())
+	assert.Nil(t, queue.Push([]byte("1")))
+	assert.EqualError(t, queue.Push([]byte("12345678901")), ErrIsFull.Error())
+
+	assert.Equal(t, int64(10), queue.Size())
+	assert.Equal(t, int64(2), queue.Dropped())
+
+	data := <-queue.Read()
+	queue.Release(data)
+
+	assert.Equal(t, int64(5), queue.Size())
+	assert.Nil(t, queue.Push([]byte("12")))
+}
+
+func TestQueueMaxBytesOverflow(t *testing.T) {
+	queue := NewQueueWithConfig(QueueConfig{Capacity: 10, MaxBytes: 10, Overflow: DropOldest})
+
+	assert.Nil(t, queue.Push([]byte("12345")))
+	assert.Nil(t, queue.Push([]byte("1234")))
+	assert.Nil(t, queue.Push([]byte("123")))
+
+	assert.Equal(t, int64(7), queue.Size())
+	assert.Equal(t, int64(1), queue.Dropped())
+
+	queue = NewQueueWithConfig(QueueConfig{Capacity: 10, MaxBytes: 10, Overflow: Block})
+
+	assert.Nil(t, queue.Push([]byte("12345")))
+	assert.Nil(t, queue.Push([]byte("1234")))
+
+	pushed := make(chan error)
+
+	go func() { pushed <- queue.Push([]byte("123")) }()
+
+	select {
+	case <-pushed:
+		t.Fatal("push to the full queue should block")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	queue.Release(<-queue.Read())
+
+	assert.Nil(t, <-pushed)
+	assert.Equal(t, int64(7), queue.Size())
+}
+
+func TestQueueDropOldestConcurrentRead(t *testing.T) {
+	queue := NewQueueWithConfig(QueueConfig{Capacity: 1, Overflow: DropOldest})
+
+	const count = 10000
+
+	done := make(chan int)
+
+	go func() {
+		var read int
+
+		for range queue.Read() {
+			read++
+		}
+
+		done <- read
+	}()
+
+	for i := 0; i < count; i++ {
+		assert.Nil(t, queue.Push([]byte("1")))
+	}
+
+	queue.Close()
+
+	assert.Equal(t, count, <-done+int(queue.Dropped()))
+}
+
+func TestQueueDropOldestInFlight(t *testing.T) {
+	queue := NewQueueWithConfig(QueueConfig{Capacity: 10, MaxBytes: 10, Overflow: DropOldest})
+
+	assert.Nil(t, queue.Push([]byte("12345678")))
+
+	data := <-queue.Read()
+
+	assert.EqualError(t, queue.Push([]byte("12345")), ErrIsFull.Error())
+	assert.Equal(t, int64(1), queue.Dropped())
+
+	queue.Release(data)
+
+	assert.Nil(t, queue.Push([]byte("12345")))
+}
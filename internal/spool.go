@@ -0,0 +1,701 @@
+package internal
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/loghole/lhw/metrics"
+)
+
+const (
+	segmentExt = ".seg"
+	cursorFile = "cursor"
+	lockFile   = "lock"
+
+	recordHeaderSize = 8  // length(4) + crc32(4)
+	cursorSize       = 20 // segment id(8) + offset(8) + crc32(4)
+)
+
+var (
+	ErrIsCorrupted = errors.New("is corrupted")
+	ErrIsLocked    = errors.New("is locked by another spool")
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+type SyncPolicy int
+
+const (
+	// SyncNever leaves flushing of written data to the os.
+	SyncNever SyncPolicy = iota
+	// SyncAlways calls fsync after each pushed entry.
+	SyncAlways
+	// SyncInterval calls fsync periodically.
+	SyncInterval
+)
+
+type SpoolConfig struct {
+	Dir          string
+	MaxBytes     int64
+	SegmentSize  int64
+	SyncPolicy   SyncPolicy
+	SyncInterval time.Duration
+	Metrics      *metrics.Metrics
+}
+
+type segment struct {
+	id     uint64
+	path   string
+	size   int64
+	count  int
+	writer *os.File
+
+	// start is an offset the segment is read from, records before it are acknowledged.
+	start int64
+	// unacked is a count of read records which are not acknowledged yet.
+	unacked int
+	// read is set when all records of the sealed segment are read.
+	read bool
+}
+
+// cursor is a position after the last acknowledged record.
+type cursor struct {
+	id     uint64
+	offset int64
+}
+
+// inflight is a read record waiting for acknowledgement.
+type inflight struct {
+	seg   *segment
+	end   int64
+	acked bool
+}
+
+// Spool is a disk-backed queue. Entries are appended to segment files
+// and every record is protected with a checksum. Read entries are kept
+// on disk until they are acknowledged with Ack, position after the last
+// acknowledged entry is stored in the cursor file, so entries which were
+// not acknowledged are replayed by a spool opened with the same directory.
+// Segments are removed after all their entries are acknowledged.
+// MaxBytes limits unread data, read part of the segment is kept on disk
+// until all its entries are acknowledged, so disk usage may exceed it.
+// The directory is locked, so only one spool uses it at a time.
+type Spool struct {
+	config SpoolConfig
+	lock   *os.File
+
+	ch     chan []byte
+	notify Signal
+	done   chan struct{}
+
+	// stop ends reading before all entries are read, stopped is closed when the reader exits.
+	stop     chan struct{}
+	stopped  chan struct{}
+	stopOnce sync.Once
+
+	mu       sync.Mutex
+	segments []*segment
+	active   *segment
+	nextID   uint64
+	size     int64
+	pending  int64
+	count    int
+	dirty    bool
+	closed   bool
+	sn       sync.Once
+
+	// inflight are read records in the order of Read, the first one has sequence number acked.
+	inflight []inflight
+	acked    uint64
+	cursor   cursor
+}
+
+func NewSpool(config SpoolConfig) (*Spool, error) {
+	if err := os.MkdirAll(config.Dir, 0o750); err != nil {
+		return nil, err
+	}
+
+	lock, err := lockDir(config.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	spool := &Spool{
+		config:  config,
+		lock:    lock,
+		ch:      make(chan []byte),
+		notify:  make(Signal, 1),
+		done:    make(chan struct{}),
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+
+	if err := spool.load(); err != nil {
+		unlockDir(lock)
+
+		return nil, err
+	}
+
+	go spool.reader()
+
+	if config.SyncPolicy == SyncInterval && config.SyncInterval > 0 {
+		go spool.syncer()
+	}
+
+	return spool, nil
+}
+
+func (s *Spool) Push(data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrIsClosed
+	}
+
+	record := int64(recordHeaderSize + len(data))
+
+	if s.pending+record > s.config.MaxBytes {
+		s.config.Metrics.QueueDropped(DropNewest.String())
+
+		return ErrIsFull
+	}
+
+	if s.active == nil || s.active.size >= s.config.SegmentSize {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+
+	buf := make([]byte, record)
+
+	binary.BigEndian.PutUint32(buf[0:4], uint32(len(data)))
+	binary.BigEndian.PutUint32(buf[4:8], crc32.Checksum(data, crcTable))
+	copy(buf[recordHeaderSize:], data)
+
+	if _, err := s.active.writer.Write(buf); err != nil {
+		// Drop partially written record.
+		_ = s.active.writer.Truncate(s.active.size)
+
+		return err
+	}
+
+	if s.config.SyncPolicy == SyncAlways {
+		if err := s.active.writer.Sync(); err != nil {
+			return err
+		}
+	}
+
+	s.active.size += record
+	s.active.count++
+	s.size += record
+	s.pending += record
+	s.count++
+	s.dirty = true
+
+	s.config.Metrics.QueueState(s.count, s.pending)
+	s.notify.Send()
+
+	return nil
+}
+
+func (s *Spool) Read() <-chan []byte {
+	return s.ch
+}
+
+// Release does nothing, spool frees space when the entry is read.
+func (s *Spool) Release([]byte) {}
+
+// Ack acknowledges count entries starting from the sequence number of the
+// first one, entries are numbered from 0 in the order of Read. The cursor
+// moves over acknowledged entries only when all entries before them are
+// acknowledged too, segments behind the cursor are removed.
+func (s *Spool) Ack(first uint64, count int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for seq := first; seq < first+uint64(count); seq++ {
+		if seq >= s.acked && seq-s.acked < uint64(len(s.inflight)) {
+			s.inflight[seq-s.acked].acked = true
+		}
+	}
+
+	var moved bool
+
+	for len(s.inflight) > 0 && s.inflight[0].acked {
+		rec := s.inflight[0]
+
+		rec.seg.unacked--
+		s.cursor = cursor{id: rec.seg.id, offset: rec.end}
+		s.inflight[0] = inflight{}
+		s.inflight = s.inflight[1:]
+		s.acked++
+
+		moved = true
+	}
+
+	if moved {
+		// Failed cursor write leads to duplicates after restart, not to lost entries.
+		_ = s.saveCursor()
+	}
+
+	s.trim()
+}
+
+// Size returns count of unread bytes stored on disk.
+func (s *Spool) Size() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.pending
+}
+
+// Len returns count of unread entries stored on disk.
+func (s *Spool) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.count
+}
+
+// Close stops accepting new entries. The read channel is closed
+// after all stored entries are read or the spool is stopped.
+func (s *Spool) Close() {
+	s.sn.Do(s.close)
+}
+
+// Stop closes the spool and stops reading, entries which are not read
+// or acknowledged are kept on disk. It waits until the reader exits
+// and unlocks the directory.
+func (s *Spool) Stop() {
+	s.Close()
+
+	s.stopOnce.Do(func() {
+		close(s.stop)
+		<-s.stopped
+
+		unlockDir(s.lock)
+	})
+}
+
+func (s *Spool) close() {
+	s.mu.Lock()
+	s.closed = true
+	s.seal()
+	s.mu.Unlock()
+
+	close(s.done)
+	s.notify.Send()
+}
+
+// load restores segments left by previous spool in the same directory.
+func (s *Spool) load() error {
+	files, err := ioutil.ReadDir(s.config.Dir)
+	if err != nil {
+		return err
+	}
+
+	s.cursor = readCursor(filepath.Join(s.config.Dir, cursorFile))
+
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), segmentExt) {
+			continue
+		}
+
+		id, err := strconv.ParseUint(strings.TrimSuffix(file.Name(), segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		path := filepath.Join(s.config.Dir, file.Name())
+
+		if id >= s.nextID {
+			s.nextID = id + 1
+		}
+
+		// Segments behind the cursor are acknowledged, they were not removed before exit.
+		if id < s.cursor.id {
+			_ = os.Remove(path)
+
+			continue
+		}
+
+		seg := &segment{id: id, path: path, size: file.Size()}
+
+		if id == s.cursor.id && s.cursor.offset <= seg.size {
+			seg.start = s.cursor.offset
+		}
+
+		seg.count, err = countRecords(seg.path, seg.start)
+		if err != nil {
+			return err
+		}
+
+		s.segments = append(s.segments, seg)
+		s.size += seg.size
+		s.pending += seg.size - seg.start
+		s.count += seg.count
+	}
+
+	// Segments are numbered after the cursor, so they are not taken for acknowledged ones.
+	if s.cursor.id >= s.nextID {
+		s.nextID = s.cursor.id + 1
+	}
+
+	sort.Slice(s.segments, func(i, j int) bool {
+		return s.segments[i].id < s.segments[j].id
+	})
+
+	s.config.Metrics.QueueState(s.count, s.pending)
+
+	return nil
+}
+
+// rotate seals active segment and creates a new one.
+func (s *Spool) rotate() error {
+	s.seal()
+
+	path := filepath.Join(s.config.Dir, fmt.Sprintf("%020d%s", s.nextID, segmentExt))
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+	if err != nil {
+		return err
+	}
+
+	s.active = &segment{id: s.nextID, path: path, writer: file}
+	s.segments = append(s.segments, s.active)
+	s.nextID++
+
+	return nil
+}
+
+func (s *Spool) seal() {
+	if s.active == nil {
+		return
+	}
+
+	if s.dirty && s.config.SyncPolicy != SyncNever {
+		_ = s.active.writer.Sync()
+	}
+
+	_ = s.active.writer.Close()
+
+	s.active = nil
+	s.dirty = false
+}
+
+func (s *Spool) syncer() {
+	ticker := time.NewTicker(s.config.SyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			s.mu.Lock()
+
+			if s.active != nil && s.dirty {
+				_ = s.active.writer.Sync()
+				s.dirty = false
+			}
+
+			s.mu.Unlock()
+		}
+	}
+}
+
+func (s *Spool) reader() {
+	defer close(s.stopped)
+	defer close(s.ch)
+
+	for {
+		s.mu.Lock()
+
+		seg := s.unread()
+		if seg == nil {
+			closed := s.closed
+			s.mu.Unlock()
+
+			if closed {
+				return
+			}
+
+			select {
+			case <-s.notify:
+			case <-s.stop:
+				return
+			}
+
+			continue
+		}
+
+		s.mu.Unlock()
+
+		if !s.readSegment(seg) {
+			return
+		}
+	}
+}
+
+// unread returns the first segment which is not read to the end.
+func (s *Spool) unread() *segment {
+	for _, seg := range s.segments {
+		if !seg.read {
+			return seg
+		}
+	}
+
+	return nil
+}
+
+// readSegment sends all segment entries to the read channel,
+// corrupted tail of the segment is skipped. It reports false
+// if the spool is stopped.
+func (s *Spool) readSegment(seg *segment) bool {
+	file, err := os.Open(seg.path)
+	if err != nil {
+		s.skip(seg, seg.start, 0)
+
+		return true
+	}
+
+	defer file.Close()
+
+	var (
+		offset = seg.start
+		read   int
+	)
+
+	for {
+		s.mu.Lock()
+		size, active := seg.size, seg == s.active
+		s.mu.Unlock()
+
+		if offset < size {
+			data, err := readRecord(file, offset, size)
+			if err != nil {
+				s.skip(seg, offset, read)
+
+				return true
+			}
+
+			offset += int64(recordHeaderSize + len(data))
+			read++
+
+			// The record waits for acknowledgement before it is sent,
+			// so the receiver can acknowledge it at once.
+			s.track(seg, offset)
+
+			// The stopped record is not acknowledged and stays on disk.
+			select {
+			case s.ch <- data:
+			case <-s.stop:
+				return false
+			}
+
+			s.consume(int64(recordHeaderSize + len(data)))
+
+			continue
+		}
+
+		if active {
+			select {
+			case <-s.notify:
+			case <-s.stop:
+				return false
+			}
+
+			continue
+		}
+
+		s.mu.Lock()
+		seg.read = true
+		s.trim()
+		s.mu.Unlock()
+
+		return true
+	}
+}
+
+// skip discards unreadable data of the segment after offset.
+func (s *Spool) skip(seg *segment, offset int64, read int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if seg == s.active {
+		s.seal()
+	}
+
+	seg.read = true
+	s.pending -= seg.size - offset
+	s.count -= seg.count - read
+	s.config.Metrics.QueueState(s.count, s.pending)
+
+	s.trim()
+}
+
+// track adds the record ending at offset to records waiting for acknowledgement.
+func (s *Spool) track(seg *segment, offset int64) {
+	s.mu.Lock()
+	s.inflight = append(s.inflight, inflight{seg: seg, end: offset})
+	seg.unacked++
+	s.mu.Unlock()
+}
+
+func (s *Spool) consume(n int64) {
+	s.mu.Lock()
+	s.pending -= n
+	s.count--
+	s.config.Metrics.QueueState(s.count, s.pending)
+	s.mu.Unlock()
+}
+
+// trim removes leading segments which are read and acknowledged.
+func (s *Spool) trim() {
+	for len(s.segments) > 0 {
+		seg := s.segments[0]
+
+		if !seg.read || seg.unacked > 0 {
+			return
+		}
+
+		s.segments = s.segments[1:]
+		s.size -= seg.size
+
+		_ = os.Remove(seg.path)
+	}
+}
+
+func lockPath(dir string) string {
+	return filepath.Join(dir, lockFile)
+}
+
+// saveCursor replaces the cursor file with the current cursor.
+func (s *Spool) saveCursor() error {
+	buf := make([]byte, cursorSize)
+
+	binary.BigEndian.PutUint64(buf[0:8], s.cursor.id)
+	binary.BigEndian.PutUint64(buf[8:16], uint64(s.cursor.offset))
+	binary.BigEndian.PutUint32(buf[16:20], crc32.Checksum(buf[:16], crcTable))
+
+	path := filepath.Join(s.config.Dir, cursorFile)
+
+	file, err := os.OpenFile(path+".tmp", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o640)
+	if err != nil {
+		return err
+	}
+
+	if _, err := file.Write(buf); err != nil {
+		file.Close()
+
+		return err
+	}
+
+	if s.config.SyncPolicy == SyncAlways {
+		if err := file.Sync(); err != nil {
+			file.Close()
+
+			return err
+		}
+	}
+
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(path+".tmp", path)
+}
+
+// readCursor returns the stored cursor, missing or corrupted
+// cursor file means that all segments are replayed.
+func readCursor(path string) cursor {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil || len(buf) != cursorSize {
+		return cursor{}
+	}
+
+	if crc32.Checksum(buf[:16], crcTable) != binary.BigEndian.Uint32(buf[16:20]) {
+		return cursor{}
+	}
+
+	return cursor{
+		id:     binary.BigEndian.Uint64(buf[0:8]),
+		offset: int64(binary.BigEndian.Uint64(buf[8:16])),
+	}
+}
+
+// countRecords returns count of valid records of the segment after offset.
+func countRecords(path string, offset int64) (count int, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+
+	defer file.Close()
+
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	var (
+		reader = bufio.NewReader(file)
+		header = make([]byte, recordHeaderSize)
+	)
+
+	for {
+		if _, err := io.ReadFull(reader, header); err != nil {
+			return count, nil
+		}
+
+		length := int(binary.BigEndian.Uint32(header[0:4]))
+
+		if n, _ := reader.Discard(length); n < length {
+			return count, nil
+		}
+
+		count++
+	}
+}
+
+func readRecord(file *os.File, offset, size int64) ([]byte, error) {
+	if offset+recordHeaderSize > size {
+		return nil, ErrIsCorrupted
+	}
+
+	header := make([]byte, recordHeaderSize)
+
+	if _, err := file.ReadAt(header, offset); err != nil {
+		return nil, err
+	}
+
+	length := int64(binary.BigEndian.Uint32(header[0:4]))
+
+	if offset+recordHeaderSize+length > size {
+		return nil, ErrIsCorrupted
+	}
+
+	data := make([]byte, length)
+
+	if _, err := file.ReadAt(data, offset+recordHeaderSize); err != nil {
+		return nil, err
+	}
+
+	if crc32.Checksum(data, crcTable) != binary.BigEndian.Uint32(header[4:8]) {
+		return nil, ErrIsCorrupted
+	}
+
+	return data, nil
+}
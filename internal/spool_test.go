@@ -0,0 +1,287 @@
+package internal
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSpool(t *testing.T) {
+	dir := t.TempDir()
+
+	spool, err := NewSpool(SpoolConfig{Dir: dir, MaxBytes: 30, SegmentSize: 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = spool.Push([]byte("1"))
+	assert.Nil(t, err, "expected nil error")
+
+	err = spool.Push([]byte("2"))
+	assert.Nil(t, err, "expected nil error")
+
+	err = spool.Push([]byte("3"))
+	assert.Nil(t, err, "expected nil error")
+
+	err = spool.Push([]byte("4"))
+	assert.EqualError(t, err, ErrIsFull.Error())
+
+	assert.Equal(t, int64(27), spool.Size())
+	assert.Equal(t, 3, spool.Len())
+
+	assert.Equal(t, []byte("1"), <-spool.Read())
+	assert.Equal(t, []byte("2"), <-spool.Read())
+
+	assert.Eventually(t, func() bool {
+		return spool.Push([]byte("4")) == nil
+	}, time.Second, time.Millisecond)
+
+	spool.Close()
+
+	err = spool.Push([]byte("5"))
+	assert.EqualError(t, err, ErrIsClosed.Error())
+
+	assert.Equal(t, []byte("3"), <-spool.Read())
+	assert.Equal(t, []byte("4"), <-spool.Read())
+	assert.Equal(t, []byte(nil), <-spool.Read())
+
+	assert.Equal(t, int64(0), spool.Size())
+	assert.Equal(t, 0, spool.Len())
+	assert.NotEmpty(t, segmentFiles(t, dir), "segments should be kept until entries are acknowledged")
+
+	spool.Ack(0, 4)
+
+	assert.Empty(t, segmentFiles(t, dir), "segments should be removed")
+}
+
+func TestSpoolReplay(t *testing.T) {
+	dir := t.TempDir()
+
+	spool, err := NewSpool(SpoolConfig{Dir: dir, MaxBytes: 1 << 20, SegmentSize: 1 << 20, SyncPolicy: SyncAlways})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Nil(t, spool.Push([]byte("1")))
+	assert.Nil(t, spool.Push([]byte("2")))
+	assert.Nil(t, spool.Push([]byte("3")))
+
+	// The writer is aborted before the read entry is acknowledged.
+	assert.Equal(t, []byte("1"), <-spool.Read())
+
+	spool.Stop()
+
+	spool, err = NewSpool(SpoolConfig{Dir: dir, MaxBytes: 1 << 20, SegmentSize: 1 << 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Nil(t, spool.Push([]byte("4")))
+
+	spool.Close()
+
+	var res []string
+
+	for data := range spool.Read() {
+		res = append(res, string(data))
+	}
+
+	assert.Equal(t, []string{"1", "2", "3", "4"}, res)
+
+	spool.Ack(0, len(res))
+
+	assert.Empty(t, segmentFiles(t, dir), "segments should be removed")
+}
+
+func TestSpoolAck(t *testing.T) {
+	dir := t.TempDir()
+	config := SpoolConfig{Dir: dir, MaxBytes: 1 << 20, SegmentSize: 20, SyncPolicy: SyncAlways}
+
+	spool, err := NewSpool(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, data := range []string{"1", "2", "3", "4", "5"} {
+		assert.Nil(t, spool.Push([]byte(data)))
+	}
+
+	for i := 0; i < 5; i++ {
+		<-spool.Read()
+	}
+
+	// Entries acknowledged out of order wait for the previous ones.
+	spool.Ack(1, 2)
+
+	assert.Equal(t, []string{"1", "2", "3", "4", "5"}, replay(t, config))
+
+	spool.Ack(0, 1)
+
+	assert.Equal(t, []string{"4", "5"}, replay(t, config))
+	assert.Len(t, segmentFiles(t, dir), 1, "acknowledged segment should be removed")
+
+	spool.Ack(3, 2)
+
+	assert.Empty(t, replay(t, config))
+}
+
+// replay returns entries replayed by a spool opened with the config.
+// replay returns entries read by a new spool from the copy of the directory,
+// the directory itself is locked by the spool under test.
+func replay(t *testing.T, config SpoolConfig) []string {
+	t.Helper()
+
+	files, err := ioutil.ReadDir(config.Dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+
+	for _, file := range files {
+		if file.Name() == lockFile {
+			continue
+		}
+
+		data, err := ioutil.ReadFile(filepath.Join(config.Dir, file.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := ioutil.WriteFile(filepath.Join(dir, file.Name()), data, 0o640); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	config.Dir = dir
+
+	spool, err := NewSpool(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer spool.Stop()
+
+	spool.Close()
+
+	var res []string
+
+	for data := range spool.Read() {
+		res = append(res, string(data))
+	}
+
+	return res
+}
+
+func TestSpoolCorrupted(t *testing.T) {
+	dir := t.TempDir()
+
+	spool, err := NewSpool(SpoolConfig{Dir: dir, MaxBytes: 1 << 20, SegmentSize: 1 << 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Nil(t, spool.Push([]byte("first")))
+	assert.Nil(t, spool.Push([]byte("second")))
+	assert.Nil(t, spool.Push([]byte("third")))
+
+	files := segmentFiles(t, dir)
+	if len(files) != 1 {
+		t.Fatalf("expected one segment, got %d", len(files))
+	}
+
+	// Corrupt data of the second record.
+	data, err := ioutil.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data[recordHeaderSize*2+len("first")] = 'S'
+
+	dir2 := t.TempDir()
+
+	if err := ioutil.WriteFile(filepath.Join(dir2, filepath.Base(files[0])), data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	spool, err = NewSpool(SpoolConfig{Dir: dir2, MaxBytes: 1 << 20, SegmentSize: 1 << 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, 3, spool.Len())
+
+	spool.Close()
+
+	var res []string
+
+	for data := range spool.Read() {
+		res = append(res, string(data))
+	}
+
+	assert.Equal(t, []string{"first"}, res)
+	assert.Equal(t, 0, spool.Len())
+	assert.Equal(t, int64(0), spool.Size())
+}
+
+func segmentFiles(t *testing.T, dir string) []string {
+	t.Helper()
+
+	files, err := filepath.Glob(filepath.Join(dir, "*"+segmentExt))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return files
+}
+
+func TestSpoolStop(t *testing.T) {
+	config := SpoolConfig{Dir: t.TempDir(), MaxBytes: 1 << 20, SegmentSize: 1 << 20}
+
+	spool, err := NewSpool(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Nil(t, spool.Push([]byte("1")))
+	assert.Nil(t, spool.Push([]byte("2")))
+
+	// The reader blocked on sending exits and unread entries stay on disk.
+	assert.Equal(t, []byte("1"), <-spool.Read())
+
+	spool.Stop()
+
+	_, ok := <-spool.Read()
+	assert.False(t, ok)
+
+	spool, err = NewSpool(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer spool.Stop()
+
+	assert.Equal(t, 2, spool.Len())
+}
+
+func TestSpoolLocked(t *testing.T) {
+	config := SpoolConfig{Dir: t.TempDir(), MaxBytes: 1 << 20, SegmentSize: 1 << 20}
+
+	spool, err := NewSpool(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = NewSpool(config)
+	assert.ErrorIs(t, err, ErrIsLocked)
+
+	spool.Stop()
+
+	spool, err = NewSpool(config)
+	assert.Nil(t, err)
+
+	spool.Stop()
+}
@@ -0,0 +1,86 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"unicode/utf8"
+)
+
+const truncateMarker = "..."
+
+// Truncate returns copy of the entry shortened to max bytes. String fields of
+// json object are cut, the longest first, so the entry stays valid json.
+// Other data is cut as is.
+func Truncate(data []byte, max int) []byte {
+	if len(data) <= max {
+		return append([]byte{}, data...)
+	}
+
+	fields := make(map[string]interface{})
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	if err := decoder.Decode(&fields); err != nil {
+		return cut(data, max)
+	}
+
+	for {
+		encoded, err := encodeFields(fields)
+		if err != nil {
+			return cut(data, max)
+		}
+
+		excess := len(encoded) - max
+		if excess <= 0 {
+			return encoded
+		}
+
+		key, value := longestString(fields)
+		if len(value) <= len(truncateMarker) {
+			return cut(data, max)
+		}
+
+		fields[key] = cutString(value, len(value)-excess-len(truncateMarker)) + truncateMarker
+	}
+}
+
+func encodeFields(fields map[string]interface{}) ([]byte, error) {
+	buf := &bytes.Buffer{}
+
+	encoder := json.NewEncoder(buf)
+	encoder.SetEscapeHTML(false)
+
+	if err := encoder.Encode(fields); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func longestString(fields map[string]interface{}) (key, value string) {
+	for k, v := range fields {
+		if s, ok := v.(string); ok && (len(s) > len(value) || (len(s) == len(value) && k < key)) {
+			key, value = k, s
+		}
+	}
+
+	return key, value
+}
+
+// cutString cuts the string to size bytes without breaking utf-8 runes.
+func cutString(s string, size int) string {
+	if size <= 0 {
+		return ""
+	}
+
+	for size > 0 && !utf8.RuneStart(s[size]) {
+		size--
+	}
+
+	return s[:size]
+}
+
+func cut(data []byte, max int) []byte {
+	return append([]byte{}, data[:max]...)
+}
@@ -0,0 +1,61 @@
+package internal
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		name        string
+		input       string
+		max         int
+		expectedRes string
+	}{
+		{
+			name:        "Small",
+			input:       `{"message":"msg"}`,
+			max:         100,
+			expectedRes: `{"message":"msg"}`,
+		},
+		{
+			name:        "LongestField",
+			input:       `{"level":"error","message":"error message","stacktrace":"` + strings.Repeat("a", 100) + `"}` + "\n",
+			max:         80,
+			expectedRes: `{"level":"error","message":"error message","stacktrace":"aaaaaaaaaaaaaaaaa..."}` + "\n",
+		},
+		{
+			name:        "SeveralFields",
+			input:       `{"a":"` + strings.Repeat("a", 30) + `","b":"` + strings.Repeat("b", 30) + `"}`,
+			max:         40,
+			expectedRes: `{"a":"...","b":"bbbbbbbbbbbbbbbbbb..."}` + "\n",
+		},
+		{
+			name:        "UTF8",
+			input:       `{"message":"` + strings.Repeat("ж", 20) + `"}`,
+			max:         30,
+			expectedRes: `{"message":"жжжжжж..."}` + "\n",
+		},
+		{
+			name:        "NotJSON",
+			input:       strings.Repeat("a", 20),
+			max:         10,
+			expectedRes: strings.Repeat("a", 10),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := Truncate([]byte(tt.input), tt.max)
+
+			assert.Equal(t, tt.expectedRes, string(res))
+			assert.LessOrEqual(t, len(res), tt.max)
+
+			if strings.HasPrefix(tt.input, "{") {
+				assert.True(t, json.Valid(res), "truncated entry should be valid json")
+			}
+		})
+	}
+}
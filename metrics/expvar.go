@@ -0,0 +1,110 @@
+package metrics
+
+import (
+	"expvar"
+	"strings"
+)
+
+// Expvar registry publishes metrics as expvar map. Labeled values are stored
+// under keys like name{node="http://127.0.0.1:50000"}, histograms are
+// exposed as _count and _sum values.
+type Expvar struct {
+	vars *expvar.Map
+}
+
+// NewExpvar publishes expvar map with the name or reuses the published one.
+func NewExpvar(name string) *Expvar {
+	if vars, ok := expvar.Get(name).(*expvar.Map); ok {
+		return &Expvar{vars: vars}
+	}
+
+	return &Expvar{vars: expvar.NewMap(name)}
+}
+
+func (e *Expvar) Counter(name, _ string, labels ...string) Counter {
+	return &expvarCounter{vars: e.vars, name: name, labels: labels}
+}
+
+func (e *Expvar) Gauge(name, _ string, labels ...string) Gauge {
+	return &expvarGauge{vars: e.vars, name: name, labels: labels}
+}
+
+func (e *Expvar) Histogram(name, _ string, _ []float64, labels ...string) Histogram {
+	return &expvarHistogram{vars: e.vars, name: name, labels: labels}
+}
+
+type expvarCounter struct {
+	vars   *expvar.Map
+	name   string
+	labels []string
+}
+
+func (c *expvarCounter) Add(value float64, labelValues ...string) {
+	c.vars.AddFloat(expvarKey(c.name, c.labels, labelValues), value)
+}
+
+type expvarGauge struct {
+	vars   *expvar.Map
+	name   string
+	labels []string
+}
+
+func (g *expvarGauge) Set(value float64, labelValues ...string) {
+	key := expvarKey(g.name, g.labels, labelValues)
+
+	if v, ok := g.vars.Get(key).(*expvar.Float); ok {
+		v.Set(value)
+
+		return
+	}
+
+	v := new(expvar.Float)
+	v.Set(value)
+
+	g.vars.Set(key, v)
+}
+
+func (g *expvarGauge) Delete(labelValues ...string) {
+	g.vars.Delete(expvarKey(g.name, g.labels, labelValues))
+}
+
+type expvarHistogram struct {
+	vars   *expvar.Map
+	name   string
+	labels []string
+}
+
+func (h *expvarHistogram) Observe(value float64, labelValues ...string) {
+	h.vars.AddFloat(expvarKey(h.name+"_count", h.labels, labelValues), 1)
+	h.vars.AddFloat(expvarKey(h.name+"_sum", h.labels, labelValues), value)
+}
+
+func expvarKey(name string, labels, values []string) string {
+	if len(labels) == 0 {
+		return name
+	}
+
+	var b strings.Builder
+
+	b.WriteString(name)
+	b.WriteByte('{')
+
+	for idx, label := range labels {
+		if idx > 0 {
+			b.WriteByte(',')
+		}
+
+		b.WriteString(label)
+		b.WriteString(`="`)
+
+		if idx < len(values) {
+			b.WriteString(values[idx])
+		}
+
+		b.WriteByte('"')
+	}
+
+	b.WriteByte('}')
+
+	return b.String()
+}
@@ -0,0 +1,241 @@
+// Package metrics provides instruments of the writer, queue and transport
+// with a neutral interface for metrics backends.
+package metrics
+
+import "time"
+
+// Registry creates instruments, it is implemented by metrics backends.
+type Registry interface {
+	Counter(name, help string, labels ...string) Counter
+	Gauge(name, help string, labels ...string) Gauge
+	Histogram(name, help string, buckets []float64, labels ...string) Histogram
+}
+
+// Counter is a monotonically increasing value.
+type Counter interface {
+	Add(value float64, labelValues ...string)
+}
+
+// Gauge is a value that can go up and down.
+type Gauge interface {
+	Set(value float64, labelValues ...string)
+}
+
+// Deleter is implemented by instruments which can remove values of labels,
+// values of removed nodes are deleted instead of being kept with zero.
+type Deleter interface {
+	Delete(labelValues ...string)
+}
+
+// Histogram samples observations into buckets.
+type Histogram interface {
+	Observe(value float64, labelValues ...string)
+}
+
+// Label names.
+const (
+	LabelNode   = "node"
+	LabelCode   = "code"
+	LabelReason = "reason"
+	LabelPolicy = "policy"
+)
+
+// Drop reasons.
+const (
+	ReasonQueueFull  = "queue_full"
+	ReasonTooLarge   = "too_large"
+	ReasonSendFailed = "send_failed"
+	ReasonAborted    = "aborted"
+	ReasonRejected   = "rejected"
+)
+
+var (
+	SizeBuckets     = []float64{1, 5, 10, 50, 100, 500, 1000, 5000}
+	DurationBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+)
+
+// Metrics records events of the writer, queue and transport.
+// All methods of nil Metrics do nothing.
+type Metrics struct {
+	entriesWritten   Counter
+	entriesDelivered Counter
+	entriesDropped   Counter
+	entriesDiverted  Counter
+
+	queueDepth   Gauge
+	queueBytes   Gauge
+	queueDropped Counter
+
+	batchSize       Histogram
+	requests        Counter
+	requestDuration Histogram
+	requestBytes    Counter
+	retries         Counter
+	nodeUp          Gauge
+}
+
+// New creates instruments in the registry, nil registry discards all metrics.
+func New(registry Registry) *Metrics {
+	if registry == nil {
+		registry = Nop{}
+	}
+
+	return &Metrics{
+		entriesWritten: registry.Counter("lhw_entries_written_total",
+			"Count of entries written to the queue."),
+		entriesDelivered: registry.Counter("lhw_entries_delivered_total",
+			"Count of entries delivered to the collector."),
+		entriesDropped: registry.Counter("lhw_entries_dropped_total",
+			"Count of entries dropped by the writer.", LabelReason),
+		entriesDiverted: registry.Counter("lhw_entries_diverted_total",
+			"Count of entries diverted to the fallback sink."),
+
+		queueDepth: registry.Gauge("lhw_queue_depth",
+			"Count of entries in the queue."),
+		queueBytes: registry.Gauge("lhw_queue_bytes",
+			"Total size of entries in the queue."),
+		queueDropped: registry.Counter("lhw_queue_dropped_total",
+			"Count of entries dropped by the queue overflow policy.", LabelPolicy),
+
+		batchSize: registry.Histogram("lhw_batch_size",
+			"Count of entries in sent batches.", SizeBuckets),
+		requests: registry.Counter("lhw_requests_total",
+			"Count of requests to collector nodes.", LabelNode, LabelCode),
+		requestDuration: registry.Histogram("lhw_request_duration_seconds",
+			"Duration of requests to collector nodes.", DurationBuckets, LabelNode),
+		requestBytes: registry.Counter("lhw_request_bytes_total",
+			"Count of request body bytes sent to collector nodes.", LabelNode),
+		retries: registry.Counter("lhw_retries_total",
+			"Count of retried sends."),
+		nodeUp: registry.Gauge("lhw_node_up",
+			"Status of collector node, 1 if node is live.", LabelNode),
+	}
+}
+
+// Written records entries accepted by the writer.
+func (m *Metrics) Written(count int) {
+	if m == nil {
+		return
+	}
+
+	m.entriesWritten.Add(float64(count))
+}
+
+// Delivered records successfully sent batch.
+func (m *Metrics) Delivered(count int) {
+	if m == nil {
+		return
+	}
+
+	m.entriesDelivered.Add(float64(count))
+	m.batchSize.Observe(float64(count))
+}
+
+// Dropped records entries lost for the reason.
+func (m *Metrics) Dropped(reason string, count int) {
+	if m == nil {
+		return
+	}
+
+	m.entriesDropped.Add(float64(count), reason)
+}
+
+// Diverted records entries written to the fallback sink.
+func (m *Metrics) Diverted(count int) {
+	if m == nil {
+		return
+	}
+
+	m.entriesDiverted.Add(float64(count))
+}
+
+// QueueState records count and total size of queued entries.
+func (m *Metrics) QueueState(depth int, bytes int64) {
+	if m == nil {
+		return
+	}
+
+	m.queueDepth.Set(float64(depth))
+	m.queueBytes.Set(float64(bytes))
+}
+
+// QueueDropped records entry dropped by the overflow policy.
+func (m *Metrics) QueueDropped(policy string) {
+	if m == nil {
+		return
+	}
+
+	m.queueDropped.Add(1, policy)
+}
+
+// Request records finished request to the node, code is "error"
+// if the request failed without response.
+func (m *Metrics) Request(node, code string, duration time.Duration, bytes int) {
+	if m == nil {
+		return
+	}
+
+	m.requests.Add(1, node, code)
+	m.requestDuration.Observe(duration.Seconds(), node)
+	m.requestBytes.Add(float64(bytes), node)
+}
+
+// Retry records repeated send attempt.
+func (m *Metrics) Retry() {
+	if m == nil {
+		return
+	}
+
+	m.retries.Add(1)
+}
+
+// NodeStatus records node liveness.
+func (m *Metrics) NodeStatus(node string, up bool) {
+	if m == nil {
+		return
+	}
+
+	if up {
+		m.nodeUp.Set(1, node)
+	} else {
+		m.nodeUp.Set(0, node)
+	}
+}
+
+// NodeRemoved removes liveness of the node which is no longer used.
+func (m *Metrics) NodeRemoved(node string) {
+	if m == nil {
+		return
+	}
+
+	if deleter, ok := m.nodeUp.(Deleter); ok {
+		deleter.Delete(node)
+
+		return
+	}
+
+	m.nodeUp.Set(0, node)
+}
+
+// Nop registry creates instruments which discard values.
+type Nop struct{}
+
+func (Nop) Counter(string, string, ...string) Counter {
+	return nop{}
+}
+
+func (Nop) Gauge(string, string, ...string) Gauge {
+	return nop{}
+}
+
+func (Nop) Histogram(string, string, []float64, ...string) Histogram {
+	return nop{}
+}
+
+type nop struct{}
+
+func (nop) Add(float64, ...string) {}
+
+func (nop) Set(float64, ...string) {}
+
+func (nop) Observe(float64, ...string) {}
@@ -0,0 +1,93 @@
+package metrics
+
+import (
+	"expvar"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetrics(t *testing.T) {
+	registry := newTestExpvar()
+	m := New(registry)
+
+	m.Written(3)
+	m.Delivered(2)
+	m.Dropped(ReasonQueueFull, 1)
+	m.QueueState(1, 10)
+	m.QueueDropped("drop_oldest")
+	m.Request("http://127.0.0.1:50000", "200", time.Second, 100)
+	m.Retry()
+	m.NodeStatus("http://127.0.0.1:50000", false)
+
+	tests := []struct {
+		key         string
+		expectedRes string
+	}{
+		{key: "lhw_entries_written_total", expectedRes: "3"},
+		{key: "lhw_entries_delivered_total", expectedRes: "2"},
+		{key: `lhw_entries_dropped_total{reason="queue_full"}`, expectedRes: "1"},
+		{key: "lhw_queue_depth", expectedRes: "1"},
+		{key: "lhw_queue_bytes", expectedRes: "10"},
+		{key: `lhw_queue_dropped_total{policy="drop_oldest"}`, expectedRes: "1"},
+		{key: "lhw_batch_size_count", expectedRes: "1"},
+		{key: "lhw_batch_size_sum", expectedRes: "2"},
+		{key: `lhw_requests_total{node="http://127.0.0.1:50000",code="200"}`, expectedRes: "1"},
+		{key: `lhw_request_duration_seconds_sum{node="http://127.0.0.1:50000"}`, expectedRes: "1"},
+		{key: `lhw_request_bytes_total{node="http://127.0.0.1:50000"}`, expectedRes: "100"},
+		{key: "lhw_retries_total", expectedRes: "1"},
+		{key: `lhw_node_up{node="http://127.0.0.1:50000"}`, expectedRes: "0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			value := registry.vars.Get(tt.key)
+			if assert.NotNil(t, value) {
+				assert.Equal(t, tt.expectedRes, value.String())
+			}
+		})
+	}
+}
+
+func TestMetrics_Nil(t *testing.T) {
+	var m *Metrics
+
+	assert.NotPanics(t, func() {
+		m.Written(1)
+		m.Delivered(1)
+		m.Dropped(ReasonAborted, 1)
+		m.QueueState(1, 1)
+		m.QueueDropped("block")
+		m.Request("node", "200", time.Second, 1)
+		m.Retry()
+		m.NodeStatus("node", true)
+		m.NodeRemoved("node")
+	})
+
+	assert.NotPanics(t, func() {
+		New(nil).Written(1)
+	})
+}
+
+func TestMetrics_NodeRemoved(t *testing.T) {
+	registry := newTestExpvar()
+	m := New(registry)
+
+	m.NodeStatus("http://127.0.0.1:50000", true)
+	m.NodeRemoved("http://127.0.0.1:50000")
+
+	assert.Nil(t, registry.vars.Get(`lhw_node_up{node="http://127.0.0.1:50000"}`))
+}
+
+func TestNewExpvar(t *testing.T) {
+	registry := NewExpvar("lhw_expvar_test")
+
+	assert.Same(t, registry.vars, NewExpvar("lhw_expvar_test").vars)
+	assert.Same(t, registry.vars, expvar.Get("lhw_expvar_test"))
+}
+
+// newTestExpvar returns registry which is not published, so counters
+// start from zero on every test run.
+func newTestExpvar() *Expvar {
+	return &Expvar{vars: new(expvar.Map).Init()}
+}
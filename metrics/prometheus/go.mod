@@ -0,0 +1,13 @@
+module github.com/loghole/lhw/metrics/prometheus
+
+go 1.16
+
+require (
+	github.com/loghole/lhw v0.0.0-20261017074206-81547e57372f
+	github.com/prometheus/client_golang v1.11.0
+	github.com/stretchr/testify v1.7.0
+)
+
+// The replacement is ignored by consumers of the module, they use
+// the required version of github.com/loghole/lhw.
+replace github.com/loghole/lhw => ../../
@@ -0,0 +1,121 @@
+// Package prometheus adapts lhw metrics to prometheus client.
+// It is a separate module, so the root module does not depend on prometheus.
+package prometheus
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/loghole/lhw/metrics"
+)
+
+// Registry creates prometheus vectors for lhw instruments and implements
+// prometheus.Collector, it should be registered in prometheus registerer.
+// Writers sharing the registry share its instruments, use registries
+// with different const labels to tell writers apart.
+type Registry struct {
+	mu         sync.RWMutex
+	labels     prometheus.Labels
+	collectors map[string]prometheus.Collector
+}
+
+func NewRegistry() *Registry {
+	return NewRegistryWithLabels(nil)
+}
+
+// NewRegistryWithLabels returns registry which adds the const labels
+// to all instruments, e.g. prometheus.Labels{"writer": "audit"}.
+func NewRegistryWithLabels(labels prometheus.Labels) *Registry {
+	return &Registry{labels: labels, collectors: make(map[string]prometheus.Collector)}
+}
+
+func (r *Registry) Counter(name, help string, labels ...string) metrics.Counter {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	vec, ok := r.collectors[name].(*prometheus.CounterVec)
+	if !ok {
+		vec = prometheus.NewCounterVec(prometheus.CounterOpts{Name: name, Help: help, ConstLabels: r.labels}, labels)
+		r.collectors[name] = vec
+	}
+
+	return &counter{vec: vec}
+}
+
+func (r *Registry) Gauge(name, help string, labels ...string) metrics.Gauge {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	vec, ok := r.collectors[name].(*prometheus.GaugeVec)
+	if !ok {
+		vec = prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: name, Help: help, ConstLabels: r.labels}, labels)
+		r.collectors[name] = vec
+	}
+
+	return &gauge{vec: vec}
+}
+
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) metrics.Histogram {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	vec, ok := r.collectors[name].(*prometheus.HistogramVec)
+	if !ok {
+		vec = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:        name,
+			Help:        help,
+			Buckets:     buckets,
+			ConstLabels: r.labels,
+		}, labels)
+		r.collectors[name] = vec
+	}
+
+	return &histogram{vec: vec}
+}
+
+func (r *Registry) Describe(ch chan<- *prometheus.Desc) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, collector := range r.collectors {
+		collector.Describe(ch)
+	}
+}
+
+func (r *Registry) Collect(ch chan<- prometheus.Metric) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, collector := range r.collectors {
+		collector.Collect(ch)
+	}
+}
+
+type counter struct {
+	vec *prometheus.CounterVec
+}
+
+func (c *counter) Add(value float64, labelValues ...string) {
+	c.vec.WithLabelValues(labelValues...).Add(value)
+}
+
+type gauge struct {
+	vec *prometheus.GaugeVec
+}
+
+func (g *gauge) Set(value float64, labelValues ...string) {
+	g.vec.WithLabelValues(labelValues...).Set(value)
+}
+
+func (g *gauge) Delete(labelValues ...string) {
+	g.vec.DeleteLabelValues(labelValues...)
+}
+
+type histogram struct {
+	vec *prometheus.HistogramVec
+}
+
+func (h *histogram) Observe(value float64, labelValues ...string) {
+	h.vec.WithLabelValues(labelValues...).Observe(value)
+}
@@ -0,0 +1,97 @@
+package prometheus
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/loghole/lhw"
+	"github.com/loghole/lhw/metrics"
+)
+
+func TestRegistry(t *testing.T) {
+	registry := NewRegistry()
+
+	promRegistry := prometheus.NewPedanticRegistry()
+	if err := promRegistry.Register(registry); err != nil {
+		t.Fatal(err)
+	}
+
+	m := metrics.New(registry)
+
+	m.Written(3)
+	m.Dropped(metrics.ReasonTooLarge, 2)
+	m.QueueState(5, 100)
+	m.Request("http://127.0.0.1:50000", "503", 20*time.Millisecond, 10)
+
+	expected := `
+# HELP lhw_entries_written_total Count of entries written to the queue.
+# TYPE lhw_entries_written_total counter
+lhw_entries_written_total 3
+# HELP lhw_entries_dropped_total Count of entries dropped by the writer.
+# TYPE lhw_entries_dropped_total counter
+lhw_entries_dropped_total{reason="too_large"} 2
+# HELP lhw_queue_depth Count of entries in the queue.
+# TYPE lhw_queue_depth gauge
+lhw_queue_depth 5
+# HELP lhw_requests_total Count of requests to collector nodes.
+# TYPE lhw_requests_total counter
+lhw_requests_total{code="503",node="http://127.0.0.1:50000"} 1
+`
+
+	err := testutil.GatherAndCompare(promRegistry, strings.NewReader(expected),
+		"lhw_entries_written_total",
+		"lhw_entries_dropped_total",
+		"lhw_queue_depth",
+		"lhw_requests_total",
+	)
+	assert.Nil(t, err)
+
+	count, err := testutil.GatherAndCount(promRegistry, "lhw_request_duration_seconds")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, count)
+}
+
+func TestRegistry_TwoWriters(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	var (
+		shared       = NewRegistry()
+		audit        = NewRegistryWithLabels(prometheus.Labels{"writer": "audit"})
+		promRegistry = prometheus.NewPedanticRegistry()
+	)
+
+	for _, registry := range []*Registry{shared, audit} {
+		if err := promRegistry.Register(registry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, registry := range []*Registry{shared, shared, audit} {
+		writer, err := lhw.NewWriter(ts.URL, lhw.WithMetrics(registry))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = writer.Write([]byte(`{"message":"1"}`))
+		assert.Nil(t, err)
+		assert.Nil(t, writer.Close())
+	}
+
+	expected := `
+# HELP lhw_entries_written_total Count of entries written to the queue.
+# TYPE lhw_entries_written_total counter
+lhw_entries_written_total 2
+lhw_entries_written_total{writer="audit"} 1
+`
+
+	err := testutil.GatherAndCompare(promRegistry, strings.NewReader(expected), "lhw_entries_written_total")
+	assert.Nil(t, err)
+}
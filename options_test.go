@@ -1,7 +1,9 @@
 package lhw
 
 import (
+	"crypto/tls"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"testing"
@@ -9,6 +11,7 @@ import (
 
 	"github.com/stretchr/testify/assert"
 
+	"github.com/loghole/lhw/metrics"
 	"github.com/loghole/lhw/transport"
 )
 
@@ -31,16 +34,252 @@ func TestOptions(t *testing.T) {
 			wantErr:     true,
 			expectedErr: ErrBadQueueCapacity.Error(),
 		},
+		{
+			name:        "WithBatchSize",
+			option:      WithBatchSize(10),
+			expectedRes: &Options{BatchSize: 10},
+		},
+		{
+			name:        "WithBatchSizeError",
+			option:      WithBatchSize(0),
+			wantErr:     true,
+			expectedErr: ErrBadBatchSize.Error(),
+		},
+		{
+			name:        "WithBatchBytes",
+			option:      WithBatchBytes(1024),
+			expectedRes: &Options{BatchBytes: 1024},
+		},
+		{
+			name:        "WithBatchBytesError",
+			option:      WithBatchBytes(-1),
+			wantErr:     true,
+			expectedErr: ErrBadBatchBytes.Error(),
+		},
+		{
+			name:        "WithFlushInterval",
+			option:      WithFlushInterval(time.Second),
+			expectedRes: &Options{FlushInterval: time.Second},
+		},
+		{
+			name:        "WithFlushIntervalError",
+			option:      WithFlushInterval(0),
+			wantErr:     true,
+			expectedErr: ErrBadFlushInterval.Error(),
+		},
+		{
+			name:        "WithMaxInFlight",
+			option:      WithMaxInFlight(2),
+			expectedRes: &Options{MaxInFlight: 2},
+		},
+		{
+			name:        "WithMaxInFlightError",
+			option:      WithMaxInFlight(0),
+			wantErr:     true,
+			expectedErr: ErrBadMaxInFlight.Error(),
+		},
+		{
+			name:        "WithOverflowPolicy",
+			option:      WithOverflowPolicy(BlockWithTimeout, time.Second),
+			expectedRes: &Options{OverflowPolicy: BlockWithTimeout, OverflowTimeout: time.Second},
+		},
+		{
+			name:        "WithOverflowPolicyError",
+			option:      WithOverflowPolicy(BlockWithTimeout, 0),
+			wantErr:     true,
+			expectedErr: ErrBadOverflowPolicy.Error(),
+		},
+		{
+			name:        "WithQueueMaxBytes",
+			option:      WithQueueMaxBytes(1 << 20),
+			expectedRes: &Options{QueueMaxBytes: 1 << 20},
+		},
+		{
+			name:        "WithQueueMaxBytesError",
+			option:      WithQueueMaxBytes(0),
+			wantErr:     true,
+			expectedErr: ErrBadQueueMaxBytes.Error(),
+		},
+		{
+			name:        "WithMaxEntrySize",
+			option:      WithMaxEntrySize(1024, true),
+			expectedRes: &Options{MaxEntrySize: 1024, TruncateEntries: true},
+		},
+		{
+			name:        "WithMaxEntrySizeError",
+			option:      WithMaxEntrySize(-1, false),
+			wantErr:     true,
+			expectedErr: ErrBadMaxEntrySize.Error(),
+		},
 		{
 			name:        "WithLogger",
 			option:      WithLogger(log.New(os.Stdout, "", log.Ltime)),
 			expectedRes: &Options{Logger: log.New(os.Stdout, "", log.Ltime)},
 		},
+		{
+			name:        "WithMetrics",
+			option:      WithMetrics(metrics.Nop{}),
+			expectedRes: &Options{Metrics: metrics.Nop{}},
+		},
+		{
+			name:        "WithHooks",
+			option:      WithHooks(NopHooks{}),
+			expectedRes: &Options{Hooks: NopHooks{}},
+		},
+		{
+			name:        "WithQueueHighWatermark",
+			option:      WithQueueHighWatermark(10),
+			expectedRes: &Options{QueueHighWatermark: 10},
+		},
+		{
+			name:        "WithQueueHighWatermarkError",
+			option:      WithQueueHighWatermark(0),
+			wantErr:     true,
+			expectedErr: ErrBadHighWatermark.Error(),
+		},
+		{
+			name:        "WithFallback",
+			option:      WithFallback(os.Stderr, time.Minute),
+			expectedRes: &Options{Fallback: os.Stderr, FallbackAfter: time.Minute},
+		},
+		{
+			name:        "WithFallbackError",
+			option:      WithFallback(nil, time.Minute),
+			wantErr:     true,
+			expectedErr: ErrBadFallback.Error(),
+		},
+		{
+			name:        "WithFallbackWatermark",
+			option:      WithFallbackWatermark(100),
+			expectedRes: &Options{FallbackWatermark: 100},
+		},
+		{
+			name:        "WithFallbackReship",
+			option:      WithFallbackReship(),
+			expectedRes: &Options{FallbackReship: true},
+		},
+		{
+			name:        "WithStreaming",
+			option:      WithStreaming(),
+			expectedRes: &Options{Streaming: true},
+		},
 		{
 			name:        "WithInsecure",
 			option:      WithInsecure(),
 			expectedRes: &Options{Insecure: true},
 		},
+		{
+			name:        "WithTLSConfig",
+			option:      WithTLSConfig(&tls.Config{MinVersion: tls.VersionTLS12}),
+			expectedRes: &Options{TLSConfig: &tls.Config{MinVersion: tls.VersionTLS12}},
+		},
+		{
+			name:        "WithTLSConfigError",
+			option:      WithTLSConfig(nil),
+			wantErr:     true,
+			expectedErr: ErrBadTLSConfig.Error(),
+		},
+		{
+			name:        "WithRootCAs",
+			option:      WithRootCAs("ca.pem"),
+			expectedRes: &Options{RootCAs: "ca.pem"},
+		},
+		{
+			name:        "WithRootCAsError",
+			option:      WithRootCAs(""),
+			wantErr:     true,
+			expectedErr: ErrBadTLSConfig.Error(),
+		},
+		{
+			name:        "WithClientCertificate",
+			option:      WithClientCertificate("cert.pem", "key.pem"),
+			expectedRes: &Options{ClientCert: "cert.pem", ClientKey: "key.pem"},
+		},
+		{
+			name:        "WithClientCertificateError",
+			option:      WithClientCertificate("cert.pem", ""),
+			wantErr:     true,
+			expectedErr: ErrBadTLSConfig.Error(),
+		},
+		{
+			name:        "WithServerName",
+			option:      WithServerName("collector"),
+			expectedRes: &Options{ServerName: "collector"},
+		},
+		{
+			name:        "WithServerNameError",
+			option:      WithServerName(""),
+			wantErr:     true,
+			expectedErr: ErrBadTLSConfig.Error(),
+		},
+		{
+			name:        "WithProxy",
+			option:      WithProxy("socks5://proxy:1080"),
+			expectedRes: &Options{Proxy: "socks5://proxy:1080"},
+		},
+		{
+			name:        "WithProxyError",
+			option:      WithProxy("ftp://proxy"),
+			wantErr:     true,
+			expectedErr: ErrBadProxy.Error(),
+		},
+		{
+			name:        "WithProxyFromEnvironment",
+			option:      WithProxyFromEnvironment(),
+			expectedRes: &Options{ProxyFromEnvironment: true},
+		},
+		{
+			name:        "WithDialerError",
+			option:      WithDialer(nil),
+			wantErr:     true,
+			expectedErr: ErrBadDialer.Error(),
+		},
+		{
+			name:        "WithDiscovery",
+			option:      WithDiscovery(time.Minute),
+			expectedRes: &Options{DiscoveryInterval: time.Minute},
+		},
+		{
+			name:        "WithDiscoveryError",
+			option:      WithDiscovery(0),
+			wantErr:     true,
+			expectedErr: ErrBadDiscovery.Error(),
+		},
+		{
+			name:        "WithResolver",
+			option:      WithResolver(net.DefaultResolver),
+			expectedRes: &Options{Resolver: net.DefaultResolver},
+		},
+		{
+			name:        "WithResolverError",
+			option:      WithResolver(nil),
+			wantErr:     true,
+			expectedErr: ErrBadResolver.Error(),
+		},
+		{
+			name:        "WithAuth",
+			option:      WithAuth(transport.NewTokenAuth("token")),
+			expectedRes: &Options{Auth: transport.NewTokenAuth("token")},
+		},
+		{
+			name:        "WithAuthError",
+			option:      WithAuth(nil),
+			wantErr:     true,
+			expectedErr: ErrBadAuth.Error(),
+		},
+		{
+			name:   "WithNodeAuth",
+			option: WithNodeAuth("https://collector:50000", transport.NewTokenAuth("token")),
+			expectedRes: &Options{NodeAuth: map[string]transport.AuthProvider{
+				"https://collector:50000": transport.NewTokenAuth("token"),
+			}},
+		},
+		{
+			name:        "WithNodeAuthError",
+			option:      WithNodeAuth("", transport.NewTokenAuth("token")),
+			wantErr:     true,
+			expectedErr: ErrBadAuth.Error(),
+		},
 		{
 			name:        "WithRequestTimeout",
 			option:      WithRequestTimeout(time.Second),
@@ -74,6 +313,90 @@ func TestOptions(t *testing.T) {
 			wantErr:     true,
 			expectedErr: ErrSuccessCodes.Error(),
 		},
+		{
+			name:        "WithCompression",
+			option:      WithCompression(transport.CompressionGzip),
+			expectedRes: &Options{Compression: "gzip"},
+		},
+		{
+			name:        "WithCompressionError",
+			option:      WithCompression("lz4"),
+			wantErr:     true,
+			expectedErr: ErrBadCompression.Error(),
+		},
+		{
+			name:        "WithCompressionMinSize",
+			option:      WithCompressionMinSize(512),
+			expectedRes: &Options{CompressionMinSize: 512},
+		},
+		{
+			name:        "WithCompressionMinSizeError",
+			option:      WithCompressionMinSize(-1),
+			wantErr:     true,
+			expectedErr: ErrBadCompressionMin.Error(),
+		},
+		{
+			name:        "WithSpool",
+			option:      WithSpool("/var/spool/lhw"),
+			expectedRes: &Options{SpoolDir: "/var/spool/lhw"},
+		},
+		{
+			name:        "WithSpoolError",
+			option:      WithSpool(""),
+			wantErr:     true,
+			expectedErr: ErrBadSpoolDir.Error(),
+		},
+		{
+			name:        "WithSpoolMaxBytes",
+			option:      WithSpoolMaxBytes(1024),
+			expectedRes: &Options{SpoolMaxBytes: 1024},
+		},
+		{
+			name:        "WithSpoolMaxBytesError",
+			option:      WithSpoolMaxBytes(0),
+			wantErr:     true,
+			expectedErr: ErrBadSpoolMaxBytes.Error(),
+		},
+		{
+			name:        "WithSpoolSync",
+			option:      WithSpoolSync(SyncInterval, time.Second),
+			expectedRes: &Options{SpoolSyncPolicy: SyncInterval, SpoolSyncInterval: time.Second},
+		},
+		{
+			name:        "WithSpoolSyncError",
+			option:      WithSpoolSync(SyncInterval, 0),
+			wantErr:     true,
+			expectedErr: ErrBadSpoolSync.Error(),
+		},
+		{
+			name:        "WithRetryPolicy",
+			option:      WithRetryPolicy(transport.RetryPolicy{InitialBackoff: time.Second, Multiplier: 2, MaxAttempts: 3}),
+			expectedRes: &Options{RetryPolicy: transport.RetryPolicy{InitialBackoff: time.Second, MaxBackoff: DefaultMaxBackoff, Multiplier: 2, MaxAttempts: 3}},
+		},
+		{
+			name:        "WithRetryPolicyError",
+			option:      WithRetryPolicy(transport.RetryPolicy{Multiplier: 2, Jitter: 1.5}),
+			wantErr:     true,
+			expectedErr: ErrBadRetryPolicy.Error(),
+		},
+		{
+			name:        "WithRetryPolicyNoBackoffError",
+			option:      WithRetryPolicy(transport.RetryPolicy{Multiplier: 2}),
+			wantErr:     true,
+			expectedErr: ErrBadRetryPolicy.Error(),
+		},
+		{
+			name:        "WithRetryPolicyMaxBackoffError",
+			option:      WithRetryPolicy(transport.RetryPolicy{InitialBackoff: time.Second, MaxBackoff: time.Millisecond, Multiplier: 2}),
+			wantErr:     true,
+			expectedErr: ErrBadRetryPolicy.Error(),
+		},
+		{
+			name:        "WithRetryPolicyBudgetError",
+			option:      WithRetryPolicy(transport.RetryPolicy{Multiplier: 2, Budget: 10}),
+			wantErr:     true,
+			expectedErr: ErrBadRetryPolicy.Error(),
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -101,6 +424,18 @@ func TestTransportConfig(t *testing.T) {
 		RequestTimeout: DefaultRequestTimeout,
 		PingInterval:   DefaultPingInterval,
 		SuccessCodes:   []int{200, 201, 202},
+
+		RootCAs:    "ca.pem",
+		ClientCert: "cert.pem",
+		ClientKey:  "key.pem",
+		ServerName: "collector",
+
+		Proxy: "http://proxy:3128",
+
+		DiscoveryInterval: time.Minute,
+
+		Compression:        transport.CompressionZstd,
+		CompressionMinSize: DefaultCompressionMinSize,
 	}
 
 	config := Options{
@@ -108,6 +443,18 @@ func TestTransportConfig(t *testing.T) {
 		RequestTimeout: DefaultRequestTimeout,
 		PingInterval:   DefaultPingInterval,
 		SuccessCodes:   []int{200, 201, 202},
+
+		RootCAs:    "ca.pem",
+		ClientCert: "cert.pem",
+		ClientKey:  "key.pem",
+		ServerName: "collector",
+
+		Proxy: "http://proxy:3128",
+
+		DiscoveryInterval: time.Minute,
+
+		Compression:        transport.CompressionZstd,
+		CompressionMinSize: DefaultCompressionMinSize,
 	}
 
 	assert.Equal(t, expected, config.transportConfig())
@@ -116,10 +463,28 @@ func TestTransportConfig(t *testing.T) {
 func TestGetDefaultOptions(t *testing.T) {
 	expected := &Options{
 		QueueCap:       DefaultQueueCap,
+		BatchSize:      DefaultBatchSize,
+		BatchBytes:     DefaultBatchBytes,
+		FlushInterval:  DefaultFlushInterval,
+		MaxInFlight:    DefaultMaxInFlight,
 		Insecure:       false,
 		RequestTimeout: DefaultRequestTimeout,
 		PingInterval:   DefaultPingInterval,
 		SuccessCodes:   []int{http.StatusOK, http.StatusCreated},
+
+		CompressionMinSize: DefaultCompressionMinSize,
+
+		SpoolMaxBytes:     DefaultSpoolMaxBytes,
+		SpoolSegmentSize:  DefaultSpoolSegmentSize,
+		SpoolSyncPolicy:   DefaultSpoolSyncPolicy,
+		SpoolSyncInterval: DefaultSpoolSyncInterval,
+
+		RetryPolicy: transport.RetryPolicy{
+			InitialBackoff: DefaultInitialBackoff,
+			MaxBackoff:     DefaultMaxBackoff,
+			Multiplier:     DefaultBackoffFactor,
+			Jitter:         DefaultBackoffJitter,
+		},
 	}
 
 	assert.Equal(t, expected, GetDefaultOptions())
@@ -1,23 +1,152 @@
 package test
 
 import (
+	"errors"
+	"strconv"
+	"sync"
 	"sync/atomic"
+	"time"
 )
 
+var ErrStubSend = errors.New("stub send failed")
+
 type StubTransport struct {
 	Counter int64
+	Batches int64
+
+	// Failures is a count of sends failed before the first successful one.
+	Failures int64
+	Attempts int64
+
+	// Errors are returned by batch sends following the failed ones.
+	Errors []error
+
+	// Disconnected transport never reconnects.
+	Disconnected bool
+	Throttle     time.Duration
+
+	Closed int32
 }
 
 func (m *StubTransport) Send(body []byte) error {
+	if atomic.AddInt64(&m.Attempts, 1) <= m.Failures {
+		return ErrStubSend
+	}
+
 	atomic.AddInt64(&m.Counter, 1)
 
 	return nil
 }
 
+func (m *StubTransport) SendBatch(batch [][]byte) error {
+	attempt := atomic.AddInt64(&m.Attempts, 1)
+	if attempt <= m.Failures {
+		return ErrStubSend
+	}
+
+	if idx := attempt - m.Failures - 1; idx < int64(len(m.Errors)) {
+		return m.Errors[idx]
+	}
+
+	atomic.AddInt64(&m.Counter, int64(len(batch)))
+	atomic.AddInt64(&m.Batches, 1)
+
+	return nil
+}
+
 func (m *StubTransport) IsConnected() (ok bool) {
-	return true
+	return !m.Disconnected
 }
 
 func (m *StubTransport) IsReconnected() <-chan struct{} {
 	return nil
 }
+
+func (m *StubTransport) ThrottleDelay() time.Duration {
+	return m.Throttle
+}
+
+func (m *StubTransport) Close() error {
+	atomic.StoreInt32(&m.Closed, 1)
+
+	return nil
+}
+
+// FakeClock records requested delays and fires timers immediately.
+type FakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	delays []time.Duration
+}
+
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.delays = append(c.delays, d)
+	c.now = c.now.Add(d)
+
+	ch := make(chan time.Time, 1)
+	ch <- c.now
+
+	return ch
+}
+
+// Delays returns all delays requested by After.
+func (c *FakeClock) Delays() []time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]time.Duration{}, c.delays...)
+}
+
+// RecordHooks records received hook events as strings.
+type RecordHooks struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (h *RecordHooks) OnSendError(node string, err error) {
+	h.record("send_error " + node + ": " + err.Error())
+}
+
+func (h *RecordHooks) OnNodeDown(node string) {
+	h.record("node_down " + node)
+}
+
+func (h *RecordHooks) OnNodeUp(node string) {
+	h.record("node_up " + node)
+}
+
+func (h *RecordHooks) OnDrop(entry []byte, reason string) {
+	h.record("drop " + string(entry) + ": " + reason)
+}
+
+func (h *RecordHooks) OnQueueHighWatermark(depth int) {
+	h.record("high_watermark " + strconv.Itoa(depth))
+}
+
+// Events returns all recorded events.
+func (h *RecordHooks) Events() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return append([]string{}, h.events...)
+}
+
+func (h *RecordHooks) record(event string) {
+	h.mu.Lock()
+	h.events = append(h.events, event)
+	h.mu.Unlock()
+}
@@ -0,0 +1,297 @@
+package transport
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	hmacTimestampHeader = "X-Lhw-Timestamp"
+	hmacSignatureScheme = "LHW-HMAC-SHA256"
+)
+
+const (
+	// DefaultOAuth2ExpiryDelta refreshes tokens before they expire,
+	// so requests in flight are not sent with expired tokens.
+	DefaultOAuth2ExpiryDelta = 10 * time.Second
+	// DefaultOAuth2TokenTTL is used for tokens without expiration.
+	DefaultOAuth2TokenTTL = time.Hour
+)
+
+var (
+	ErrBadAuthToken    = errors.New("auth token file is empty")
+	ErrBadOAuth2Config = errors.New("oauth2 config invalid")
+	ErrOAuth2Token     = errors.New("oauth2 token request failed")
+	ErrUnsupportedAuth = errors.New("auth provider is not supported by the node")
+)
+
+// AuthProvider authorizes requests sent to the node, body is the payload
+// of the request as sent, after compression.
+type AuthProvider interface {
+	Authorize(req *http.Request, body []byte) error
+}
+
+// authTransport authorizes requests of connections using HTTP.
+type authTransport struct {
+	transport http.RoundTripper
+	auth      AuthProvider
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+
+	// Bodies of streaming requests can not be read in advance,
+	// providers signing bodies are rejected by streaming nodes.
+	if req.GetBody != nil {
+		reader, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+
+		if body, err = ioutil.ReadAll(reader); err != nil {
+			return nil, err
+		}
+	}
+
+	req = req.Clone(req.Context())
+
+	if err := t.auth.Authorize(req, body); err != nil {
+		return nil, err
+	}
+
+	return t.transport.RoundTrip(req)
+}
+
+// transportAuth returns auth provider of the node transport,
+// it returns nil if the node uses the token of its url.
+func transportAuth(transport http.RoundTripper) AuthProvider {
+	if t, ok := transport.(*authTransport); ok {
+		return t.auth
+	}
+
+	return nil
+}
+
+// signsBody reports whether the provider needs the body of the request.
+func signsBody(auth AuthProvider) bool {
+	_, ok := auth.(*HMACAuth)
+
+	return ok
+}
+
+// TokenAuth sends the static bearer token.
+type TokenAuth string
+
+// NewTokenAuth returns provider of the token, it is used for tokens from node urls.
+func NewTokenAuth(token string) TokenAuth {
+	return TokenAuth(token)
+}
+
+func (a TokenAuth) Authorize(req *http.Request, _ []byte) error {
+	req.Header.Set(authorizationHeader, "Bearer"+" "+string(a))
+
+	return nil
+}
+
+// FileTokenAuth sends bearer token read from the file, the file is read
+// again when it changes, so rotated tokens are used without restart.
+type FileTokenAuth struct {
+	path string
+
+	mu    sync.Mutex
+	watch fileWatch
+	token string
+	err   error
+}
+
+func NewFileTokenAuth(path string) (*FileTokenAuth, error) {
+	a := &FileTokenAuth{path: path}
+
+	if _, err := a.Token(); err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+// Token returns the current token from the file.
+func (a *FileTokenAuth) Token() (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if !a.watch.changed(a.path) {
+		return a.token, a.err
+	}
+
+	data, err := ioutil.ReadFile(a.path)
+
+	switch token := strings.TrimSpace(string(data)); {
+	case err != nil:
+		a.token, a.err = "", err
+	case token == "":
+		a.token, a.err = "", ErrBadAuthToken
+	default:
+		a.token, a.err = token, nil
+	}
+
+	return a.token, a.err
+}
+
+func (a *FileTokenAuth) Authorize(req *http.Request, _ []byte) error {
+	token, err := a.Token()
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set(authorizationHeader, "Bearer"+" "+token)
+
+	return nil
+}
+
+type OAuth2Config struct {
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+
+	// Transport sends token requests, nil means http.DefaultTransport.
+	Transport http.RoundTripper
+}
+
+// OAuth2Auth sends access tokens of OAuth2 client credentials grant,
+// the token is cached until it is about to expire.
+type OAuth2Auth struct {
+	config OAuth2Config
+	client *http.Client
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+type oauth2Token struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+func NewOAuth2Auth(config OAuth2Config) (*OAuth2Auth, error) {
+	if _, err := url.ParseRequestURI(config.TokenURL); err != nil || config.ClientID == "" {
+		return nil, ErrBadOAuth2Config
+	}
+
+	return &OAuth2Auth{config: config, client: &http.Client{Transport: config.Transport}}, nil
+}
+
+func (a *OAuth2Auth) Authorize(req *http.Request, _ []byte) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.token == "" || !time.Now().Before(a.expires) {
+		if err := a.refresh(req); err != nil {
+			return err
+		}
+	}
+
+	req.Header.Set(authorizationHeader, a.token)
+
+	return nil
+}
+
+// refresh requests new token within the context of the node request.
+func (a *OAuth2Auth) refresh(nodeReq *http.Request) error {
+	form := url.Values{"grant_type": {"client_credentials"}}
+
+	if len(a.config.Scopes) > 0 {
+		form.Set("scope", strings.Join(a.config.Scopes, " "))
+	}
+
+	req, err := http.NewRequestWithContext(nodeReq.Context(), http.MethodPost, a.config.TokenURL,
+		strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set(contentTypeHeader, "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(a.config.ClientID), url.QueryEscape(a.config.ClientSecret))
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: status %d", ErrOAuth2Token, resp.StatusCode)
+	}
+
+	var token oauth2Token
+
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return fmt.Errorf("%w: %v", ErrOAuth2Token, err)
+	}
+
+	if token.AccessToken == "" {
+		return fmt.Errorf("%w: empty access token", ErrOAuth2Token)
+	}
+
+	tokenType := token.TokenType
+	if tokenType == "" || strings.EqualFold(tokenType, "bearer") {
+		tokenType = "Bearer"
+	}
+
+	ttl := DefaultOAuth2TokenTTL
+	if token.ExpiresIn > 0 {
+		ttl = time.Duration(token.ExpiresIn)*time.Second - DefaultOAuth2ExpiryDelta
+	}
+
+	a.token = tokenType + " " + token.AccessToken
+	a.expires = time.Now().Add(ttl)
+
+	return nil
+}
+
+// HMACAuth signs method, path, timestamp and body of the request with
+// the shared secret, so the node can verify the sender and reject replays.
+type HMACAuth struct {
+	keyID  string
+	secret []byte
+	now    func() time.Time
+}
+
+func NewHMACAuth(keyID string, secret []byte) *HMACAuth {
+	return &HMACAuth{keyID: keyID, secret: secret, now: time.Now}
+}
+
+func (a *HMACAuth) Authorize(req *http.Request, body []byte) error {
+	timestamp := strconv.FormatInt(a.now().Unix(), 10)
+
+	req.Header.Set(hmacTimestampHeader, timestamp)
+	req.Header.Set(authorizationHeader, fmt.Sprintf("%s keyId=%s, signature=%s",
+		hmacSignatureScheme, a.keyID, a.Signature(req.Method, req.URL.EscapedPath(), timestamp, body)))
+
+	return nil
+}
+
+// Signature returns hex encoded HMAC-SHA256 of method, path and timestamp
+// separated by new lines and followed by the body.
+func (a *HMACAuth) Signature(method, path, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, a.secret)
+
+	mac.Write([]byte(method + "\n" + path + "\n" + timestamp + "\n"))
+	mac.Write(body)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
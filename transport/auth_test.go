@@ -0,0 +1,281 @@
+package transport
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNodeClient_Auth(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lhw-auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	tokenFile := filepath.Join(dir, "token")
+	now := time.Now()
+
+	writeFile(t, tokenFile, []byte("file-token\n"), now)
+
+	fileAuth, err := NewFileTokenAuth(tokenFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hmacAuth := NewHMACAuth("key1", []byte("secret"))
+	hmacAuth.now = func() time.Time { return time.Unix(1600000000, 0) }
+
+	tests := []struct {
+		name         string
+		auth         AuthProvider
+		expectedAuth []string
+	}{
+		{
+			name:         "URLToken",
+			expectedAuth: []string{"Bearer token", "Bearer token"},
+		},
+		{
+			name:         "Token",
+			auth:         NewTokenAuth("static"),
+			expectedAuth: []string{"Bearer static", "Bearer static"},
+		},
+		{
+			name:         "File",
+			auth:         fileAuth,
+			expectedAuth: []string{"Bearer file-token", "Bearer file-token"},
+		},
+		{
+			name: "HMAC",
+			auth: hmacAuth,
+			expectedAuth: []string{
+				"LHW-HMAC-SHA256 keyId=key1, signature=" +
+					hmacAuth.Signature(http.MethodPost, storeURI, "1600000000", []byte(`{"message":"1"}`)),
+				"LHW-HMAC-SHA256 keyId=key1, signature=" +
+					hmacAuth.Signature(http.MethodPost, pingURI, "1600000000", nil),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var headers []string
+
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				headers = append(headers, r.Header.Get(authorizationHeader))
+			}))
+			defer ts.Close()
+
+			var options []NodeOption
+
+			if tt.auth != nil {
+				options = append(options, WithAuth(tt.auth))
+			}
+
+			client, err := NewNodeClient(strings.Replace(ts.URL, "://", "://token@", 1), ts.Client().Transport, options...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = client.SendRequest([]byte(`{"message":"1"}`), time.Second)
+			assert.Nil(t, err)
+
+			_, err = client.PingRequest(time.Second)
+			assert.Nil(t, err)
+
+			assert.Equal(t, tt.expectedAuth, headers)
+		})
+	}
+}
+
+func TestFileTokenAuth_Rotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lhw-auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	tokenFile := filepath.Join(dir, "token")
+	now := time.Now()
+
+	writeFile(t, tokenFile, []byte("old"), now)
+
+	auth, err := NewFileTokenAuth(tokenFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := auth.Token()
+	assert.Nil(t, err)
+	assert.Equal(t, "old", token)
+
+	writeFile(t, tokenFile, []byte("new"), now.Add(time.Minute))
+
+	token, err = auth.Token()
+	assert.Nil(t, err)
+	assert.Equal(t, "new", token)
+
+	writeFile(t, tokenFile, []byte(" \n"), now.Add(2*time.Minute))
+
+	_, err = auth.Token()
+	assert.ErrorIs(t, err, ErrBadAuthToken)
+
+	_, err = NewFileTokenAuth(filepath.Join(dir, "missing"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestOAuth2Auth(t *testing.T) {
+	var requests int
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+
+		user, password, _ := r.BasicAuth()
+
+		assert.Equal(t, "client", user)
+		assert.Equal(t, "secret", password)
+		assert.Nil(t, r.ParseForm())
+		assert.Equal(t, "client_credentials", r.PostForm.Get("grant_type"))
+		assert.Equal(t, "logs.write logs.read", r.PostForm.Get("scope"))
+
+		// The second token expires before it is used.
+		expires := "3600"
+		if requests == 2 {
+			expires = "5"
+		}
+
+		w.Header().Set(contentTypeHeader, jsonContentType)
+		_, _ = w.Write([]byte(`{"access_token":"token` + string(rune('0'+requests)) +
+			`","token_type":"bearer","expires_in":` + expires + `}`))
+	}))
+	defer ts.Close()
+
+	auth, err := NewOAuth2Auth(OAuth2Config{
+		TokenURL:     ts.URL,
+		ClientID:     "client",
+		ClientSecret: "secret",
+		Scopes:       []string{"logs.write", "logs.read"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authorize := func() string {
+		req, _ := http.NewRequestWithContext(context.Background(), http.MethodPost, "http://collector", nil)
+
+		assert.Nil(t, auth.Authorize(req, nil))
+
+		return req.Header.Get(authorizationHeader)
+	}
+
+	assert.Equal(t, "Bearer token1", authorize())
+	assert.Equal(t, "Bearer token1", authorize())
+
+	// Token is refreshed when it is about to expire.
+	auth.expires = time.Now()
+
+	assert.Equal(t, "Bearer token2", authorize())
+	assert.Equal(t, "Bearer token3", authorize())
+	assert.Equal(t, 3, requests)
+
+	_, err = NewOAuth2Auth(OAuth2Config{TokenURL: "token", ClientID: "client"})
+	assert.ErrorIs(t, err, ErrBadOAuth2Config)
+}
+
+func TestOAuth2Auth_Error(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer ts.Close()
+
+	auth, err := NewOAuth2Auth(OAuth2Config{TokenURL: ts.URL, ClientID: "client"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, _ := http.NewRequestWithContext(context.Background(), http.MethodPost, "http://collector", nil)
+
+	assert.ErrorIs(t, auth.Authorize(req, nil), ErrOAuth2Token)
+}
+
+func TestNewClientsPool_NodeAuth(t *testing.T) {
+	headers := make(chan string, 2)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers <- r.Header.Get(authorizationHeader)
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		name   string
+		server string
+	}{
+		{
+			name:   "HTTP",
+			server: "http://token@" + strings.TrimPrefix(ts.URL, "http://"),
+		},
+		{
+			name:   "Conn",
+			server: "gelf+http://" + strings.TrimPrefix(ts.URL, "http://"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pool, err := NewClientsPoolWithConfig(Config{
+				Servers:  []string{tt.server},
+				Auth:     NewTokenAuth("default"),
+				NodeAuth: map[string]AuthProvider{nodeAddr(tt.server): NewTokenAuth("node")},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			client, err := pool.NextLive()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = client.SendRequest([]byte(`{"message":"1"}`), time.Second)
+			assert.Nil(t, err)
+
+			assert.Equal(t, "Bearer node", <-headers)
+		})
+	}
+}
+
+func TestNewClientsPool_UnsupportedAuth(t *testing.T) {
+	tests := []struct {
+		name      string
+		server    string
+		streaming bool
+	}{
+		{
+			name:   "GRPC",
+			server: "grpc://127.0.0.1:4317",
+		},
+		{
+			name:      "Streaming",
+			server:    "http://127.0.0.1:8080",
+			streaming: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewClientsPoolWithConfig(Config{
+				Servers:   []string{tt.server},
+				Streaming: tt.streaming,
+				Auth:      NewHMACAuth("key", []byte("secret")),
+			})
+			assert.ErrorIs(t, err, ErrUnsupportedAuth)
+		})
+	}
+}
@@ -3,10 +3,15 @@ package transport
 import (
 	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/url"
+	"strconv"
 	"sync/atomic"
 	"time"
+
+	"github.com/loghole/lhw/metrics"
 )
 
 const (
@@ -19,31 +24,119 @@ const (
 	pingURI  = "/api/v1/ping"
 
 	authorizationHeader = "Authorization"
+	retryAfterHeader    = "Retry-After"
+)
+
+// metricsErrorCode labels requests failed without response.
+const metricsErrorCode = "error"
+
+const (
+	// DefaultThrottleDelay is used for 429 and 503 responses without Retry-After header.
+	DefaultThrottleDelay = time.Second
+	// MinThrottleDelay is a min delay of 429 and 503 responses.
+	MinThrottleDelay = 100 * time.Millisecond
 )
 
+// ThrottleError is returned by Conn.Store with 429 or 503 code
+// to forward the delay requested by Retry-After header.
+type ThrottleError struct {
+	Delay time.Duration
+}
+
+func (e *ThrottleError) Error() string {
+	return fmt.Sprintf("throttled for %s", e.Delay)
+}
+
+// throttleError returns ThrottleError for 429 and 503 responses.
+func throttleError(resp *http.Response) error {
+	if delay, ok := throttleDelay(resp.StatusCode, resp.Header.Get(retryAfterHeader)); ok {
+		return &ThrottleError{Delay: delay}
+	}
+
+	return nil
+}
+
 type NodeConfig struct {
 	Host      string
 	AuthToken string
 }
 
 type NodeClient struct {
-	addr  string
-	token string
+	addr string
+	// name identifies the node in metrics and hooks, it is the url
+	// of the node or of the resolved address of discovered nodes.
+	name string
+	auth AuthProvider
 
-	status      int32
-	activeReq   int32
-	lastUseTime int64
+	status         int32
+	retired        int32
+	activeReq      int32
+	lastUseTime    int64
+	throttledUntil int64
 
 	client *http.Client
+
+	compressor      Compressor
+	compressMinSize int
+
+	metrics *metrics.Metrics
+
+	conn Conn
+}
+
+// Conn sends entries to the node over a protocol other than HTTP.
+// Result codes follow HTTP semantics, so success codes and throttling
+// of the transport apply to all protocols.
+type Conn interface {
+	Store(ctx context.Context, batch [][]byte) (code int, err error)
+	Ping(ctx context.Context) (code int, err error)
+	Close() error
+}
+
+type NodeOption func(c *NodeClient)
+
+// WithConn sends requests of the client over the connection instead of HTTP.
+func WithConn(conn Conn) NodeOption {
+	return func(c *NodeClient) {
+		c.conn = conn
+	}
+}
+
+// WithAuth authorizes requests of the client with the provider
+// instead of the token from the node url.
+func WithAuth(auth AuthProvider) NodeOption {
+	return func(c *NodeClient) {
+		c.auth = auth
+	}
+}
+
+// WithCompressor enables request body compression for bodies
+// with size greater or equal to minSize.
+func WithCompressor(compressor Compressor, minSize int) NodeOption {
+	return func(c *NodeClient) {
+		c.compressor = compressor
+		c.compressMinSize = minSize
+	}
+}
+
+// WithMetrics enables recording of request metrics.
+func WithMetrics(m *metrics.Metrics) NodeOption {
+	return func(c *NodeClient) {
+		c.metrics = m
+	}
 }
 
 // NewNodeClient create log hole node client.
-func NewNodeClient(dsn string, transport http.RoundTripper) (*NodeClient, error) {
+func NewNodeClient(dsn string, transport http.RoundTripper, options ...NodeOption) (*NodeClient, error) {
 	client := &NodeClient{
 		status: isLive,
 		client: &http.Client{Transport: transport},
 	}
 
+	for _, option := range options {
+		option(client)
+	}
+
 	if err := client.parseURL(dsn); err != nil {
 		return nil, err
 	}
@@ -52,14 +145,44 @@ func NewNodeClient(dsn string, transport http.RoundTripper) (*NodeClient, error)
 }
 
 func (c *NodeClient) SendRequest(body []byte, timeout time.Duration) (code int, err error) {
+	if c.conn != nil {
+		return c.store([][]byte{body}, timeout)
+	}
+
 	return c.do(storeURI, body, timeout)
 }
 
+// SendBatchRequest sends entries in one request, HTTP nodes receive them as json array.
+func (c *NodeClient) SendBatchRequest(batch [][]byte, timeout time.Duration) (code int, err error) {
+	if c.conn != nil {
+		return c.store(batch, timeout)
+	}
+
+	return c.do(storeURI, encodeBatch(batch), timeout)
+}
+
 // Ping request allows to check connection status.
 func (c *NodeClient) PingRequest(timeout time.Duration) (code int, err error) {
+	if c.conn != nil {
+		return c.ping(timeout)
+	}
+
 	return c.do(pingURI, nil, timeout)
 }
 
+// Close closes connection and idle HTTP connections of the client.
+func (c *NodeClient) Close() error {
+	if c.client != nil {
+		c.client.CloseIdleConnections()
+	}
+
+	if c.conn != nil {
+		return c.conn.Close()
+	}
+
+	return nil
+}
+
 // ActiveRequests returns all active request of node client.
 func (c *NodeClient) ActiveRequests() int {
 	return int(atomic.LoadInt32(&c.activeReq))
@@ -70,6 +193,21 @@ func (c *NodeClient) LastUseTime() int {
 	return int(atomic.LoadInt64(&c.lastUseTime))
 }
 
+// ThrottleDelay returns remaining time the node asked to pause sending for.
+func (c *NodeClient) ThrottleDelay() time.Duration {
+	delay := time.Duration(atomic.LoadInt64(&c.throttledUntil) - time.Now().UnixNano())
+	if delay < 0 {
+		return 0
+	}
+
+	return delay
+}
+
+// IsThrottled reports whether the node asked to pause sending.
+func (c *NodeClient) IsThrottled() bool {
+	return c.ThrottleDelay() > 0
+}
+
 func (c *NodeClient) do(uri string, body []byte, timeout time.Duration) (code int, err error) {
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
@@ -77,40 +215,190 @@ func (c *NodeClient) do(uri string, body []byte, timeout time.Duration) (code in
 	atomic.AddInt32(&c.activeReq, 1)
 	defer atomic.AddInt32(&c.activeReq, -1)
 
+	body, encoding, err := c.compress(body)
+	if err != nil {
+		return 0, err
+	}
+
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.addr, bytes.NewBuffer(body))
 	if err != nil {
 		return 0, err
 	}
 
 	req.URL.Path = uri
-	req.Header.Set(authorizationHeader, c.token)
 
-	atomic.StoreInt64(&c.lastUseTime, time.Now().UnixNano())
+	if c.auth != nil {
+		if err := c.auth.Authorize(req, body); err != nil {
+			return 0, err
+		}
+	}
+
+	if encoding != "" {
+		req.Header.Set(contentEncodingHeader, encoding)
+	}
+
+	started := time.Now()
+
+	atomic.StoreInt64(&c.lastUseTime, started.UnixNano())
 
 	resp, err := c.client.Do(req)
 	if err != nil {
+		c.metrics.Request(c.name, metricsErrorCode, time.Since(started), len(body))
+
 		return 0, err
 	}
 
+	c.metrics.Request(c.name, strconv.Itoa(resp.StatusCode), time.Since(started), len(body))
+
 	if err := resp.Body.Close(); err != nil {
 		return 0, err
 	}
 
+	if delay, ok := throttleDelay(resp.StatusCode, resp.Header.Get(retryAfterHeader)); ok {
+		atomic.StoreInt64(&c.throttledUntil, time.Now().Add(delay).UnixNano())
+	}
+
 	return resp.StatusCode, err
 }
 
+// store sends the batch over the connection.
+func (c *NodeClient) store(batch [][]byte, timeout time.Duration) (code int, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	atomic.AddInt32(&c.activeReq, 1)
+	defer atomic.AddInt32(&c.activeReq, -1)
+
+	var size int
+
+	for _, entry := range batch {
+		size += len(entry)
+	}
+
+	started := time.Now()
+
+	atomic.StoreInt64(&c.lastUseTime, started.UnixNano())
+
+	code, err = c.conn.Store(ctx, batch)
+
+	delay, throttled := throttleDelay(code, "")
+
+	var throttle *ThrottleError
+	if errors.As(err, &throttle) {
+		delay, err = throttle.Delay, nil
+	}
+
+	// Partially stored batch has a response code with the error.
+	if code == 0 {
+		c.metrics.Request(c.name, metricsErrorCode, time.Since(started), size)
+	} else {
+		c.metrics.Request(c.name, strconv.Itoa(code), time.Since(started), size)
+	}
+
+	if err != nil {
+		return code, err
+	}
+
+	if throttled {
+		atomic.StoreInt64(&c.throttledUntil, time.Now().Add(delay).UnixNano())
+	}
+
+	return code, nil
+}
+
+func (c *NodeClient) ping(timeout time.Duration) (code int, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	return c.conn.Ping(ctx)
+}
+
+// throttleDelay returns delay of 429 or 503 response requested by its Retry-After
+// header, it is DefaultThrottleDelay without the header and MinThrottleDelay at least.
+func throttleDelay(code int, retryAfter string) (time.Duration, bool) {
+	if code != http.StatusTooManyRequests && code != http.StatusServiceUnavailable {
+		return 0, false
+	}
+
+	delay, ok := parseRetryAfter(retryAfter)
+
+	switch {
+	case !ok:
+		return DefaultThrottleDelay, true
+	case delay < MinThrottleDelay:
+		return MinThrottleDelay, true
+	default:
+		return delay, true
+	}
+}
+
+// parseRetryAfter parses Retry-After header in delay-seconds or http-date form.
+func parseRetryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	date, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+
+	delay := time.Until(date)
+	if delay < 0 {
+		delay = 0
+	}
+
+	return delay, true
+}
+
+func (c *NodeClient) compress(body []byte) (data []byte, encoding string, err error) {
+	if c.compressor == nil || len(body) == 0 || len(body) < c.compressMinSize {
+		return body, "", nil
+	}
+
+	data, err = c.compressor.Compress(body)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return data, c.compressor.Encoding(), nil
+}
+
 func (c *NodeClient) parseURL(addr string) (err error) {
 	parsed, err := url.Parse(addr)
 	if err != nil {
 		return err
 	}
 
-	c.token = "Bearer" + " " + parsed.User.String()
+	if c.auth == nil {
+		c.auth = NewTokenAuth(parsed.User.String())
+	}
 
 	// Drop user info
 	parsed.User = nil
 
 	c.addr = parsed.String()
+	c.name = c.addr
 
 	return nil
 }
+
+// nodeAddr returns the node url without user info.
+func nodeAddr(server string) string {
+	parsed, err := url.Parse(server)
+	if err != nil {
+		return server
+	}
+
+	parsed.User = nil
+
+	return parsed.String()
+}
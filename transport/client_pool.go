@@ -1,10 +1,12 @@
 package transport
 
 import (
-	"crypto/tls"
 	"errors"
 	"net/http"
+	"net/url"
+	"sync"
 	"sync/atomic"
+	"time"
 )
 
 // https://groups.google.com/group/golang-nuts/msg/71c307e4d73024ce?pli=1
@@ -13,37 +15,108 @@ const maxInt = int(^uint(0) >> 1)
 var (
 	ErrNoAvailableClients = errors.New("no available clients")
 	ErrNoAvailableServers = errors.New("no servers available for connection")
+	ErrThrottled          = errors.New("all live clients are throttled")
 )
 
+// connSchemes creates connections of nodes with non-HTTP url schemes.
+var connSchemes = map[string]connFactory{
+	SchemeGRPC:  newGRPCConn,
+	SchemeGRPCS: newGRPCConn,
+
+	SchemeSyslogTCP: newSyslogConn,
+	SchemeSyslogUDP: newSyslogConn,
+	SchemeSyslogTLS: newSyslogConn,
+
+	SchemeLokiHTTP:  newLokiConn,
+	SchemeLokiHTTPS: newLokiConn,
+
+	SchemeElasticHTTP:  newElasticConn,
+	SchemeElasticHTTPS: newElasticConn,
+
+	SchemeOTLPHTTP:  newOTLPConn,
+	SchemeOTLPHTTPS: newOTLPConn,
+
+	SchemeKafka:    newKafkaConn,
+	SchemeKafkaTLS: newKafkaConn,
+
+	SchemeGELFUDP:   newGELFConn,
+	SchemeGELFTCP:   newGELFConn,
+	SchemeGELFHTTP:  newGELFConn,
+	SchemeGELFHTTPS: newGELFConn,
+
+	SchemeUnixRaw: newUnixRawConn,
+
+	SchemeSplunkHTTP:  newSplunkConn,
+	SchemeSplunkHTTPS: newSplunkConn,
+}
+
+type connFactory func(dsn *url.URL, config Config, transport http.RoundTripper) (Conn, error)
+
 type ClientsPool interface {
 	NextLive() (*NodeClient, error)
 	NextDead() (*NodeClient, error)
 	OnFailure(c *NodeClient)
 	OnSuccess(c *NodeClient)
+	// ThrottleDelay returns time until some live client stops being
+	// throttled or zero if any live client is available.
+	ThrottleDelay() time.Duration
+	// Close closes connections of all clients.
+	Close() error
+}
+
+// NewClientsPool returns pool of the servers, insecure skips verification
+// of node certificates.
+//
+// Deprecated: use NewClientsPoolWithConfig.
+func NewClientsPool(servers []string, insecure bool) (ClientsPool, error) {
+	return NewClientsPoolWithConfig(Config{Servers: servers, Insecure: insecure})
 }
 
-func NewClientsPool(servers []string, insecure bool) (pool ClientsPool, err error) {
+// NewClientsPoolWithConfig returns pool of the servers of the config.
+func NewClientsPoolWithConfig(config Config) (pool ClientsPool, err error) {
+	if len(config.Servers) == 0 {
+		return nil, ErrNoAvailableServers
+	}
+
+	if config.tlsConfig, config.tlsRoots, err = newTLSConfig(config); err != nil {
+		return nil, err
+	}
+
+	if config.dialer, err = newDialer(config); err != nil {
+		return nil, err
+	}
+
 	transport := &http.Transport{
+		Proxy:             config.dialer.proxy,
+		DialContext:       config.dialer.dial,
 		ForceAttemptHTTP2: true,
+		TLSClientConfig:   config.tlsConfig,
 	}
 
-	if insecure {
-		transport.TLSClientConfig = &tls.Config{
-			InsecureSkipVerify: true, // nolint:gosec // skip.
-		}
+	compressor, err := NewCompressor(config.Compression)
+	if err != nil {
+		return nil, err
 	}
 
-	if len(servers) == 0 {
-		return nil, ErrNoAvailableServers
+	builder := &nodeBuilder{config: config, transport: transport, compressor: compressor}
+
+	if config.DiscoveryInterval > 0 {
+		return newDiscoveryPool(config, builder)
 	}
 
-	clients := make([]*NodeClient, len(servers))
+	clients := make([]*NodeClient, len(config.Servers))
 
-	for idx, server := range servers {
-		clients[idx], err = NewNodeClient(server, transport)
+	for idx, server := range config.Servers {
+		clients[idx], err = builder.build(server, config.nodeAuth(server))
 		if err != nil {
+			for _, client := range clients[:idx] {
+				_ = client.Close()
+			}
+
 			return nil, err
 		}
+
+		config.Metrics.NodeStatus(clients[idx].name, true)
 	}
 
 	if len(clients) == 1 {
@@ -53,6 +126,93 @@ func NewClientsPool(servers []string, insecure bool) (pool ClientsPool, err erro
 	return &ClusterPool{clients: clients}, nil
 }
 
+// nodeBuilder creates clients of nodes sharing the HTTP transport.
+type nodeBuilder struct {
+	config     Config
+	transport  *http.Transport
+	compressor Compressor
+}
+
+func (b *nodeBuilder) build(server string, auth AuthProvider) (*NodeClient, error) {
+	options := []NodeOption{
+		WithCompressor(b.compressor, b.config.CompressionMinSize),
+		WithMetrics(b.config.Metrics),
+	}
+
+	nodeTransport := http.RoundTripper(b.transport)
+
+	unix, ok, err := newUnixTransport(server, b.config.dialer)
+	if err != nil {
+		return nil, err
+	}
+
+	if ok {
+		nodeTransport = unix
+	} else if b.config.tlsRoots != nil {
+		dsn, err := url.Parse(server)
+		if err != nil {
+			return nil, err
+		}
+
+		// Certificates are verified for the host of the node,
+		// see verifyHost.
+		transport := b.transport.Clone()
+		transport.TLSClientConfig = verifyHost(b.config.tlsConfig, b.config.tlsRoots, dsn.Hostname())
+		nodeTransport = transport
+	}
+
+	connTransport := nodeTransport
+
+	if auth != nil {
+		options = append(options, WithAuth(auth))
+		connTransport = &authTransport{transport: nodeTransport, auth: auth}
+	}
+
+	conn, err := newConn(server, b.config, connTransport)
+	if err != nil {
+		return nil, err
+	}
+
+	if conn != nil {
+		options = append(options, WithConn(conn))
+	}
+
+	client, err := NewNodeClient(server, nodeTransport, options...)
+	if err != nil && conn != nil {
+		_ = conn.Close()
+	}
+
+	return client, err
+}
+
+// nodeAuth returns auth provider of the node or nil if the node uses the token of its url.
+func (c Config) nodeAuth(server string) AuthProvider {
+	if auth, ok := c.NodeAuth[nodeAddr(server)]; ok {
+		return auth
+	}
+
+	return c.Auth
+}
+
+// newConn returns connection for the node with non-HTTP scheme or for
+// streaming HTTP node, it returns nil if the node uses HTTP requests.
+func newConn(server string, config Config, transport http.RoundTripper) (Conn, error) {
+	dsn, err := url.Parse(server)
+	if err != nil {
+		return nil, err
+	}
+
+	if factory, ok := connSchemes[dsn.Scheme]; ok {
+		return factory(dsn, config, transport)
+	}
+
+	if config.Streaming {
+		return newStreamConn(dsn, config, transport)
+	}
+
+	return nil, nil
+}
+
 type SinglePool struct {
 	client *NodeClient
 }
@@ -62,6 +222,10 @@ func (p *SinglePool) NextLive() (*NodeClient, error) {
 		return nil, ErrNoAvailableClients
 	}
 
+	if p.client.IsThrottled() {
+		return nil, ErrThrottled
+	}
+
 	return p.client, nil
 }
 
@@ -81,8 +245,24 @@ func (p *SinglePool) OnSuccess(c *NodeClient) {
 	atomic.StoreInt32(&c.status, isLive)
 }
 
+func (p *SinglePool) ThrottleDelay() time.Duration {
+	if atomic.LoadInt32(&p.client.status) != isLive {
+		return 0
+	}
+
+	return p.client.ThrottleDelay()
+}
+
+func (p *SinglePool) Close() error {
+	return p.client.Close()
+}
+
 type ClusterPool struct {
+	mu      sync.RWMutex
 	clients []*NodeClient
+
+	// discovery re-resolves nodes, nil means the nodes are fixed.
+	discovery *discovery
 }
 
 func (p *ClusterPool) NextLive() (*NodeClient, error) {
@@ -101,17 +281,66 @@ func (p *ClusterPool) OnSuccess(c *NodeClient) {
 	atomic.StoreInt32(&c.status, isLive)
 }
 
+func (p *ClusterPool) ThrottleDelay() time.Duration {
+	var minD time.Duration
+
+	for _, client := range p.nodes() {
+		if atomic.LoadInt32(&client.status) != isLive {
+			continue
+		}
+
+		d := client.ThrottleDelay()
+		if d == 0 {
+			return 0
+		}
+
+		if minD == 0 || d < minD {
+			minD = d
+		}
+	}
+
+	return minD
+}
+
+// Close closes connections of all clients, it returns the first error.
+func (p *ClusterPool) Close() error {
+	var first error
+
+	for _, client := range p.nodes() {
+		if err := client.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+
+	return first
+}
+
+// nodes returns current clients, the slice is replaced on discovery and not modified.
+func (p *ClusterPool) nodes() []*NodeClient {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.clients
+}
+
 func (p *ClusterPool) next(status int32) (*NodeClient, error) {
-	clients := p.clients
+	clients := p.nodes()
 
 	var (
-		minC *NodeClient
-		minR = maxInt
-		minT = maxInt
+		minC      *NodeClient
+		minR      = maxInt
+		minT      = maxInt
+		throttled bool
 	)
 
 	for _, client := range clients {
-		if atomic.LoadInt32(&client.status) != status {
+		if atomic.LoadInt32(&client.status) != status || atomic.LoadInt32(&client.retired) == 1 {
+			continue
+		}
+
+		if status == isLive && client.IsThrottled() {
+			throttled = true
+
 			continue
 		}
 
@@ -125,6 +354,10 @@ func (p *ClusterPool) next(status int32) (*NodeClient, error) {
 		}
 	}
 
+	if minC == nil && throttled {
+		return nil, ErrThrottled
+	}
+
 	if minC == nil {
 		return nil, ErrNoAvailableClients
 	}
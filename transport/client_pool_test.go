@@ -1,6 +1,9 @@
 package transport
 
 import (
+	"net/http"
+	"net/url"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -57,6 +60,20 @@ func TestNewClientsPool(t *testing.T) {
 	}
 }
 
+func TestNewClientsPoolWithConfig_CloseOnError(t *testing.T) {
+	conn := &stubConn{}
+
+	connSchemes["stub"] = func(*url.URL, Config, http.RoundTripper) (Conn, error) {
+		return conn, nil
+	}
+
+	defer delete(connSchemes, "stub")
+
+	_, err := NewClientsPoolWithConfig(Config{Servers: []string{"stub://127.0.0.1:9200", "*http://127.0.0.1:9201"}})
+	assert.Error(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&conn.closed))
+}
+
 func TestSinglePool(t *testing.T) {
 	pool := SinglePool{
 		client: &NodeClient{
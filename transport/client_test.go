@@ -205,3 +205,135 @@ func TestNodeClient_SendRequest(t *testing.T) {
 		})
 	}
 }
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		name        string
+		input       string
+		expectedRes time.Duration
+		expectedOk  bool
+	}{
+		{
+			name:       "Empty",
+			input:      "",
+			expectedOk: false,
+		},
+		{
+			name:        "Seconds",
+			input:       "120",
+			expectedRes: 2 * time.Minute,
+			expectedOk:  true,
+		},
+		{
+			name:       "NegativeSeconds",
+			input:      "-1",
+			expectedOk: false,
+		},
+		{
+			name:        "PastDate",
+			input:       "Wed, 21 Oct 2015 07:28:00 GMT",
+			expectedRes: 0,
+			expectedOk:  true,
+		},
+		{
+			name:       "Invalid",
+			input:      "soon",
+			expectedOk: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, ok := parseRetryAfter(tt.input)
+
+			assert.Equal(t, tt.expectedOk, ok)
+			assert.Equal(t, tt.expectedRes, res)
+		})
+	}
+}
+
+func TestNodeClient_SendRequestThrottled(t *testing.T) {
+	tests := []struct {
+		name              string
+		handler           http.HandlerFunc
+		expectedCode      int
+		expectedThrottled bool
+	}{
+		{
+			name: "TooManyRequests",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set(retryAfterHeader, "10")
+				w.WriteHeader(http.StatusTooManyRequests)
+			},
+			expectedCode:      http.StatusTooManyRequests,
+			expectedThrottled: true,
+		},
+		{
+			name: "TooManyRequestsWithoutRetryAfter",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusTooManyRequests)
+			},
+			expectedCode:      http.StatusTooManyRequests,
+			expectedThrottled: true,
+		},
+		{
+			name: "ServiceUnavailable",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set(retryAfterHeader, "10")
+				w.WriteHeader(http.StatusServiceUnavailable)
+			},
+			expectedCode:      http.StatusServiceUnavailable,
+			expectedThrottled: true,
+		},
+		{
+			name: "ServiceUnavailableWithoutRetryAfter",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			},
+			expectedCode:      http.StatusServiceUnavailable,
+			expectedThrottled: true,
+		},
+		{
+			name: "RetryAfterZero",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set(retryAfterHeader, "0")
+				w.WriteHeader(http.StatusTooManyRequests)
+			},
+			expectedCode:      http.StatusTooManyRequests,
+			expectedThrottled: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := httptest.NewServer(tt.handler)
+			defer ts.Close()
+
+			client := NodeClient{addr: ts.URL, client: ts.Client()}
+
+			code, err := client.SendRequest([]byte(`{"message":"some message"}`), time.Second)
+			assert.Nil(t, err)
+			assert.Equal(t, tt.expectedCode, code)
+			assert.Equal(t, tt.expectedThrottled, client.IsThrottled())
+		})
+	}
+}
+
+func TestNodeClient_StoreThrottled(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(retryAfterHeader, "10")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer ts.Close()
+
+	conn, err := newConn("loki+"+ts.URL, Config{}, ts.Client().Transport)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := NodeClient{addr: ts.URL, conn: conn}
+
+	// Retry-After of the conn response is forwarded to the client.
+	code, err := client.SendBatchRequest([][]byte{[]byte(`{"message":"some message"}`)}, time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusTooManyRequests, code)
+	assert.True(t, client.ThrottleDelay() > 9*time.Second)
+}
@@ -0,0 +1,118 @@
+package transport
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"sync"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	CompressionNone   = ""
+	CompressionGzip   = "gzip"
+	CompressionZstd   = "zstd"
+	CompressionSnappy = "snappy"
+)
+
+const contentEncodingHeader = "Content-Encoding"
+
+var ErrUnknownCompression = errors.New("unknown compression")
+
+// Compressor encodes request bodies. Encoding returns
+// the Content-Encoding header value of compressed data.
+type Compressor interface {
+	Encoding() string
+	Compress(data []byte) ([]byte, error)
+}
+
+// ValidCompression reports whether the compression name is supported.
+func ValidCompression(name string) bool {
+	switch name {
+	case CompressionNone, CompressionGzip, CompressionZstd, CompressionSnappy:
+		return true
+	default:
+		return false
+	}
+}
+
+// NewCompressor returns compressor by name or nil for CompressionNone.
+func NewCompressor(name string) (Compressor, error) {
+	switch name {
+	case CompressionNone:
+		return nil, nil
+	case CompressionGzip:
+		return &GzipCompressor{}, nil
+	case CompressionZstd:
+		return NewZstdCompressor()
+	case CompressionSnappy:
+		return SnappyCompressor{}, nil
+	default:
+		return nil, ErrUnknownCompression
+	}
+}
+
+type GzipCompressor struct {
+	pool sync.Pool
+}
+
+func (c *GzipCompressor) Encoding() string {
+	return CompressionGzip
+}
+
+func (c *GzipCompressor) Compress(data []byte) ([]byte, error) {
+	buf := bytes.NewBuffer(make([]byte, 0, len(data)/2))
+
+	writer, ok := c.pool.Get().(*gzip.Writer)
+	if ok {
+		writer.Reset(buf)
+	} else {
+		writer = gzip.NewWriter(buf)
+	}
+
+	defer c.pool.Put(writer)
+
+	if _, err := writer.Write(data); err != nil {
+		return nil, err
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+type ZstdCompressor struct {
+	encoder *zstd.Encoder
+}
+
+func NewZstdCompressor() (*ZstdCompressor, error) {
+	encoder, err := zstd.NewWriter(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ZstdCompressor{encoder: encoder}, nil
+}
+
+func (c *ZstdCompressor) Encoding() string {
+	return CompressionZstd
+}
+
+func (c *ZstdCompressor) Compress(data []byte) ([]byte, error) {
+	return c.encoder.EncodeAll(data, make([]byte, 0, len(data)/2)), nil
+}
+
+// SnappyCompressor uses snappy block format.
+type SnappyCompressor struct{}
+
+func (SnappyCompressor) Encoding() string {
+	return CompressionSnappy
+}
+
+func (SnappyCompressor) Compress(data []byte) ([]byte, error) {
+	return snappy.Encode(nil, data), nil
+}
@@ -0,0 +1,185 @@
+package transport
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewCompressor(t *testing.T) {
+	tests := []struct {
+		name        string
+		input       string
+		wantErr     bool
+		expectedRes interface{}
+		expectedErr string
+	}{
+		{
+			name:        "None",
+			input:       CompressionNone,
+			expectedRes: nil,
+		},
+		{
+			name:        "Gzip",
+			input:       CompressionGzip,
+			expectedRes: &GzipCompressor{},
+		},
+		{
+			name:        "Zstd",
+			input:       CompressionZstd,
+			expectedRes: &ZstdCompressor{},
+		},
+		{
+			name:        "Snappy",
+			input:       CompressionSnappy,
+			expectedRes: SnappyCompressor{},
+		},
+		{
+			name:        "Error",
+			input:       "lz4",
+			wantErr:     true,
+			expectedErr: ErrUnknownCompression.Error(),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := NewCompressor(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Error(err)
+			}
+
+			if tt.wantErr {
+				assert.EqualError(t, err, tt.expectedErr)
+
+				return
+			}
+
+			assert.Equal(t, ValidCompression(tt.input), true)
+			assert.IsType(t, tt.expectedRes, res)
+		})
+	}
+}
+
+func TestNodeClient_SendRequestCompressed(t *testing.T) {
+	ts := httptest.NewUnstartedServer(nil)
+	ts.EnableHTTP2 = true
+
+	ts.StartTLS()
+	defer ts.Close()
+
+	var (
+		largeBody = []byte(`[` + strings.Repeat(`{"message":"some message"},`, 100) + `{}]`)
+		smallBody = []byte(`{"message":"some message"}`)
+	)
+
+	tests := []struct {
+		name             string
+		compression      string
+		body             []byte
+		expectedEncoding string
+	}{
+		{
+			name:             "Gzip",
+			compression:      CompressionGzip,
+			body:             largeBody,
+			expectedEncoding: "gzip",
+		},
+		{
+			name:             "Zstd",
+			compression:      CompressionZstd,
+			body:             largeBody,
+			expectedEncoding: "zstd",
+		},
+		{
+			name:             "Snappy",
+			compression:      CompressionSnappy,
+			body:             largeBody,
+			expectedEncoding: "snappy",
+		},
+		{
+			name:             "BelowMinSize",
+			compression:      CompressionGzip,
+			body:             smallBody,
+			expectedEncoding: "",
+		},
+		{
+			name:             "None",
+			compression:      CompressionNone,
+			body:             largeBody,
+			expectedEncoding: "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, tt.expectedEncoding, r.Header.Get(contentEncodingHeader))
+				assert.Equal(t, tt.body, decodeBody(t, r))
+
+				w.WriteHeader(http.StatusOK)
+			})
+
+			compressor, err := NewCompressor(tt.compression)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			client := NodeClient{addr: ts.URL, client: ts.Client()}
+
+			WithCompressor(compressor, 100)(&client)
+
+			code, err := client.SendRequest(tt.body, time.Second)
+			assert.Nil(t, err)
+			assert.Equal(t, http.StatusOK, code)
+		})
+	}
+}
+
+func decodeBody(t *testing.T, r *http.Request) []byte {
+	t.Helper()
+
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	switch r.Header.Get(contentEncodingHeader) {
+	case CompressionGzip:
+		reader, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		data, err = ioutil.ReadAll(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+	case CompressionZstd:
+		decoder, err := zstd.NewReader(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer decoder.Close()
+
+		data, err = decoder.DecodeAll(data, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+	case CompressionSnappy:
+		data, err = snappy.Decode(nil, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return data
+}
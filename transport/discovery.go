@@ -0,0 +1,328 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultResolveTimeout limits lookups of one discovery round.
+	DefaultResolveTimeout = 5 * time.Second
+
+	// retirePollInterval is an interval of checking requests of removed nodes.
+	retirePollInterval = 100 * time.Millisecond
+)
+
+// ErrDiscoveryProxy is returned when nodes of A records would be resolved
+// for a proxy, the proxy connects to the host instead of the resolved address.
+var ErrDiscoveryProxy = errors.New("discovery of host addresses is not supported with proxy")
+
+// Resolver looks up addresses of discovered nodes, *net.Resolver implements it.
+type Resolver interface {
+	LookupHost(ctx context.Context, host string) (addrs []string, err error)
+	LookupSRV(ctx context.Context, service, proto, name string) (cname string, addrs []*net.SRV, err error)
+}
+
+// discovery resolves hosts of server urls to nodes. Hosts starting with
+// underscore are SRV names, e.g. _logs._tcp.collector.svc, every record is
+// a node with the target and port of the record. Other host names are
+// resolved to A and AAAA records, every address is a node which keeps
+// the host name for TLS and Host header but connects to the address and
+// is identified by it in metrics and hooks. Hosts are resolved to addresses
+// only without a proxy.
+type discovery struct {
+	servers  []string
+	resolver Resolver
+	builder  *nodeBuilder
+
+	// nodes are current nodes by keys of their addresses,
+	// they are modified only by one discovery round at a time.
+	nodes map[string]*discoveredNode
+}
+
+type discoveredNode struct {
+	server    string
+	client    *NodeClient
+	transport *http.Transport
+}
+
+func newDiscoveryPool(config Config, builder *nodeBuilder) (*ClusterPool, error) {
+	if config.dialer.proxy != nil {
+		for _, server := range config.Servers {
+			dsn, err := url.Parse(server)
+			if err != nil {
+				return nil, err
+			}
+
+			if discoverable(dsn) && !strings.HasPrefix(dsn.Hostname(), "_") {
+				return nil, ErrDiscoveryProxy
+			}
+		}
+	}
+
+	resolver := config.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+
+	pool := &ClusterPool{
+		discovery: &discovery{
+			servers:  config.Servers,
+			resolver: resolver,
+			builder:  builder,
+			nodes:    make(map[string]*discoveredNode),
+		},
+	}
+
+	// Nodes of the first round are live as nodes of fixed pools.
+	if _, err := pool.discover(isLive); err != nil {
+		return nil, err
+	}
+
+	return pool, nil
+}
+
+// discover resolves nodes, new nodes are added with the status and nodes
+// which are no longer resolved are closed after their requests finish.
+// Nodes of servers failed to resolve are kept.
+func (p *ClusterPool) discover(status int32) (added int, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultResolveTimeout)
+	defer cancel()
+
+	d := p.discovery
+
+	nodes, err := d.resolve(ctx)
+	if err != nil && len(nodes) == 0 && len(d.nodes) == 0 {
+		return 0, err
+	}
+
+	keys := make([]string, 0, len(nodes))
+
+	for key := range nodes {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	clients := make([]*NodeClient, 0, len(nodes))
+
+	for _, key := range keys {
+		node := nodes[key]
+
+		if _, ok := d.nodes[key]; !ok {
+			atomic.StoreInt32(&node.client.status, status)
+
+			d.builder.config.Metrics.NodeStatus(node.client.name, status == isLive)
+
+			added++
+		}
+
+		clients = append(clients, node.client)
+	}
+
+	var removed []*discoveredNode
+
+	for key, node := range d.nodes {
+		if _, ok := nodes[key]; !ok {
+			removed = append(removed, node)
+		}
+	}
+
+	d.nodes = nodes
+
+	p.mu.Lock()
+	p.clients = clients
+
+	// Senders holding previous clients skip retired nodes.
+	for _, node := range removed {
+		atomic.StoreInt32(&node.client.retired, 1)
+	}
+
+	p.mu.Unlock()
+
+	for _, node := range removed {
+		d.builder.config.Metrics.NodeRemoved(node.client.name)
+
+		go node.retire()
+	}
+
+	return added, err
+}
+
+// resolve returns nodes of all servers, current nodes are reused.
+func (d *discovery) resolve(ctx context.Context) (map[string]*discoveredNode, error) {
+	var (
+		nodes   = make(map[string]*discoveredNode)
+		lastErr error
+	)
+
+	for _, server := range d.servers {
+		if err := d.resolveServer(ctx, server, nodes); err != nil {
+			lastErr = err
+
+			for key, node := range d.nodes {
+				if node.server == server {
+					nodes[key] = node
+				}
+			}
+		}
+	}
+
+	return nodes, lastErr
+}
+
+func (d *discovery) resolveServer(ctx context.Context, server string, nodes map[string]*discoveredNode) error {
+	dsn, err := url.Parse(server)
+	if err != nil {
+		return err
+	}
+
+	host := dsn.Hostname()
+
+	switch {
+	case !discoverable(dsn):
+		return d.add(nodes, server, server, "", "")
+	case strings.HasPrefix(host, "_"):
+		_, records, err := d.resolver.LookupSRV(ctx, "", "", host)
+		if err != nil {
+			return err
+		}
+
+		for _, record := range records {
+			node := *dsn
+			node.Host = net.JoinHostPort(strings.TrimSuffix(record.Target, "."), strconv.Itoa(int(record.Port)))
+
+			if err := d.add(nodes, node.String(), server, "", ""); err != nil {
+				return err
+			}
+		}
+	default:
+		addrs, err := d.resolver.LookupHost(ctx, host)
+		if err != nil {
+			return err
+		}
+
+		for _, addr := range addrs {
+			if err := d.add(nodes, server, server, host, addr); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// add adds the node reusing current node with the same key,
+// nodes with the address connect to it instead of the host.
+func (d *discovery) add(nodes map[string]*discoveredNode, nodeURL, server, host, addr string) error {
+	key := nodeURL
+	if addr != "" {
+		key += "@" + addr
+	}
+
+	if node, ok := d.nodes[key]; ok {
+		nodes[key] = node
+
+		return nil
+	}
+
+	node := &discoveredNode{server: server}
+	builder := d.builder
+
+	if addr != "" {
+		node.transport = builder.transport.Clone()
+		node.transport.DialContext = pinDial(builder.config.dialer.dial, host, addr)
+
+		pinned := *builder.config.dialer
+		pinned.dial = node.transport.DialContext
+
+		builder = &nodeBuilder{config: builder.config, transport: node.transport, compressor: builder.compressor}
+		builder.config.dialer = &pinned
+	}
+
+	client, err := builder.build(nodeURL, d.builder.config.nodeAuth(server))
+	if err != nil {
+		return err
+	}
+
+	if addr != "" {
+		client.name = addrURL(nodeURL, addr)
+	}
+
+	node.client = client
+	nodes[key] = node
+
+	return nil
+}
+
+// retire closes the node after its in-flight requests finish,
+// the node is marked as retired by the discovery round before.
+func (n *discoveredNode) retire() {
+	for {
+		time.Sleep(retirePollInterval)
+
+		if n.client.ActiveRequests() == 0 {
+			break
+		}
+	}
+
+	_ = n.client.Close()
+
+	if n.transport != nil {
+		n.transport.CloseIdleConnections()
+	}
+}
+
+// discoverable reports whether nodes of the url are resolved with DNS,
+// unix sockets, ip addresses and kafka brokers are used as is.
+func discoverable(dsn *url.URL) bool {
+	switch {
+	case dsn.Scheme == SchemeUnix, dsn.Scheme == SchemeUnixRaw:
+		return false
+	case dsn.Scheme == SchemeKafka, dsn.Scheme == SchemeKafkaTLS:
+		return false
+	case dsn.Hostname() == "", net.ParseIP(dsn.Hostname()) != nil:
+		return false
+	default:
+		return true
+	}
+}
+
+// addrURL returns the node url without user info with the address as host.
+func addrURL(nodeURL, addr string) string {
+	dsn, err := url.Parse(nodeURL)
+	if err != nil {
+		return nodeURL
+	}
+
+	dsn.User = nil
+
+	if port := dsn.Port(); port != "" {
+		dsn.Host = net.JoinHostPort(addr, port)
+	} else if strings.Contains(addr, ":") {
+		dsn.Host = "[" + addr + "]"
+	} else {
+		dsn.Host = addr
+	}
+
+	return dsn.String()
+}
+
+// pinDial connects to the address instead of the host.
+func pinDial(dial DialFunc, host, addr string) DialFunc {
+	return func(ctx context.Context, network, target string) (net.Conn, error) {
+		if targetHost, port, err := net.SplitHostPort(target); err == nil && targetHost == host {
+			target = net.JoinHostPort(addr, port)
+		}
+
+		return dial(ctx, network, target)
+	}
+}
@@ -0,0 +1,278 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/loghole/lhw/metrics"
+)
+
+var errNoSuchHost = errors.New("no such host")
+
+type stubResolver struct {
+	mu    sync.Mutex
+	hosts map[string][]string
+	srv   map[string][]*net.SRV
+	err   error
+}
+
+func (r *stubResolver) set(hosts map[string][]string, srv map[string][]*net.SRV, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.hosts, r.srv, r.err = hosts, srv, err
+}
+
+func (r *stubResolver) LookupHost(_ context.Context, host string) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	return r.hosts[host], nil
+}
+
+func (r *stubResolver) LookupSRV(_ context.Context, _, _, name string) (string, []*net.SRV, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.err != nil {
+		return "", nil, r.err
+	}
+
+	return name, r.srv[name], nil
+}
+
+type stubConn struct {
+	closed int32
+}
+
+func (c *stubConn) Store(context.Context, [][]byte) (int, error) { return http.StatusOK, nil }
+func (c *stubConn) Ping(context.Context) (int, error)            { return http.StatusOK, nil }
+
+func (c *stubConn) Close() error {
+	atomic.StoreInt32(&c.closed, 1)
+
+	return nil
+}
+
+func poolAddrs(pool *ClusterPool) []string {
+	var addrs []string
+
+	for _, client := range pool.nodes() {
+		addrs = append(addrs, client.addr)
+	}
+
+	return addrs
+}
+
+func poolNames(pool *ClusterPool) []string {
+	var names []string
+
+	for _, client := range pool.nodes() {
+		names = append(names, client.name)
+	}
+
+	return names
+}
+
+func TestClusterPool_Discover(t *testing.T) {
+	var hosts []string
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hosts = append(hosts, r.Host)
+	}))
+	defer ts.Close()
+
+	_, port, _ := net.SplitHostPort(ts.Listener.Addr().String())
+
+	resolver := &stubResolver{}
+	resolver.set(
+		map[string][]string{"collector.test": {"127.0.0.1"}},
+		map[string][]*net.SRV{"_logs._tcp.test": {{Target: "node1.test.", Port: 9200}}},
+		nil,
+	)
+
+	pool, err := NewClientsPoolWithConfig(Config{
+		Servers:           []string{"http://token@collector.test:" + port, "http://_logs._tcp.test", "http://127.0.0.1:9300"},
+		DiscoveryInterval: time.Minute,
+		Resolver:          resolver,
+		Metrics:           metrics.New(metrics.NewExpvar("lhw_discovery_test")),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cluster := pool.(*ClusterPool)
+
+	assert.Equal(t, []string{
+		"http://127.0.0.1:9300",
+		"http://node1.test:9200",
+		"http://collector.test:" + port,
+	}, poolAddrs(cluster))
+
+	// Nodes of A records connect to the address and keep the host name.
+	collector := cluster.nodes()[2]
+
+	code, err := collector.SendRequest([]byte(`{"message":"1"}`), time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, []string{"collector.test:" + port}, hosts)
+
+	resolver.set(map[string][]string{"collector.test": {"127.0.0.1", "127.0.0.2"}}, nil, nil)
+
+	added, err := cluster.discover(isDead)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, added)
+	assert.Equal(t, []string{
+		"http://127.0.0.1:9300",
+		"http://collector.test:" + port,
+		"http://collector.test:" + port,
+	}, poolAddrs(cluster))
+
+	assert.Same(t, collector, cluster.nodes()[1])
+	assert.Equal(t, isDead, atomic.LoadInt32(&cluster.nodes()[2].status))
+
+	// Nodes of A records are identified by their addresses.
+	assert.Equal(t, []string{
+		"http://127.0.0.1:9300",
+		"http://127.0.0.1:" + port,
+		"http://127.0.0.2:" + port,
+	}, poolNames(cluster))
+
+	resolver.set(map[string][]string{"collector.test": {"127.0.0.2"}}, nil, nil)
+
+	added, err = cluster.discover(isDead)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, added)
+	assert.Equal(t, []string{"http://127.0.0.1:9300", "http://127.0.0.2:" + port}, poolNames(cluster))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&collector.retired))
+	assert.Nil(t, expvar.Get("lhw_discovery_test").(*expvar.Map).Get(`lhw_node_up{node="http://127.0.0.1:`+port+`"}`))
+
+	// Nodes of servers failed to resolve are kept.
+	resolver.set(nil, nil, errNoSuchHost)
+
+	added, err = cluster.discover(isDead)
+	assert.ErrorIs(t, err, errNoSuchHost)
+	assert.Equal(t, 0, added)
+	assert.Len(t, cluster.nodes(), 2)
+}
+
+func TestNewClientsPool_DiscoveryError(t *testing.T) {
+	resolver := &stubResolver{}
+	resolver.set(nil, nil, errNoSuchHost)
+
+	_, err := NewClientsPoolWithConfig(Config{
+		Servers:           []string{"http://collector.test"},
+		DiscoveryInterval: time.Minute,
+		Resolver:          resolver,
+	})
+	assert.ErrorIs(t, err, errNoSuchHost)
+}
+
+func TestNewClientsPool_DiscoveryProxy(t *testing.T) {
+	tests := []struct {
+		name        string
+		server      string
+		expectedErr error
+	}{
+		{
+			name:        "Host",
+			server:      "http://collector.test",
+			expectedErr: ErrDiscoveryProxy,
+		},
+		{
+			name:   "SRV",
+			server: "http://_logs._tcp.test",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewClientsPoolWithConfig(Config{
+				Servers:           []string{tt.server},
+				Proxy:             "http://proxy.test:3128",
+				DiscoveryInterval: time.Minute,
+				Resolver:          &stubResolver{},
+			})
+			assert.ErrorIs(t, err, tt.expectedErr)
+		})
+	}
+}
+
+func TestClusterPool_NextRetired(t *testing.T) {
+	retired := &NodeClient{status: isLive, retired: 1}
+	pool := &ClusterPool{clients: []*NodeClient{retired}}
+
+	_, err := pool.NextLive()
+	assert.ErrorIs(t, err, ErrNoAvailableClients)
+}
+
+func TestDiscoveredNode_Retire(t *testing.T) {
+	conn := &stubConn{}
+	node := &discoveredNode{client: &NodeClient{conn: conn, activeReq: 1}}
+
+	go node.retire()
+
+	time.Sleep(3 * retirePollInterval)
+
+	assert.Equal(t, int32(0), atomic.LoadInt32(&conn.closed))
+
+	atomic.AddInt32(&node.client.activeReq, -1)
+
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&conn.closed) == 1
+	}, time.Second, retirePollInterval)
+}
+
+func TestTransport_DiscoverNodes(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	_, port, _ := net.SplitHostPort(ts.Listener.Addr().String())
+
+	resolver := &stubResolver{}
+
+	tr, err := New(Config{
+		Servers:           []string{"http://collector.test:" + port},
+		RequestTimeout:    time.Second,
+		PingInterval:      10 * time.Millisecond,
+		SuccessCodes:      []int{http.StatusOK},
+		DiscoveryInterval: 10 * time.Millisecond,
+		Resolver:          resolver,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.ErrorIs(t, tr.Send([]byte(`{"message":"1"}`)), ErrNoAvailableClients)
+
+	resolver.set(map[string][]string{"collector.test": {"127.0.0.1"}}, nil, nil)
+
+	// The new node receives entries after a successful ping.
+	<-tr.IsReconnected()
+
+	assert.Nil(t, tr.Send([]byte(`{"message":"1"}`)))
+	assert.True(t, strings.HasSuffix(tr.(*httpTransport).clientsPool.(*ClusterPool).nodes()[0].addr, port))
+
+	// Discovery stops on close.
+	assert.Nil(t, tr.Close())
+
+	resolver.set(map[string][]string{"collector.test": {"127.0.0.1", "127.0.0.2"}}, nil, nil)
+
+	time.Sleep(50 * time.Millisecond)
+
+	assert.Len(t, tr.(*httpTransport).clientsPool.(*ClusterPool).nodes(), 1)
+}
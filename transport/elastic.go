@@ -0,0 +1,320 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	SchemeElasticHTTP  = "es+http"
+	SchemeElasticHTTPS = "es+https"
+)
+
+const (
+	elasticBulkURI = "/_bulk"
+
+	elasticIndexParam  = "index"
+	elasticAPIKeyParam = "api_key"
+)
+
+// DefaultElasticIndex is an index pattern of entries, see newElasticConn.
+const DefaultElasticIndex = "lhw-{date:2006.01.02}"
+
+// elasticDatePrefix starts the date segment of the index pattern.
+const elasticDatePrefix = "date:"
+
+// elasticYearLayout starts the date layout written in the pattern text.
+const elasticYearLayout = "2006"
+
+// elasticMaxIndexLen is a limit of index name length in bytes.
+const elasticMaxIndexLen = 255
+
+// elasticIndexReplacer replaces characters not allowed in index names.
+var elasticIndexReplacer = strings.NewReplacer(
+	"/", "_", `\`, "_", "*", "_", "?", "_", `"`, "_", "<", "_",
+	">", "_", "|", "_", ",", "_", "#", "_", ":", "_", " ", "_",
+)
+
+// elasticDefaultIndex is used for entries with fields making the index name invalid.
+var elasticDefaultIndex = parseIndexPattern(DefaultElasticIndex)
+
+// elasticConn sends entries with bulk API of Elasticsearch or OpenSearch.
+// Index of the entry is built from the pattern: {field} is replaced with
+// value of the entry field, {date:layout} and a layout starting with 2006
+// outside of braces with the entry time formatted with the layout, other
+// text is copied as is, e.g. logs-{namespace}-{date:2006.01.02} or
+// logs-{namespace}-2006.01.02. Characters not allowed in index names are
+// replaced in field values, the default index is used if the name is invalid.
+type elasticConn struct {
+	client *http.Client
+	addr   string
+	user   *url.Userinfo
+	apiKey string
+	index  []indexPart
+}
+
+// indexPart is a field name, a time layout or a text of the index pattern.
+type indexPart struct {
+	field  string
+	layout string
+	text   string
+}
+
+type elasticResponse struct {
+	Errors bool                             `json:"errors"`
+	Items  []map[string]elasticResponseItem `json:"items"`
+}
+
+type elasticResponseItem struct {
+	Status int `json:"status"`
+}
+
+func newElasticConn(dsn *url.URL, _ Config, transport http.RoundTripper) (Conn, error) {
+	query := dsn.Query()
+
+	pattern := query.Get(elasticIndexParam)
+	if pattern == "" {
+		pattern = DefaultElasticIndex
+	}
+
+	addr := url.URL{
+		Scheme: strings.TrimPrefix(dsn.Scheme, "es+"),
+		Host:   dsn.Host,
+		Path:   strings.TrimSuffix(dsn.Path, "/"),
+	}
+
+	return &elasticConn{
+		client: &http.Client{Transport: transport},
+		addr:   addr.String(),
+		user:   dsn.User,
+		apiKey: query.Get(elasticAPIKeyParam),
+		index:  parseIndexPattern(pattern),
+	}, nil
+}
+
+// Store sends the batch in one bulk request. Entries failed with 429 or 5xx
+// item status are returned for retry, entries failed with other statuses
+// are rejected.
+func (c *elasticConn) Store(ctx context.Context, batch [][]byte) (code int, err error) {
+	resp, err := c.do(ctx, http.MethodPost, elasticBulkURI, c.bulk(batch, time.Now()))
+	if err != nil {
+		return 0, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, throttleError(resp)
+	}
+
+	var result elasticResponse
+
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return 0, err
+	}
+
+	if !result.Errors {
+		return http.StatusOK, nil
+	}
+
+	partial := &PartialError{}
+
+	for idx, item := range result.Items {
+		for _, action := range item {
+			switch {
+			case action.Status < 300:
+			case action.Status == http.StatusTooManyRequests, action.Status >= 500:
+				partial.Retry = append(partial.Retry, idx)
+			default:
+				partial.Rejected = append(partial.Rejected, idx)
+			}
+		}
+	}
+
+	if len(partial.Retry) == 0 && len(partial.Rejected) == 0 {
+		return http.StatusOK, nil
+	}
+
+	return http.StatusOK, partial
+}
+
+func (c *elasticConn) Ping(ctx context.Context) (code int, err error) {
+	resp, err := c.do(ctx, http.MethodGet, "/", nil)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := resp.Body.Close(); err != nil {
+		return 0, err
+	}
+
+	return resp.StatusCode, nil
+}
+
+func (c *elasticConn) Close() error {
+	return nil
+}
+
+func (c *elasticConn) do(ctx context.Context, method, uri string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.addr+uri, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	if body != nil {
+		req.Header.Set(contentTypeHeader, ndjsonContentType)
+	}
+
+	switch {
+	case c.apiKey != "":
+		req.Header.Set(authorizationHeader, "ApiKey"+" "+c.apiKey)
+	case c.user != nil:
+		password, _ := c.user.Password()
+		req.SetBasicAuth(c.user.Username(), password)
+	}
+
+	return c.client.Do(req)
+}
+
+// bulk encodes create action and source of every entry.
+func (c *elasticConn) bulk(batch [][]byte, now time.Time) []byte {
+	buf := &bytes.Buffer{}
+
+	for _, entry := range batch {
+		entry = bytes.TrimSpace(entry)
+
+		action, _ := json.Marshal(map[string]map[string]string{
+			"create": {"_index": c.indexName(entry, now)},
+		})
+
+		buf.Write(action)
+		buf.WriteByte('\n')
+		// Line breaks are json whitespace, so they are replaced to keep one entry per line.
+		buf.Write(bytes.ReplaceAll(entry, []byte{'\n'}, []byte{' '}))
+		buf.WriteByte('\n')
+	}
+
+	return buf.Bytes()
+}
+
+// indexName builds index of the entry, missing fields are replaced with "unknown".
+func (c *elasticConn) indexName(entry []byte, now time.Time) string {
+	fields := make(map[string]interface{})
+
+	_ = json.Unmarshal(entry, &fields)
+
+	stamp := now
+
+	if value, ok := fields["time"].(string); ok {
+		if parsed, err := time.Parse(time.RFC3339Nano, value); err == nil {
+			stamp = parsed
+		}
+	}
+
+	if name := formatIndex(c.index, fields, stamp); validIndex(name) {
+		return name
+	}
+
+	return formatIndex(elasticDefaultIndex, fields, stamp)
+}
+
+func formatIndex(parts []indexPart, fields map[string]interface{}, stamp time.Time) string {
+	var b strings.Builder
+
+	for _, part := range parts {
+		switch {
+		case part.layout != "":
+			b.WriteString(stamp.UTC().Format(part.layout))
+		case part.field != "":
+			value, ok := fields[part.field].(string)
+			if !ok || value == "" {
+				value = "unknown"
+			}
+
+			b.WriteString(elasticIndexReplacer.Replace(value))
+		default:
+			b.WriteString(part.text)
+		}
+	}
+
+	// Index names must be lowercase.
+	return strings.ToLower(b.String())
+}
+
+// validIndex reports whether the name is accepted as index name.
+func validIndex(name string) bool {
+	if name == "" || name == "." || name == ".." || len(name) > elasticMaxIndexLen {
+		return false
+	}
+
+	return !strings.ContainsAny(name[:1], "-_+")
+}
+
+func parseIndexPattern(pattern string) []indexPart {
+	var parts []indexPart
+
+	for pattern != "" {
+		start := strings.IndexByte(pattern, '{')
+		end := strings.IndexByte(pattern, '}')
+
+		if start < 0 || end < start {
+			parts = append(parts, parseIndexText(pattern)...)
+
+			break
+		}
+
+		if start > 0 {
+			parts = append(parts, parseIndexText(pattern[:start])...)
+		}
+
+		if name := pattern[start+1 : end]; strings.HasPrefix(name, elasticDatePrefix) {
+			parts = append(parts, indexPart{layout: strings.TrimPrefix(name, elasticDatePrefix)})
+		} else {
+			parts = append(parts, indexPart{field: name})
+		}
+
+		pattern = pattern[end+1:]
+	}
+
+	return parts
+}
+
+// parseIndexText splits the text into text and date layout parts, the layout
+// starts with 2006 and lasts while digits and separators follow.
+func parseIndexText(text string) []indexPart {
+	var parts []indexPart
+
+	for text != "" {
+		start := strings.Index(text, elasticYearLayout)
+		if start < 0 {
+			parts = append(parts, indexPart{text: text})
+
+			break
+		}
+
+		end := start + len(elasticYearLayout)
+
+		for end < len(text) && strings.IndexByte("0123456789.-_", text[end]) >= 0 {
+			end++
+		}
+
+		// Trailing separators belong to the text.
+		for strings.IndexByte(".-_", text[end-1]) >= 0 {
+			end--
+		}
+
+		if start > 0 {
+			parts = append(parts, indexPart{text: text[:start]})
+		}
+
+		parts = append(parts, indexPart{layout: text[start:end]})
+		text = text[end:]
+	}
+
+	return parts
+}
@@ -0,0 +1,172 @@
+package transport
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestElasticConn_indexName(t *testing.T) {
+	now := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+
+	tests := []struct {
+		name        string
+		pattern     string
+		entry       string
+		expectedRes string
+	}{
+		{
+			name:        "Default",
+			pattern:     DefaultElasticIndex,
+			entry:       `{"message":"m"}`,
+			expectedRes: "lhw-2021.03.04",
+		},
+		{
+			name:        "Fields",
+			pattern:     "logs-{namespace}-{source}-{date:2006.01}",
+			entry:       `{"namespace":"Prod","time":"2020-12-31T23:00:00-02:00"}`,
+			expectedRes: "logs-prod-unknown-2021.01",
+		},
+		{
+			name:        "DigitsInText",
+			pattern:     "logs-v1-{namespace}-{date:2006.01.02}",
+			entry:       `{"namespace":"api"}`,
+			expectedRes: "logs-v1-api-2021.03.04",
+		},
+		{
+			name:        "TextLayout",
+			pattern:     "logs-{namespace}-2006.01.02-v2",
+			entry:       `{"namespace":"api"}`,
+			expectedRes: "logs-api-2021.03.04-v2",
+		},
+		{
+			name:        "TextLayoutOnly",
+			pattern:     "2006.01.",
+			entry:       `{}`,
+			expectedRes: "2021.03.",
+		},
+		{
+			name:        "Sanitize",
+			pattern:     "logs-{namespace}",
+			entry:       `{"namespace":"a/b\\c*d?e\"f<g>h|i,j#k:l m"}`,
+			expectedRes: "logs-a_b_c_d_e_f_g_h_i_j_k_l_m",
+		},
+		{
+			name:        "InvalidFallback",
+			pattern:     "{namespace}",
+			entry:       `{"namespace":"_private"}`,
+			expectedRes: "lhw-2021.03.04",
+		},
+		{
+			name:        "NotJSON",
+			pattern:     "{source}",
+			entry:       "text",
+			expectedRes: "unknown",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := &elasticConn{index: parseIndexPattern(tt.pattern)}
+
+			assert.Equal(t, tt.expectedRes, conn.indexName([]byte(tt.entry), now))
+		})
+	}
+}
+
+func TestElasticConn_Store(t *testing.T) {
+	tests := []struct {
+		name         string
+		status       int
+		response     string
+		expectedCode int
+		expectedErr  error
+	}{
+		{
+			name:         "Stored",
+			status:       http.StatusOK,
+			response:     `{"errors":false,"items":[{"create":{"status":201}},{"create":{"status":201}},{"create":{"status":201}}]}`,
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:   "Partial",
+			status: http.StatusOK,
+			response: `{"errors":true,"items":[{"create":{"status":201}},{"create":{"status":429}},` +
+				`{"create":{"status":400,"error":{"type":"mapper_parsing_exception"}}}]}`,
+			expectedCode: http.StatusOK,
+			expectedErr:  &PartialError{Retry: []int{1}, Rejected: []int{2}},
+		},
+		{
+			name:         "Throttled",
+			status:       http.StatusTooManyRequests,
+			expectedCode: http.StatusTooManyRequests,
+			expectedErr:  &ThrottleError{Delay: DefaultThrottleDelay},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var lines []string
+
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, elasticBulkURI, r.URL.Path)
+				assert.Equal(t, "ApiKey key", r.Header.Get(authorizationHeader))
+
+				scanner := bufio.NewScanner(r.Body)
+				for scanner.Scan() {
+					lines = append(lines, scanner.Text())
+				}
+
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(tt.response))
+			}))
+			defer ts.Close()
+
+			conn, err := newConn("es+"+ts.URL+"?api_key=key&index=logs-{source}-{date:2006}", Config{}, ts.Client().Transport)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			code, err := conn.Store(context.Background(), [][]byte{
+				[]byte(`{"source":"api","time":"2021-01-02T03:04:05Z"}`),
+				[]byte("{\n\"source\":\"web\",\"time\":\"2021-01-02T03:04:05Z\"}\n"),
+				[]byte(`{"time":"2021-01-02T03:04:05Z"}`),
+			})
+			assert.Equal(t, tt.expectedCode, code)
+			assert.Equal(t, tt.expectedErr, err)
+
+			assert.Equal(t, []string{
+				`{"create":{"_index":"logs-api-2021"}}`,
+				`{"source":"api","time":"2021-01-02T03:04:05Z"}`,
+				`{"create":{"_index":"logs-web-2021"}}`,
+				`{ "source":"web","time":"2021-01-02T03:04:05Z"}`,
+				`{"create":{"_index":"logs-unknown-2021"}}`,
+				`{"time":"2021-01-02T03:04:05Z"}`,
+			}, lines)
+		})
+	}
+}
+
+func TestHttpTransport_SendBatchPartial(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"errors":true,"items":[{"create":{"status":201}},{"create":{"status":503}}]}`))
+	}))
+	defer ts.Close()
+
+	transport, err := New(Config{
+		Servers:        []string{"es+" + ts.URL},
+		RequestTimeout: time.Second,
+		PingInterval:   time.Second,
+		SuccessCodes:   []int{http.StatusOK},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = transport.SendBatch([][]byte{[]byte("{}"), []byte("{}")})
+	assert.Equal(t, &PartialError{Retry: []int{1}}, err)
+	assert.True(t, transport.IsConnected(), "node should stay live")
+}
@@ -0,0 +1,459 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	SchemeGELFUDP   = "gelf+udp"
+	SchemeGELFTCP   = "gelf+tcp"
+	SchemeGELFHTTP  = "gelf+http"
+	SchemeGELFHTTPS = "gelf+https"
+)
+
+// Query parameters of gelf dsn.
+const (
+	gelfHostParam        = "host"
+	gelfCompressionParam = "compression"
+	gelfChunkSizeParam   = "chunk_size"
+)
+
+const (
+	gelfVersion    = "1.1"
+	gelfHTTPURI    = "/gelf"
+	gelfMaxChunks  = 128
+	gelfChunkMagic = "\x1e\x0f"
+	// gelfChunkHeader is size of magic bytes, message id, sequence number and count.
+	gelfChunkHeader = 12
+)
+
+// DefaultGELFChunkSize fits UDP datagrams into ethernet frames.
+const DefaultGELFChunkSize = 1420
+
+var (
+	ErrBadGELFCompression = errors.New("gelf compression invalid")
+	ErrBadGELFChunkSize   = errors.New("gelf chunk size invalid")
+	ErrGELFTooManyChunks  = errors.New("gelf message exceeds 128 chunks")
+)
+
+// gelfFields are entry fields mapped to fields of the message,
+// other entry fields are additional fields with underscore prefix.
+var gelfFields = map[string]string{
+	"message":    "short_message",
+	"stacktrace": "full_message",
+	"host":       "host",
+	"level":      "level",
+	"time":       "timestamp",
+}
+
+// gelfConn sends entries as GELF 1.1 messages to Graylog inputs. Over UDP
+// messages are compressed and split into chunks, over TCP messages are
+// delimited with null bytes, over HTTP every message is a request.
+type gelfConn struct {
+	network    string
+	addr       string
+	host       string
+	compressor Compressor
+	chunkSize  int
+	dialer     *dialer
+
+	client *http.Client
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func newGELFConn(dsn *url.URL, config Config, transport http.RoundTripper) (Conn, error) {
+	c := &gelfConn{
+		addr:      dsn.Host,
+		chunkSize: DefaultGELFChunkSize,
+	}
+
+	switch dsn.Scheme {
+	case SchemeGELFUDP:
+		c.network = "udp"
+		c.compressor = &GzipCompressor{}
+	case SchemeGELFTCP:
+		c.network = "tcp"
+	default:
+		addr := url.URL{
+			Scheme: strings.TrimPrefix(dsn.Scheme, "gelf+"),
+			Host:   dsn.Host,
+			Path:   strings.TrimSuffix(dsn.Path, "/"),
+		}
+
+		if addr.Path == "" {
+			addr.Path = gelfHTTPURI
+		}
+
+		c.network = "http"
+		c.addr = addr.String()
+		c.client = &http.Client{Transport: transport}
+	}
+
+	dialer, err := config.netDialer()
+	if err != nil {
+		return nil, err
+	}
+
+	c.dialer = dialer
+
+	query := dsn.Query()
+
+	c.host = query.Get(gelfHostParam)
+	if c.host == "" {
+		c.host, _ = os.Hostname()
+	}
+
+	switch query.Get(gelfCompressionParam) {
+	case "", CompressionGzip:
+	case "none":
+		c.compressor = nil
+	default:
+		return nil, ErrBadGELFCompression
+	}
+
+	if size := query.Get(gelfChunkSizeParam); size != "" {
+		chunkSize, err := strconv.Atoi(size)
+		if err != nil || chunkSize <= gelfChunkHeader {
+			return nil, ErrBadGELFChunkSize
+		}
+
+		c.chunkSize = chunkSize
+	}
+
+	return c, nil
+}
+
+// Store sends messages of the batch one by one. Messages exceeding
+// 128 chunks and messages rejected by the HTTP input are dropped,
+// messages after a failed one are returned for retry.
+func (c *gelfConn) Store(ctx context.Context, batch [][]byte) (code int, err error) {
+	partial := &PartialError{}
+
+	for idx, entry := range batch {
+		msg, err := c.message(entry, time.Now())
+		if err != nil {
+			partial.Rejected = append(partial.Rejected, idx)
+
+			continue
+		}
+
+		code, err := c.send(ctx, msg)
+
+		switch {
+		case err == nil && code == http.StatusOK:
+			continue
+		case errors.Is(err, ErrGELFTooManyChunks), err == nil && code >= 400 && code < 500 && code != http.StatusTooManyRequests:
+			partial.Rejected = append(partial.Rejected, idx)
+
+			continue
+		case idx == 0:
+			return code, err
+		}
+
+		for rest := idx; rest < len(batch); rest++ {
+			partial.Retry = append(partial.Retry, rest)
+		}
+
+		break
+	}
+
+	if len(partial.Retry) == 0 && len(partial.Rejected) == 0 {
+		return http.StatusOK, nil
+	}
+
+	return http.StatusOK, partial
+}
+
+// Ping dials TCP inputs, GELF inputs have no health endpoint, so any
+// HTTP response below 500 means the input is reachable.
+func (c *gelfConn) Ping(ctx context.Context) (code int, err error) {
+	if c.network != "http" {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		if _, err := c.dial(ctx); err != nil {
+			return 0, err
+		}
+
+		return http.StatusOK, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.addr, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := resp.Body.Close(); err != nil {
+		return 0, err
+	}
+
+	if resp.StatusCode >= 500 {
+		return resp.StatusCode, nil
+	}
+
+	return http.StatusOK, nil
+}
+
+func (c *gelfConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.closeConn()
+
+	return nil
+}
+
+func (c *gelfConn) send(ctx context.Context, msg []byte) (int, error) {
+	if c.network == "http" {
+		return c.post(ctx, msg)
+	}
+
+	frames := [][]byte{append(msg, 0)}
+
+	if c.network == "udp" {
+		var err error
+
+		if frames, err = c.datagrams(msg); err != nil {
+			return 0, err
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetWriteDeadline(deadline)
+	}
+
+	for _, frame := range frames {
+		if _, err := conn.Write(frame); err != nil {
+			c.closeConn()
+
+			return 0, err
+		}
+	}
+
+	return http.StatusOK, nil
+}
+
+func (c *gelfConn) post(ctx context.Context, msg []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.addr, bytes.NewReader(msg))
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set(contentTypeHeader, jsonContentType)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := resp.Body.Close(); err != nil {
+		return 0, err
+	}
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return http.StatusOK, nil
+	}
+
+	return resp.StatusCode, nil
+}
+
+func (c *gelfConn) dial(ctx context.Context) (net.Conn, error) {
+	if c.conn != nil {
+		return c.conn, nil
+	}
+
+	conn, err := c.dialer.dialConn(ctx, c.network, c.addr, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	c.conn = conn
+
+	return conn, nil
+}
+
+func (c *gelfConn) closeConn() {
+	if c.conn != nil {
+		_ = c.conn.Close()
+		c.conn = nil
+	}
+}
+
+// datagrams compresses the message and splits it into chunks
+// sharing a random message id if it does not fit into one datagram.
+func (c *gelfConn) datagrams(msg []byte) ([][]byte, error) {
+	if c.compressor != nil {
+		compressed, err := c.compressor.Compress(msg)
+		if err != nil {
+			return nil, err
+		}
+
+		msg = compressed
+	}
+
+	if len(msg) <= c.chunkSize {
+		return [][]byte{msg}, nil
+	}
+
+	size := c.chunkSize - gelfChunkHeader
+
+	count := (len(msg) + size - 1) / size
+	if count > gelfMaxChunks {
+		return nil, ErrGELFTooManyChunks
+	}
+
+	return gelfChunks(msg, size, count), nil
+}
+
+func gelfChunks(msg []byte, size, count int) [][]byte {
+	var (
+		chunks = make([][]byte, 0, count)
+		id     = make([]byte, 8)
+	)
+
+	_, _ = rand.Read(id)
+
+	for seq := 0; seq < count; seq++ {
+		end := (seq + 1) * size
+		if end > len(msg) {
+			end = len(msg)
+		}
+
+		chunk := make([]byte, 0, gelfChunkHeader+end-seq*size)
+		chunk = append(chunk, gelfChunkMagic...)
+		chunk = append(chunk, id...)
+		chunk = append(chunk, byte(seq), byte(count))
+		chunk = append(chunk, msg[seq*size:end]...)
+
+		chunks = append(chunks, chunk)
+	}
+
+	return chunks
+}
+
+// message converts the entry to GELF message, entries which are not json
+// objects are sent as short message.
+func (c *gelfConn) message(entry []byte, now time.Time) ([]byte, error) {
+	entry = bytes.TrimSpace(entry)
+
+	fields := make(map[string]interface{})
+
+	decoder := json.NewDecoder(bytes.NewReader(entry))
+	decoder.UseNumber()
+
+	if err := decoder.Decode(&fields); err != nil {
+		fields = map[string]interface{}{"message": string(entry)}
+	}
+
+	msg := map[string]interface{}{
+		"version":       gelfVersion,
+		"host":          c.host,
+		"short_message": string(entry),
+		"timestamp":     epochSeconds(now),
+		"level":         syslogSeverityInfo,
+	}
+
+	for key, value := range fields {
+		name, ok := gelfFields[key]
+		if !ok {
+			msg[gelfFieldName(key)] = gelfValue(value)
+
+			continue
+		}
+
+		switch key {
+		case "level":
+			if severity, ok := syslogSeverities[strings.ToLower(gelfString(value))]; ok {
+				msg[name] = severity
+			}
+		case "time":
+			if parsed, err := time.Parse(time.RFC3339Nano, gelfString(value)); err == nil {
+				msg[name] = epochSeconds(parsed)
+			}
+		default:
+			if str := gelfString(value); str != "" {
+				msg[name] = str
+			}
+		}
+	}
+
+	return json.Marshal(msg)
+}
+
+// epochSeconds returns seconds since epoch with milliseconds.
+func epochSeconds(t time.Time) json.Number {
+	return json.Number(strconv.FormatFloat(float64(t.UnixNano()/int64(time.Millisecond))/1e3, 'f', -1, 64))
+}
+
+// gelfFieldName returns additional field name, characters not allowed
+// in names are replaced with underscores, reserved _id is renamed.
+func gelfFieldName(key string) string {
+	name := []byte("_" + key)
+
+	for idx, c := range name {
+		switch {
+		case c == '_', c == '.', c == '-',
+			c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		default:
+			name[idx] = '_'
+		}
+	}
+
+	if string(name) == "_id" {
+		return "_entry_id"
+	}
+
+	return string(name)
+}
+
+// gelfValue returns additional field value, GELF allows only strings and numbers.
+func gelfValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case string, json.Number:
+		return v
+	case nil:
+		return ""
+	default:
+		data, _ := json.Marshal(v)
+
+		return string(data)
+	}
+}
+
+func gelfString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	default:
+		return ""
+	}
+}
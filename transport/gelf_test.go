@@ -0,0 +1,279 @@
+package transport
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGELFConn_message(t *testing.T) {
+	now := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+
+	tests := []struct {
+		name        string
+		entry       string
+		expectedRes map[string]interface{}
+	}{
+		{
+			name: "Fields",
+			entry: `{"host":"web1","level":"error","message":"failed","time":"2021-01-02T03:04:05.123456Z",` +
+				`"stacktrace":"main.go:1","id":"42","trace id":"t1","code":500,"tags":["a"],"ok":true,"empty":null}`,
+			expectedRes: map[string]interface{}{
+				"version":       "1.1",
+				"host":          "web1",
+				"short_message": "failed",
+				"full_message":  "main.go:1",
+				"timestamp":     1609556645.123,
+				"level":         float64(3),
+				"_entry_id":     "42",
+				"_trace_id":     "t1",
+				"_code":         float64(500),
+				"_tags":         `["a"]`,
+				"_ok":           "true",
+				"_empty":        "",
+			},
+		},
+		{
+			name:  "NotJSON",
+			entry: "plain text\n",
+			expectedRes: map[string]interface{}{
+				"version":       "1.1",
+				"host":          "node",
+				"short_message": "plain text",
+				"timestamp":     1614834367.0,
+				"level":         float64(6),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := newConn("gelf+tcp://127.0.0.1:12201?host=node", Config{}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			msg, err := conn.(*gelfConn).message([]byte(tt.entry), now)
+			assert.Nil(t, err)
+
+			var res map[string]interface{}
+
+			assert.Nil(t, json.Unmarshal(msg, &res))
+			assert.Equal(t, tt.expectedRes, res)
+		})
+	}
+}
+
+func TestNewGELFConn_Error(t *testing.T) {
+	_, err := newConn("gelf+udp://127.0.0.1:12201?compression=zstd", Config{}, nil)
+	assert.ErrorIs(t, err, ErrBadGELFCompression)
+
+	_, err = newConn("gelf+udp://127.0.0.1:12201?chunk_size=12", Config{}, nil)
+	assert.ErrorIs(t, err, ErrBadGELFChunkSize)
+}
+
+func TestGELFConn_UDP(t *testing.T) {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer listener.Close()
+
+	conn, err := newConn("gelf+udp://"+listener.LocalAddr().String()+"?chunk_size=100&compression=none", Config{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer conn.Close()
+
+	large := `{"message":"` + strings.Repeat("m", 300) + `"}`
+
+	code, err := conn.Store(context.Background(), [][]byte{
+		[]byte(large),
+		[]byte(`{"message":"` + strings.Repeat("m", 100*gelfMaxChunks) + `"}`),
+	})
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, &PartialError{Rejected: []int{1}}, err)
+
+	var (
+		id      []byte
+		message []byte
+		buf     = make([]byte, 200)
+	)
+
+	for seq, count := 0, 1; seq < count; seq++ {
+		n, _, err := listener.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		chunk := buf[:n]
+
+		if id == nil {
+			id = append([]byte{}, chunk[2:10]...)
+			count = int(chunk[11])
+		}
+
+		assert.Equal(t, gelfChunkMagic, string(chunk[:2]))
+		assert.Equal(t, []byte{byte(seq), byte(count)}, chunk[10:12])
+
+		assert.Equal(t, id, chunk[2:10])
+
+		message = append(message, chunk[gelfChunkHeader:]...)
+	}
+
+	var res map[string]interface{}
+
+	assert.Nil(t, json.Unmarshal(message, &res))
+	assert.Equal(t, strings.Repeat("m", 300), res["short_message"])
+}
+
+func TestGELFConn_UDPGzip(t *testing.T) {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer listener.Close()
+
+	conn, err := newConn("gelf+udp://"+listener.LocalAddr().String(), Config{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer conn.Close()
+
+	code, err := conn.Store(context.Background(), [][]byte{[]byte(`{"message":"m"}`)})
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, code)
+
+	buf := make([]byte, DefaultGELFChunkSize)
+
+	n, _, err := listener.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := gzip.NewReader(bytes.NewReader(buf[:n]))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	message, err := ioutil.ReadAll(reader)
+	assert.Nil(t, err)
+	assert.Contains(t, string(message), `"short_message":"m"`)
+}
+
+func TestGELFConn_TCP(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer listener.Close()
+
+	messages := make(chan string, 2)
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+
+		defer conn.Close()
+
+		reader := bufio.NewReader(conn)
+
+		for {
+			msg, err := reader.ReadString(0)
+			if err != nil {
+				return
+			}
+
+			messages <- strings.TrimSuffix(msg, "\x00")
+		}
+	}()
+
+	conn, err := newConn("gelf+tcp://"+listener.Addr().String(), Config{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer conn.Close()
+
+	code, err := conn.Ping(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, code)
+
+	code, err = conn.Store(context.Background(), [][]byte{[]byte(`{"message":"1"}`), []byte(`{"message":"2"}`)})
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, code)
+
+	for _, expected := range []string{"1", "2"} {
+		var res map[string]interface{}
+
+		assert.Nil(t, json.Unmarshal([]byte(<-messages), &res))
+		assert.Equal(t, expected, res["short_message"])
+	}
+}
+
+func TestGELFConn_HTTP(t *testing.T) {
+	tests := []struct {
+		name         string
+		statuses     []int
+		expectedCode int
+		expectedErr  error
+	}{
+		{
+			name:         "Accepted",
+			statuses:     []int{http.StatusAccepted, http.StatusAccepted, http.StatusAccepted},
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "Partial",
+			statuses:     []int{http.StatusAccepted, http.StatusBadRequest, http.StatusServiceUnavailable},
+			expectedCode: http.StatusOK,
+			expectedErr:  &PartialError{Retry: []int{2}, Rejected: []int{1}},
+		},
+		{
+			name:         "Unavailable",
+			statuses:     []int{http.StatusServiceUnavailable},
+			expectedCode: http.StatusServiceUnavailable,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests int
+
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, gelfHTTPURI, r.URL.Path)
+				assert.Equal(t, jsonContentType, r.Header.Get(contentTypeHeader))
+
+				w.WriteHeader(tt.statuses[requests])
+				requests++
+			}))
+			defer ts.Close()
+
+			conn, err := newConn("gelf+"+ts.URL, Config{}, ts.Client().Transport)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			code, err := conn.Store(context.Background(), [][]byte{[]byte("1"), []byte("2"), []byte("3")})
+			assert.Equal(t, tt.expectedCode, code)
+			assert.Equal(t, tt.expectedErr, err)
+			assert.Equal(t, len(tt.statuses), requests)
+		})
+	}
+}
@@ -0,0 +1,193 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/loghole/lhw/transport/storepb"
+)
+
+const (
+	SchemeGRPC  = "grpc"
+	SchemeGRPCS = "grpcs"
+)
+
+// grpcConn sends entries to the collector service of store.proto,
+// batches of several entries are sent with client streaming.
+// Calls are authorized with the auth provider of the node or the token of its url.
+type grpcConn struct {
+	conn   *grpc.ClientConn
+	client storepb.CollectorClient
+	token  string
+}
+
+func newGRPCConn(dsn *url.URL, config Config, transport http.RoundTripper) (Conn, error) {
+	auth := transportAuth(transport)
+	if signsBody(auth) {
+		return nil, fmt.Errorf("%w: grpc calls can not be signed", ErrUnsupportedAuth)
+	}
+
+	creds := insecure.NewCredentials()
+
+	if dsn.Scheme == SchemeGRPCS {
+		tlsConfig, err := config.clientTLSConfig(dsn.Hostname())
+		if err != nil {
+			return nil, err
+		}
+
+		creds = credentials.NewTLS(tlsConfig)
+	}
+
+	dialer, err := config.netDialer()
+	if err != nil {
+		return nil, err
+	}
+
+	options := []grpc.DialOption{
+		grpc.WithTransportCredentials(creds),
+		grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "tcp", addr)
+		}),
+		grpc.WithDefaultCallOptions(grpc.ForceCodec(storepb.Codec{})),
+	}
+
+	if auth != nil {
+		options = append(options, grpc.WithPerRPCCredentials(grpcAuth{auth: auth}))
+	}
+
+	conn, err := grpc.Dial(dsn.Host, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &grpcConn{
+		conn:   conn,
+		client: storepb.NewCollectorClient(conn),
+	}
+
+	if dsn.User != nil && auth == nil {
+		c.token = "Bearer" + " " + dsn.User.String()
+	}
+
+	return c, nil
+}
+
+func (c *grpcConn) Store(ctx context.Context, batch [][]byte) (code int, err error) {
+	ctx = c.withToken(ctx)
+
+	if len(batch) == 1 {
+		_, err = c.client.Store(ctx, &storepb.StoreRequest{Entries: batch})
+
+		return grpcCode(err)
+	}
+
+	stream, err := c.client.StoreStream(ctx)
+	if err != nil {
+		return grpcCode(err)
+	}
+
+	for _, entry := range batch {
+		if err := stream.Send(&storepb.StoreRequest{Entries: [][]byte{entry}}); err != nil {
+			// Status of the failed stream is returned by CloseAndRecv.
+			break
+		}
+	}
+
+	_, err = stream.CloseAndRecv()
+
+	return grpcCode(err)
+}
+
+func (c *grpcConn) Ping(ctx context.Context) (code int, err error) {
+	_, err = c.client.Ping(c.withToken(ctx), &storepb.PingRequest{})
+
+	return grpcCode(err)
+}
+
+func (c *grpcConn) Close() error {
+	return c.conn.Close()
+}
+
+func (c *grpcConn) withToken(ctx context.Context) context.Context {
+	if c.token == "" {
+		return ctx
+	}
+
+	return metadata.AppendToOutgoingContext(ctx, authorizationHeader, c.token)
+}
+
+// grpcAuth passes headers set by the auth provider as metadata of calls.
+type grpcAuth struct {
+	auth AuthProvider
+}
+
+func (a grpcAuth) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	target := "/"
+	if len(uri) > 0 {
+		target = uri[0]
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := a.auth.Authorize(req, nil); err != nil {
+		return nil, err
+	}
+
+	md := make(map[string]string, len(req.Header))
+
+	for key := range req.Header {
+		md[strings.ToLower(key)] = req.Header.Get(key)
+	}
+
+	return md, nil
+}
+
+// RequireTransportSecurity returns false as tokens of urls are sent
+// over insecure connections too.
+func (a grpcAuth) RequireTransportSecurity() bool {
+	return false
+}
+
+// grpcCode converts status of the call to http status code,
+// errors without a response status are returned as is.
+func grpcCode(err error) (int, error) {
+	st, ok := status.FromError(err)
+	if !ok {
+		return 0, err
+	}
+
+	switch st.Code() {
+	case codes.OK:
+		return http.StatusOK, nil
+	case codes.Unavailable, codes.Canceled, codes.DeadlineExceeded:
+		return 0, err
+	case codes.InvalidArgument:
+		return http.StatusBadRequest, nil
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized, nil
+	case codes.PermissionDenied:
+		return http.StatusForbidden, nil
+	case codes.NotFound:
+		return http.StatusNotFound, nil
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests, nil
+	case codes.Unimplemented:
+		return http.StatusNotImplemented, nil
+	default:
+		return http.StatusInternalServerError, nil
+	}
+}
@@ -0,0 +1,224 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/loghole/lhw/transport/storepb"
+)
+
+type stubCollector struct {
+	storepb.UnimplementedCollectorServer
+
+	mu      sync.Mutex
+	entries []string
+	tokens  []string
+	err     error
+}
+
+func (s *stubCollector) Store(ctx context.Context, req *storepb.StoreRequest) (*storepb.StoreResponse, error) {
+	if err := s.store(ctx, req); err != nil {
+		return nil, err
+	}
+
+	return &storepb.StoreResponse{Accepted: uint64(len(req.Entries))}, nil
+}
+
+func (s *stubCollector) StoreStream(stream storepb.Collector_StoreStreamServer) error {
+	var accepted uint64
+
+	for {
+		req, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return stream.SendAndClose(&storepb.StoreResponse{Accepted: accepted})
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if err := s.store(stream.Context(), req); err != nil {
+			return err
+		}
+
+		accepted += uint64(len(req.Entries))
+	}
+}
+
+func (s *stubCollector) Ping(context.Context, *storepb.PingRequest) (*storepb.PingResponse, error) {
+	return &storepb.PingResponse{}, nil
+}
+
+func (s *stubCollector) store(ctx context.Context, req *storepb.StoreRequest) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err != nil {
+		return s.err
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	s.tokens = append(s.tokens, md.Get(authorizationHeader)...)
+
+	for _, entry := range req.Entries {
+		s.entries = append(s.entries, string(entry))
+	}
+
+	return nil
+}
+
+func startCollector(t *testing.T, collector *stubCollector) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := grpc.NewServer(grpc.ForceServerCodec(storepb.Codec{}))
+	storepb.RegisterCollectorServer(server, collector)
+
+	go func() { _ = server.Serve(listener) }()
+
+	t.Cleanup(server.Stop)
+
+	return listener.Addr().String()
+}
+
+func TestGRPCTransport(t *testing.T) {
+	collector := &stubCollector{}
+	addr := startCollector(t, collector)
+
+	transport, err := New(Config{
+		Servers:        []string{"grpc://secret@" + addr},
+		RequestTimeout: time.Second,
+		PingInterval:   time.Second,
+		SuccessCodes:   []int{http.StatusOK},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Nil(t, transport.Send([]byte(`{"a":1}`)))
+	assert.Nil(t, transport.SendBatch([][]byte{[]byte(`{"a":2}`), []byte(`{"a":3}`)}))
+
+	assert.Equal(t, []string{`{"a":1}`, `{"a":2}`, `{"a":3}`}, collector.entries)
+	assert.Equal(t, []string{"Bearer secret", "Bearer secret", "Bearer secret"}, collector.tokens)
+}
+
+func TestGRPCTransport_Auth(t *testing.T) {
+	collector := &stubCollector{}
+	addr := startCollector(t, collector)
+	server := "grpc://secret@" + addr
+
+	transport, err := New(Config{
+		Servers:        []string{server},
+		RequestTimeout: time.Second,
+		PingInterval:   time.Second,
+		SuccessCodes:   []int{http.StatusOK},
+		NodeAuth:       map[string]AuthProvider{nodeAddr(server): NewTokenAuth("node")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer transport.Close()
+
+	assert.Nil(t, transport.Send([]byte(`{"a":1}`)))
+	assert.Nil(t, transport.SendBatch([][]byte{[]byte(`{"a":2}`), []byte(`{"a":3}`)}))
+
+	assert.Equal(t, []string{"Bearer node", "Bearer node", "Bearer node"}, collector.tokens)
+}
+
+func TestGRPCConn(t *testing.T) {
+	tests := []struct {
+		name         string
+		err          error
+		expectedCode int
+	}{
+		{
+			name:         "OK",
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "ResourceExhausted",
+			err:          status.Error(codes.ResourceExhausted, "slow down"),
+			expectedCode: http.StatusTooManyRequests,
+		},
+		{
+			name:         "Internal",
+			err:          status.Error(codes.Internal, "failed"),
+			expectedCode: http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			collector := &stubCollector{err: tt.err}
+			addr := startCollector(t, collector)
+
+			client, err := NewNodeClient("grpc://"+addr, nil, WithConn(mustGRPCConn(t, "grpc://"+addr)))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			defer client.Close()
+
+			code, err := client.SendBatchRequest([][]byte{[]byte("1"), []byte("2")}, time.Second)
+			assert.Nil(t, err)
+			assert.Equal(t, tt.expectedCode, code)
+
+			code, err = client.SendRequest([]byte("3"), time.Second)
+			assert.Nil(t, err)
+			assert.Equal(t, tt.expectedCode, code)
+
+			assert.Equal(t, tt.expectedCode == http.StatusTooManyRequests, client.IsThrottled())
+
+			code, err = client.PingRequest(time.Second)
+			assert.Nil(t, err)
+			assert.Equal(t, http.StatusOK, code)
+		})
+	}
+}
+
+func TestGRPCConn_Unavailable(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addr := listener.Addr().String()
+	listener.Close()
+
+	client, err := NewNodeClient("grpc://"+addr, nil, WithConn(mustGRPCConn(t, "grpc://"+addr)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer client.Close()
+
+	_, err = client.SendRequest([]byte("1"), time.Second)
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+}
+
+func mustGRPCConn(t *testing.T, dsn string) Conn {
+	t.Helper()
+
+	conn, err := newConn(dsn, Config{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return conn
+}
@@ -0,0 +1,19 @@
+package transport
+
+// Hooks receives events of the transport. Hooks are called synchronously
+// from sending goroutines and must not block.
+type Hooks interface {
+	// OnSendError is called when a request to the node fails.
+	OnSendError(node string, err error)
+	// OnNodeDown is called when a live node is marked as dead.
+	OnNodeDown(node string)
+	// OnNodeUp is called when a dead node responds to ping.
+	OnNodeUp(node string)
+}
+
+// NopHooks ignores all events, it can be embedded to implement only some hooks.
+type NopHooks struct{}
+
+func (NopHooks) OnSendError(string, error) {}
+func (NopHooks) OnNodeDown(string)         {}
+func (NopHooks) OnNodeUp(string)           {}
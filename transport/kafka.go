@@ -0,0 +1,490 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	SchemeKafka    = "kafka"
+	SchemeKafkaTLS = "kafka+tls"
+)
+
+// Query parameters of kafka dsn.
+const (
+	kafkaAcksParam     = "acks"
+	kafkaKeyParam      = "key"
+	kafkaClientIDParam = "client_id"
+)
+
+const (
+	DefaultKafkaPort     = "9092"
+	DefaultKafkaClientID = "lhw"
+	DefaultKafkaTimeout  = 10 * time.Second
+)
+
+// Acknowledgements required by the producer, selected by acks query parameter.
+const (
+	KafkaAcksNone   = 0
+	KafkaAcksLeader = 1
+	KafkaAcksAll    = -1
+)
+
+var (
+	ErrBadKafkaTopic = errors.New("kafka topic invalid")
+	ErrBadKafkaAcks  = errors.New("kafka acks invalid")
+)
+
+// kafkaConn produces entries to the topic of kafka://broker1,broker2/topic.
+// Entries with the same value of the key field go to the same partition,
+// partitions are picked with the hash of Kafka default partitioner.
+// Entries without the key are sent to one partition per batch,
+// partitions are rotated between batches.
+type kafkaConn struct {
+	bootstrap []string
+	topic     string
+	acks      int16
+	key       string
+	clientID  string
+	tlsConfig *tls.Config
+	dialer    *dialer
+
+	mu         sync.Mutex
+	brokers    map[int32]*kafkaBroker
+	addrs      map[int32]string
+	partitions []kafkaPartitionMetadata
+	next       int
+}
+
+// kafkaBroker is a connection to one broker, requests are sent one by one.
+type kafkaBroker struct {
+	conn          net.Conn
+	correlationID int32
+}
+
+func newKafkaConn(dsn *url.URL, config Config, _ http.RoundTripper) (Conn, error) {
+	c := &kafkaConn{
+		topic:    strings.Trim(dsn.Path, "/"),
+		acks:     KafkaAcksLeader,
+		clientID: DefaultKafkaClientID,
+		brokers:  make(map[int32]*kafkaBroker),
+	}
+
+	if c.topic == "" || strings.Contains(c.topic, "/") {
+		return nil, ErrBadKafkaTopic
+	}
+
+	for _, host := range strings.Split(dsn.Host, ",") {
+		if _, _, err := net.SplitHostPort(host); err != nil {
+			host = net.JoinHostPort(host, DefaultKafkaPort)
+		}
+
+		c.bootstrap = append(c.bootstrap, host)
+	}
+
+	if dsn.Scheme == SchemeKafkaTLS {
+		// Certificates of brokers are verified for their hosts on dial.
+		tlsConfig, err := config.clientTLSConfig("")
+		if err != nil {
+			return nil, err
+		}
+
+		c.tlsConfig = tlsConfig
+	}
+
+	dialer, err := config.netDialer()
+	if err != nil {
+		return nil, err
+	}
+
+	c.dialer = dialer
+
+	query := dsn.Query()
+
+	switch query.Get(kafkaAcksParam) {
+	case "":
+	case "0":
+		c.acks = KafkaAcksNone
+	case "1":
+		c.acks = KafkaAcksLeader
+	case "all", "-1":
+		c.acks = KafkaAcksAll
+	default:
+		return nil, ErrBadKafkaAcks
+	}
+
+	c.key = query.Get(kafkaKeyParam)
+
+	if clientID := query.Get(kafkaClientIDParam); clientID != "" {
+		c.clientID = clientID
+	}
+
+	return c, nil
+}
+
+// Store produces the batch with one request per partition leader. Entries
+// of partitions failed with temporary errors are returned for retry,
+// entries of partitions failed with other errors are rejected.
+func (c *kafkaConn) Store(ctx context.Context, batch [][]byte) (code int, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.partitions == nil {
+		if code, err := c.refresh(ctx); err != nil || code != http.StatusOK {
+			return code, err
+		}
+	}
+
+	var (
+		partial = &PartialError{}
+		leaders = make(map[int32]map[int32][]int)
+		keys    = make([][]byte, len(batch))
+	)
+
+	for idx, entry := range batch {
+		keys[idx] = c.entryKey(entry)
+	}
+
+	for idx, partition := range c.assign(keys) {
+		leader, ok := c.leader(partition)
+		if !ok {
+			partial.Retry = append(partial.Retry, idx)
+
+			continue
+		}
+
+		if leaders[leader] == nil {
+			leaders[leader] = make(map[int32][]int)
+		}
+
+		leaders[leader][partition] = append(leaders[leader][partition], idx)
+	}
+
+	var lastErr error
+
+	for leader, partitions := range leaders {
+		codes, err := c.produce(ctx, leader, batch, keys, partitions)
+		if err != nil {
+			lastErr = err
+		}
+
+		for partition, indexes := range partitions {
+			code, ok := codes[partition]
+
+			switch {
+			case err != nil, !ok, kafkaRetriable(code):
+				partial.Retry = append(partial.Retry, indexes...)
+			case code != kafkaErrNone:
+				partial.Rejected = append(partial.Rejected, indexes...)
+			}
+		}
+	}
+
+	if len(partial.Retry) > 0 {
+		// Leaders of partitions could be moved.
+		c.partitions = nil
+	}
+
+	switch {
+	case len(partial.Retry) == 0 && len(partial.Rejected) == 0:
+		return http.StatusOK, nil
+	case len(partial.Retry) == len(batch) && lastErr != nil:
+		return 0, lastErr
+	case len(partial.Retry) == len(batch):
+		return http.StatusServiceUnavailable, nil
+	}
+
+	sort.Ints(partial.Retry)
+	sort.Ints(partial.Rejected)
+
+	return http.StatusOK, partial
+}
+
+// Ping fetches partitions of the topic.
+func (c *kafkaConn) Ping(ctx context.Context) (code int, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.refresh(ctx)
+}
+
+func (c *kafkaConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for id := range c.brokers {
+		c.closeBroker(id)
+	}
+
+	return nil
+}
+
+// refresh fetches metadata of the topic from any bootstrap broker.
+func (c *kafkaConn) refresh(ctx context.Context) (int, error) {
+	var lastErr error
+
+	for _, addr := range c.bootstrap {
+		metadata, err := c.metadata(ctx, addr)
+		if err != nil {
+			lastErr = err
+
+			continue
+		}
+
+		switch {
+		case metadata.topicErr == kafkaErrUnknownTopicOrPartition:
+			return http.StatusNotFound, nil
+		case metadata.topicErr != kafkaErrNone || len(metadata.partitions) == 0:
+			return http.StatusServiceUnavailable, nil
+		}
+
+		addrs := make(map[int32]string, len(metadata.brokers))
+
+		for _, broker := range metadata.brokers {
+			addrs[broker.id] = net.JoinHostPort(broker.host, strconv.Itoa(int(broker.port)))
+		}
+
+		// Connections of brokers with changed addresses are reopened.
+		for id := range c.brokers {
+			if addrs[id] != c.addrs[id] {
+				c.closeBroker(id)
+			}
+		}
+
+		c.addrs = addrs
+		c.partitions = metadata.partitions
+
+		sort.Slice(c.partitions, func(i, j int) bool {
+			return c.partitions[i].id < c.partitions[j].id
+		})
+
+		return http.StatusOK, nil
+	}
+
+	return 0, lastErr
+}
+
+func (c *kafkaConn) metadata(ctx context.Context, addr string) (*kafkaMetadata, error) {
+	broker, err := c.dial(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	defer broker.conn.Close()
+
+	data, err := broker.request(ctx, c.clientID, kafkaMetadataKey, kafkaMetadataVersion, kafkaMetadataRequest(c.topic), true)
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeKafkaMetadata(data, c.topic)
+}
+
+// produce sends entries of the partitions to their leader and returns error codes
+// of partitions, all codes are none when acknowledgements are not required.
+func (c *kafkaConn) produce(
+	ctx context.Context,
+	leader int32,
+	batch, keys [][]byte,
+	partitions map[int32][]int,
+) (map[int32]int16, error) {
+	broker, err := c.broker(ctx, leader)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		now     = time.Now()
+		records = make(map[int32][]byte, len(partitions))
+	)
+
+	for partition, indexes := range partitions {
+		items := make([]kafkaRecord, 0, len(indexes))
+
+		for _, idx := range indexes {
+			items = append(items, kafkaRecord{key: keys[idx], value: bytes.TrimSpace(batch[idx])})
+		}
+
+		records[partition] = kafkaRecordBatch(items, now)
+	}
+
+	timeout := DefaultKafkaTimeout
+
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline)
+	}
+
+	body := kafkaProduceRequest(c.topic, c.acks, timeout, records)
+
+	data, err := broker.request(ctx, c.clientID, kafkaProduceKey, kafkaProduceVersion, body, c.acks != KafkaAcksNone)
+	if err != nil {
+		c.closeBroker(leader)
+
+		return nil, err
+	}
+
+	if c.acks == KafkaAcksNone {
+		codes := make(map[int32]int16, len(partitions))
+
+		for partition := range partitions {
+			codes[partition] = kafkaErrNone
+		}
+
+		return codes, nil
+	}
+
+	return decodeKafkaProduce(data, c.topic)
+}
+
+// assign returns partitions of entries with the keys.
+func (c *kafkaConn) assign(keys [][]byte) []int32 {
+	var (
+		res    = make([]int32, len(keys))
+		sticky = c.partitions[c.next%len(c.partitions)].id
+	)
+
+	c.next++
+
+	for idx, key := range keys {
+		if key == nil {
+			res[idx] = sticky
+
+			continue
+		}
+
+		res[idx] = c.partitions[int(kafkaMurmur2(key)&0x7fffffff)%len(c.partitions)].id
+	}
+
+	return res
+}
+
+// entryKey returns value of the key field of the entry or nil.
+func (c *kafkaConn) entryKey(entry []byte) []byte {
+	if c.key == "" {
+		return nil
+	}
+
+	fields := make(map[string]interface{})
+
+	if err := json.Unmarshal(entry, &fields); err != nil {
+		return nil
+	}
+
+	switch value := fields[c.key].(type) {
+	case string:
+		return []byte(value)
+	case float64:
+		return []byte(strconv.FormatFloat(value, 'f', -1, 64))
+	case bool:
+		return []byte(strconv.FormatBool(value))
+	default:
+		return nil
+	}
+}
+
+func (c *kafkaConn) leader(partition int32) (int32, bool) {
+	for _, p := range c.partitions {
+		if p.id == partition {
+			_, ok := c.addrs[p.leader]
+
+			return p.leader, ok && p.leader >= 0
+		}
+	}
+
+	return 0, false
+}
+
+func (c *kafkaConn) broker(ctx context.Context, id int32) (*kafkaBroker, error) {
+	if broker, ok := c.brokers[id]; ok {
+		return broker, nil
+	}
+
+	broker, err := c.dial(ctx, c.addrs[id])
+	if err != nil {
+		return nil, err
+	}
+
+	c.brokers[id] = broker
+
+	return broker, nil
+}
+
+func (c *kafkaConn) closeBroker(id int32) {
+	if broker, ok := c.brokers[id]; ok {
+		_ = broker.conn.Close()
+
+		delete(c.brokers, id)
+	}
+}
+
+func (c *kafkaConn) dial(ctx context.Context, addr string) (*kafkaBroker, error) {
+	conn, err := c.dialer.dialConn(ctx, "tcp", addr, c.tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return &kafkaBroker{conn: conn}, nil
+}
+
+// request sends the request and reads its response without the header.
+func (b *kafkaBroker) request(
+	ctx context.Context,
+	clientID string,
+	key, version int16,
+	body []byte,
+	response bool,
+) ([]byte, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(DefaultKafkaTimeout)
+	}
+
+	_ = b.conn.SetDeadline(deadline)
+
+	b.correlationID++
+
+	if _, err := b.conn.Write(kafkaRequest(key, version, b.correlationID, clientID, body)); err != nil {
+		return nil, err
+	}
+
+	if !response {
+		return nil, nil
+	}
+
+	var header [8]byte
+
+	if _, err := io.ReadFull(b.conn, header[:]); err != nil {
+		return nil, err
+	}
+
+	size := int32(binary.BigEndian.Uint32(header[:4])) - 4
+	if size < 0 {
+		return nil, ErrKafkaMalformed
+	}
+
+	if id := int32(binary.BigEndian.Uint32(header[4:])); id != b.correlationID {
+		return nil, fmt.Errorf("%w: correlation id %d, expected %d", ErrKafkaMalformed, id, b.correlationID)
+	}
+
+	data := make([]byte, size)
+
+	if _, err := io.ReadFull(b.conn, data); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
@@ -0,0 +1,434 @@
+package transport
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"time"
+)
+
+// Kafka api keys and versions of requests sent by the producer.
+const (
+	kafkaProduceKey      = 0
+	kafkaProduceVersion  = 3
+	kafkaMetadataKey     = 3
+	kafkaMetadataVersion = 1
+)
+
+const (
+	kafkaRecordBatchMagic = 2
+	// kafkaRecordBatchHeader is size of record batch fields before records.
+	kafkaRecordBatchHeader = 61
+)
+
+// Kafka error codes handled by the producer.
+const (
+	kafkaErrNone                    = 0
+	kafkaErrUnknownTopicOrPartition = 3
+	kafkaErrLeaderNotAvailable      = 5
+	kafkaErrNotLeaderOrFollower     = 6
+	kafkaErrRequestTimedOut         = 7
+	kafkaErrNetworkException        = 13
+	kafkaErrNotEnoughReplicas       = 19
+	kafkaErrNotEnoughReplicasAfter  = 20
+)
+
+var ErrKafkaMalformed = errors.New("kafka response malformed")
+
+var kafkaCRCTable = crc32.MakeTable(crc32.Castagnoli)
+
+// kafkaRetriable reports whether a partition error is temporary.
+func kafkaRetriable(code int16) bool {
+	switch code {
+	case kafkaErrUnknownTopicOrPartition, kafkaErrLeaderNotAvailable, kafkaErrNotLeaderOrFollower,
+		kafkaErrRequestTimedOut, kafkaErrNetworkException, kafkaErrNotEnoughReplicas, kafkaErrNotEnoughReplicasAfter:
+		return true
+	default:
+		return false
+	}
+}
+
+// kafkaEncoder writes primitive types of Kafka protocol.
+type kafkaEncoder struct {
+	buf []byte
+}
+
+func (e *kafkaEncoder) int8(v int8) {
+	e.buf = append(e.buf, byte(v))
+}
+
+func (e *kafkaEncoder) int16(v int16) {
+	e.buf = append(e.buf, byte(v>>8), byte(v))
+}
+
+func (e *kafkaEncoder) int32(v int32) {
+	e.buf = append(e.buf, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func (e *kafkaEncoder) int64(v int64) {
+	e.int32(int32(v >> 32))
+	e.int32(int32(v))
+}
+
+func (e *kafkaEncoder) varint(v int64) {
+	var buf [binary.MaxVarintLen64]byte
+
+	e.buf = append(e.buf, buf[:binary.PutVarint(buf[:], v)]...)
+}
+
+func (e *kafkaEncoder) string(v string) {
+	e.int16(int16(len(v)))
+	e.buf = append(e.buf, v...)
+}
+
+func (e *kafkaEncoder) nullString() {
+	e.int16(-1)
+}
+
+func (e *kafkaEncoder) bytes(v []byte) {
+	e.int32(int32(len(v)))
+	e.buf = append(e.buf, v...)
+}
+
+// kafkaDecoder reads primitive types of Kafka protocol,
+// the first failed read is kept as err and next reads return zero values.
+type kafkaDecoder struct {
+	buf []byte
+	err error
+}
+
+func (d *kafkaDecoder) next(size int) []byte {
+	if d.err != nil {
+		return nil
+	}
+
+	if size < 0 || len(d.buf) < size {
+		d.err = ErrKafkaMalformed
+
+		return nil
+	}
+
+	data := d.buf[:size]
+	d.buf = d.buf[size:]
+
+	return data
+}
+
+func (d *kafkaDecoder) int8() int8 {
+	if data := d.next(1); data != nil {
+		return int8(data[0])
+	}
+
+	return 0
+}
+
+func (d *kafkaDecoder) int16() int16 {
+	if data := d.next(2); data != nil {
+		return int16(binary.BigEndian.Uint16(data))
+	}
+
+	return 0
+}
+
+func (d *kafkaDecoder) int32() int32 {
+	if data := d.next(4); data != nil {
+		return int32(binary.BigEndian.Uint32(data))
+	}
+
+	return 0
+}
+
+func (d *kafkaDecoder) int64() int64 {
+	if data := d.next(8); data != nil {
+		return int64(binary.BigEndian.Uint64(data))
+	}
+
+	return 0
+}
+
+func (d *kafkaDecoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+
+	v, n := binary.Varint(d.buf)
+	if n <= 0 {
+		d.err = ErrKafkaMalformed
+
+		return 0
+	}
+
+	d.buf = d.buf[n:]
+
+	return v
+}
+
+// string reads a nullable string, null is returned as empty.
+func (d *kafkaDecoder) string() string {
+	size := d.int16()
+	if size < 0 {
+		return ""
+	}
+
+	return string(d.next(int(size)))
+}
+
+func (d *kafkaDecoder) bytes() []byte {
+	size := d.int32()
+	if size < 0 {
+		return nil
+	}
+
+	return d.next(int(size))
+}
+
+// count reads array length, every item takes at least min bytes.
+func (d *kafkaDecoder) count(min int) int {
+	count := int(d.int32())
+
+	if count < 0 || count*min > len(d.buf) {
+		if d.err == nil && count > 0 {
+			d.err = ErrKafkaMalformed
+		}
+
+		return 0
+	}
+
+	return count
+}
+
+// kafkaRequest encodes request with header v1 and size prefix.
+func kafkaRequest(key, version int16, correlationID int32, clientID string, body []byte) []byte {
+	e := &kafkaEncoder{buf: make([]byte, 4, len(body)+len(clientID)+14)}
+
+	e.int16(key)
+	e.int16(version)
+	e.int32(correlationID)
+	e.string(clientID)
+	e.buf = append(e.buf, body...)
+
+	binary.BigEndian.PutUint32(e.buf, uint32(len(e.buf)-4))
+
+	return e.buf
+}
+
+// kafkaMetadataRequest encodes MetadataRequest v1 of the topic.
+func kafkaMetadataRequest(topic string) []byte {
+	e := &kafkaEncoder{}
+
+	e.int32(1)
+	e.string(topic)
+
+	return e.buf
+}
+
+type kafkaBrokerMetadata struct {
+	id   int32
+	host string
+	port int32
+}
+
+type kafkaPartitionMetadata struct {
+	err    int16
+	id     int32
+	leader int32
+}
+
+type kafkaMetadata struct {
+	brokers    []kafkaBrokerMetadata
+	topicErr   int16
+	partitions []kafkaPartitionMetadata
+}
+
+// decodeKafkaMetadata decodes MetadataResponse v1 of the topic.
+func decodeKafkaMetadata(data []byte, topic string) (*kafkaMetadata, error) {
+	d := &kafkaDecoder{buf: data}
+	res := &kafkaMetadata{topicErr: kafkaErrUnknownTopicOrPartition}
+
+	for i, count := 0, d.count(10); i < count; i++ {
+		broker := kafkaBrokerMetadata{id: d.int32(), host: d.string(), port: d.int32()}
+		_ = d.string() // rack
+
+		res.brokers = append(res.brokers, broker)
+	}
+
+	_ = d.int32() // controller id
+
+	for i, count := 0, d.count(9); i < count; i++ {
+		topicErr := d.int16()
+		name := d.string()
+		_ = d.int8() // is internal
+
+		var partitions []kafkaPartitionMetadata
+
+		for j, count := 0, d.count(18); j < count; j++ {
+			partition := kafkaPartitionMetadata{err: d.int16(), id: d.int32(), leader: d.int32()}
+
+			for k, count := 0, d.count(4); k < count; k++ {
+				_ = d.int32() // replica
+			}
+
+			for k, count := 0, d.count(4); k < count; k++ {
+				_ = d.int32() // in-sync replica
+			}
+
+			partitions = append(partitions, partition)
+		}
+
+		if name == topic {
+			res.topicErr = topicErr
+			res.partitions = partitions
+		}
+	}
+
+	if d.err != nil {
+		return nil, d.err
+	}
+
+	return res, nil
+}
+
+// kafkaProduceRequest encodes ProduceRequest v3 of the topic,
+// records are record batches by partition.
+func kafkaProduceRequest(topic string, acks int16, timeout time.Duration, records map[int32][]byte) []byte {
+	e := &kafkaEncoder{}
+
+	e.nullString() // transactional id
+	e.int16(acks)
+	e.int32(int32(timeout / time.Millisecond))
+	e.int32(1)
+	e.string(topic)
+	e.int32(int32(len(records)))
+
+	for partition, batch := range records {
+		e.int32(partition)
+		e.bytes(batch)
+	}
+
+	return e.buf
+}
+
+// decodeKafkaProduce decodes ProduceResponse v3 and returns error codes by partition.
+func decodeKafkaProduce(data []byte, topic string) (map[int32]int16, error) {
+	d := &kafkaDecoder{buf: data}
+	res := make(map[int32]int16)
+
+	for i, count := 0, d.count(6); i < count; i++ {
+		name := d.string()
+
+		for j, count := 0, d.count(22); j < count; j++ {
+			partition, code := d.int32(), d.int16()
+			_ = d.int64() // base offset
+			_ = d.int64() // log append time
+
+			if name == topic {
+				res[partition] = code
+			}
+		}
+	}
+
+	if d.err != nil {
+		return nil, d.err
+	}
+
+	return res, nil
+}
+
+type kafkaRecord struct {
+	key   []byte
+	value []byte
+}
+
+// kafkaRecordBatch encodes records as uncompressed record batch v2.
+func kafkaRecordBatch(records []kafkaRecord, now time.Time) []byte {
+	stamp := now.UnixNano() / int64(time.Millisecond)
+
+	body := &kafkaEncoder{}
+
+	for idx, record := range records {
+		rec := &kafkaEncoder{}
+
+		rec.int8(0)            // attributes
+		rec.varint(0)          // timestamp delta
+		rec.varint(int64(idx)) // offset delta
+
+		if record.key == nil {
+			rec.varint(-1)
+		} else {
+			rec.varint(int64(len(record.key)))
+			rec.buf = append(rec.buf, record.key...)
+		}
+
+		rec.varint(int64(len(record.value)))
+		rec.buf = append(rec.buf, record.value...)
+		rec.varint(0) // headers
+
+		body.varint(int64(len(rec.buf)))
+		body.buf = append(body.buf, rec.buf...)
+	}
+
+	e := &kafkaEncoder{buf: make([]byte, 0, kafkaRecordBatchHeader+len(body.buf))}
+
+	e.int64(0)                                                  // base offset
+	e.int32(int32(kafkaRecordBatchHeader - 12 + len(body.buf))) // batch length
+	e.int32(-1)                                                 // partition leader epoch
+	e.int8(kafkaRecordBatchMagic)
+	e.int32(0) // crc, set below
+	e.int16(0) // attributes
+	e.int32(int32(len(records) - 1))
+	e.int64(stamp) // base timestamp
+	e.int64(stamp) // max timestamp
+	e.int64(-1)    // producer id
+	e.int16(-1)    // producer epoch
+	e.int32(-1)    // base sequence
+	e.int32(int32(len(records)))
+	e.buf = append(e.buf, body.buf...)
+
+	// Checksum covers the batch from attributes to the end.
+	binary.BigEndian.PutUint32(e.buf[17:], crc32.Checksum(e.buf[21:], kafkaCRCTable))
+
+	return e.buf
+}
+
+// kafkaMurmur2 is the hash of Kafka default partitioner.
+func kafkaMurmur2(data []byte) int32 {
+	const (
+		seed = 0x9747b28c
+		m    = 0x5bd1e995
+		r    = 24
+	)
+
+	length := len(data)
+	h := uint32(seed) ^ uint32(length)
+
+	for i := 0; i+4 <= length; i += 4 {
+		k := binary.LittleEndian.Uint32(data[i:])
+		k *= m
+		k ^= k >> r
+		k *= m
+		h *= m
+		h ^= k
+	}
+
+	tail := data[length&^3:]
+
+	switch len(tail) {
+	case 3:
+		h ^= uint32(tail[2]) << 16
+
+		fallthrough
+	case 2:
+		h ^= uint32(tail[1]) << 8
+
+		fallthrough
+	case 1:
+		h ^= uint32(tail[0])
+		h *= m
+	}
+
+	h ^= h >> 13
+	h *= m
+	h ^= h >> 15
+
+	return int32(h)
+}
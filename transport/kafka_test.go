@@ -0,0 +1,475 @@
+package transport
+
+import (
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeKafka is a single broker serving metadata and produce requests of one topic.
+type fakeKafka struct {
+	t          *testing.T
+	listener   net.Listener
+	topic      string
+	partitions int32
+	// codes are error codes of produce responses by partition.
+	codes map[int32]int16
+
+	mu      sync.Mutex
+	acks    []int16
+	records map[int32][]kafkaRecord
+}
+
+func newFakeKafka(t *testing.T, topic string, partitions int32) *fakeKafka {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	k := &fakeKafka{
+		t:          t,
+		listener:   listener,
+		topic:      topic,
+		partitions: partitions,
+		codes:      make(map[int32]int16),
+		records:    make(map[int32][]kafkaRecord),
+	}
+
+	go k.serve()
+
+	return k
+}
+
+func (k *fakeKafka) Addr() string {
+	return k.listener.Addr().String()
+}
+
+func (k *fakeKafka) Close() {
+	k.listener.Close()
+}
+
+func (k *fakeKafka) Records() map[int32][]kafkaRecord {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	records := make(map[int32][]kafkaRecord, len(k.records))
+
+	for partition, items := range k.records {
+		records[partition] = append([]kafkaRecord{}, items...)
+	}
+
+	return records
+}
+
+func (k *fakeKafka) Acks() []int16 {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	return append([]int16{}, k.acks...)
+}
+
+func (k *fakeKafka) serve() {
+	for {
+		conn, err := k.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		go k.handle(conn)
+	}
+}
+
+func (k *fakeKafka) handle(conn net.Conn) {
+	defer conn.Close()
+
+	for {
+		var size [4]byte
+
+		if _, err := io.ReadFull(conn, size[:]); err != nil {
+			return
+		}
+
+		data := make([]byte, binary.BigEndian.Uint32(size[:]))
+
+		if _, err := io.ReadFull(conn, data); err != nil {
+			return
+		}
+
+		d := &kafkaDecoder{buf: data}
+
+		key, version, correlationID := d.int16(), d.int16(), d.int32()
+		_ = d.string() // client id
+
+		var body []byte
+
+		switch {
+		case key == kafkaMetadataKey && version == kafkaMetadataVersion:
+			body = k.metadata(d)
+		case key == kafkaProduceKey && version == kafkaProduceVersion:
+			body = k.produce(d)
+		default:
+			k.t.Errorf("unexpected request %d v%d", key, version)
+
+			return
+		}
+
+		assert.Nil(k.t, d.err)
+
+		if body == nil {
+			continue
+		}
+
+		e := &kafkaEncoder{}
+
+		e.int32(int32(len(body) + 4))
+		e.int32(correlationID)
+		e.buf = append(e.buf, body...)
+
+		if _, err := conn.Write(e.buf); err != nil {
+			return
+		}
+	}
+}
+
+func (k *fakeKafka) metadata(d *kafkaDecoder) []byte {
+	var topics []string
+
+	for i, count := 0, d.count(2); i < count; i++ {
+		topics = append(topics, d.string())
+	}
+
+	host, port, _ := net.SplitHostPort(k.Addr())
+	portNum, _ := strconv.Atoi(port)
+
+	e := &kafkaEncoder{}
+
+	e.int32(1)
+	e.int32(1)
+	e.string(host)
+	e.int32(int32(portNum))
+	e.nullString() // rack
+	e.int32(1)     // controller id
+	e.int32(int32(len(topics)))
+
+	for _, topic := range topics {
+		if topic != k.topic {
+			e.int16(kafkaErrUnknownTopicOrPartition)
+			e.string(topic)
+			e.int8(0)
+			e.int32(0)
+
+			continue
+		}
+
+		e.int16(kafkaErrNone)
+		e.string(topic)
+		e.int8(0)
+		e.int32(k.partitions)
+
+		for partition := int32(0); partition < k.partitions; partition++ {
+			e.int16(kafkaErrNone)
+			e.int32(partition)
+			e.int32(1) // leader
+			e.int32(1) // replicas
+			e.int32(1)
+			e.int32(1) // in-sync replicas
+			e.int32(1)
+		}
+	}
+
+	return e.buf
+}
+
+func (k *fakeKafka) produce(d *kafkaDecoder) []byte {
+	_ = d.string() // transactional id
+	acks := d.int16()
+	_ = d.int32() // timeout
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.acks = append(k.acks, acks)
+
+	e := &kafkaEncoder{}
+
+	count := d.count(6)
+	e.int32(int32(count))
+
+	for i := 0; i < count; i++ {
+		topic := d.string()
+		partitions := d.count(8)
+
+		assert.Equal(k.t, k.topic, topic)
+
+		e.string(topic)
+		e.int32(int32(partitions))
+
+		for j := 0; j < partitions; j++ {
+			partition := d.int32()
+			batch := d.bytes()
+
+			if k.codes[partition] == kafkaErrNone {
+				k.records[partition] = append(k.records[partition], decodeRecordBatch(k.t, batch)...)
+			}
+
+			e.int32(partition)
+			e.int16(k.codes[partition])
+			e.int64(0)  // base offset
+			e.int64(-1) // log append time
+		}
+	}
+
+	e.int32(0) // throttle time
+
+	if acks == KafkaAcksNone {
+		return nil
+	}
+
+	return e.buf
+}
+
+func decodeRecordBatch(t *testing.T, batch []byte) []kafkaRecord {
+	d := &kafkaDecoder{buf: batch}
+
+	_ = d.int64() // base offset
+	assert.Equal(t, int32(len(batch)-12), d.int32())
+	_ = d.int32() // partition leader epoch
+	assert.Equal(t, int8(kafkaRecordBatchMagic), d.int8())
+	assert.Equal(t, crc32.Checksum(batch[21:], kafkaCRCTable), uint32(d.int32()))
+
+	d.next(kafkaRecordBatchHeader - 21 - 4)
+
+	var records []kafkaRecord
+
+	for i, count := 0, d.count(7); i < count; i++ {
+		record := &kafkaDecoder{buf: d.next(int(d.varint()))}
+
+		_ = record.int8()   // attributes
+		_ = record.varint() // timestamp delta
+		assert.Equal(t, int64(i), record.varint())
+
+		var item kafkaRecord
+
+		if size := record.varint(); size >= 0 {
+			item.key = record.next(int(size))
+		}
+
+		item.value = record.next(int(record.varint()))
+
+		assert.Equal(t, int64(0), record.varint())
+		assert.Nil(t, record.err)
+
+		records = append(records, item)
+	}
+
+	assert.Nil(t, d.err)
+
+	return records
+}
+
+func TestKafkaMurmur2(t *testing.T) {
+	tests := []struct {
+		key         string
+		expectedRes int32
+	}{
+		{key: "21", expectedRes: -973932308},
+		{key: "foobar", expectedRes: -790332482},
+		{key: "a-little-bit-long-string", expectedRes: -985981536},
+		{key: "a-little-bit-longer-string", expectedRes: -1486304829},
+		{key: "lkjh234lh9fiuh90y23oiuhsafujhadof229phr9h19h89h8", expectedRes: -58897971},
+		{key: "abc", expectedRes: 479470107},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			assert.Equal(t, tt.expectedRes, kafkaMurmur2([]byte(tt.key)))
+		})
+	}
+}
+
+func TestKafkaConn_Store(t *testing.T) {
+	broker := newFakeKafka(t, "logs", 3)
+	defer broker.Close()
+
+	conn, err := newConn("kafka://"+broker.Addr()+"/logs?key=source&acks=all", Config{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer conn.Close()
+
+	code, err := conn.Store(context.Background(), [][]byte{
+		[]byte(`{"source":"api","message":"1"}`),
+		[]byte(`{"source":"web","message":"2"}`),
+		[]byte(`{"source":"api","message":"3"}` + "\n"),
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, code)
+
+	api := (kafkaMurmur2([]byte("api")) & 0x7fffffff) % 3
+	web := (kafkaMurmur2([]byte("web")) & 0x7fffffff) % 3
+
+	expected := map[int32][]kafkaRecord{}
+	expected[api] = append(expected[api],
+		kafkaRecord{key: []byte("api"), value: []byte(`{"source":"api","message":"1"}`)},
+		kafkaRecord{key: []byte("api"), value: []byte(`{"source":"api","message":"3"}`)},
+	)
+	expected[web] = append(expected[web],
+		kafkaRecord{key: []byte("web"), value: []byte(`{"source":"web","message":"2"}`)},
+	)
+
+	assert.Equal(t, expected, broker.Records())
+	assert.Equal(t, []int16{KafkaAcksAll}, broker.Acks())
+}
+
+func TestKafkaConn_StoreSticky(t *testing.T) {
+	broker := newFakeKafka(t, "logs", 2)
+	defer broker.Close()
+
+	conn, err := newConn("kafka://"+broker.Addr()+"/logs?acks=0", Config{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer conn.Close()
+
+	for _, entry := range []string{"1", "2", "3"} {
+		code, err := conn.Store(context.Background(), [][]byte{[]byte(entry)})
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, code)
+	}
+
+	// Requests without acknowledgements have no responses.
+	assert.Eventually(t, func() bool {
+		return assert.ObjectsAreEqual(map[int32][]kafkaRecord{
+			0: {{value: []byte("1")}, {value: []byte("3")}},
+			1: {{value: []byte("2")}},
+		}, broker.Records())
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestKafkaConn_StorePartial(t *testing.T) {
+	broker := newFakeKafka(t, "logs", 3)
+	defer broker.Close()
+
+	broker.codes[0] = kafkaErrNotLeaderOrFollower
+	broker.codes[1] = 10 // MESSAGE_TOO_LARGE
+
+	conn, err := newConn("kafka://"+broker.Addr()+"/logs?key=id", Config{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer conn.Close()
+
+	batch := make([][]byte, 0, 30)
+	partitions := make([]int32, 0, 30)
+
+	for i := 0; i < 30; i++ {
+		id := strconv.Itoa(i)
+
+		batch = append(batch, []byte(`{"id":"`+id+`"}`))
+		partitions = append(partitions, (kafkaMurmur2([]byte(id))&0x7fffffff)%3)
+	}
+
+	expected := &PartialError{}
+
+	for idx, partition := range partitions {
+		switch partition {
+		case 0:
+			expected.Retry = append(expected.Retry, idx)
+		case 1:
+			expected.Rejected = append(expected.Rejected, idx)
+		}
+	}
+
+	code, err := conn.Store(context.Background(), batch)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, expected, err)
+
+	code, err = conn.Store(context.Background(), pickBatch(batch, expected.Retry))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+}
+
+func TestKafkaConn_Ping(t *testing.T) {
+	broker := newFakeKafka(t, "logs", 1)
+	defer broker.Close()
+
+	tests := []struct {
+		name         string
+		dsn          string
+		expectedCode int
+		wantErr      bool
+	}{
+		{
+			name:         "OK",
+			dsn:          "kafka://127.0.0.1:1," + broker.Addr() + "/logs",
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "UnknownTopic",
+			dsn:          "kafka://" + broker.Addr() + "/other",
+			expectedCode: http.StatusNotFound,
+		},
+		{
+			name:    "Unavailable",
+			dsn:     "kafka://127.0.0.1:1/logs",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := newConn(tt.dsn, Config{}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			code, err := conn.Ping(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Ping() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			assert.Equal(t, tt.expectedCode, code)
+		})
+	}
+}
+
+func TestNewKafkaConn(t *testing.T) {
+	tests := []struct {
+		name        string
+		dsn         string
+		expectedErr error
+	}{
+		{name: "NoTopic", dsn: "kafka://127.0.0.1", expectedErr: ErrBadKafkaTopic},
+		{name: "BadAcks", dsn: "kafka://127.0.0.1/logs?acks=2", expectedErr: ErrBadKafkaAcks},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newConn(tt.dsn, Config{}, nil)
+			assert.ErrorIs(t, err, tt.expectedErr)
+		})
+	}
+
+	conn, err := newConn("kafka://b1,b2:9093/logs", Config{}, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"b1:9092", "b2:9093"}, conn.(*kafkaConn).bootstrap)
+}
+
+func pickBatch(batch [][]byte, indexes []int) [][]byte {
+	res := make([][]byte, 0, len(indexes))
+
+	for _, idx := range indexes {
+		res = append(res, batch[idx])
+	}
+
+	return res
+}
@@ -0,0 +1,377 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const (
+	SchemeLokiHTTP  = "loki+http"
+	SchemeLokiHTTPS = "loki+https"
+)
+
+// Loki payload formats, selected by format query parameter of the dsn.
+const (
+	LokiProtobuf = "protobuf"
+	LokiJSON     = "json"
+)
+
+const (
+	lokiPushURI  = "/loki/api/v1/push"
+	lokiReadyURI = "/ready"
+
+	lokiLabelsParam = "labels"
+	lokiFormatParam = "format"
+	lokiTenantParam = "tenant"
+
+	lokiTenantHeader = "X-Scope-OrgID"
+
+	protobufContentType = "application/x-protobuf"
+	jsonContentType     = "application/json"
+)
+
+// DefaultLokiLabels are entry fields used as stream labels.
+var DefaultLokiLabels = []string{"namespace", "source", "level"}
+
+// lokiDefaultStream labels entries without any of label fields,
+// Loki rejects streams without labels.
+var lokiDefaultStream = []lokiLabel{{name: "job", value: "lhw"}}
+
+var ErrBadLokiFormat = errors.New("loki format invalid")
+
+// lokiConn pushes entries to Loki, entries are grouped into streams
+// by values of label fields of the entry.
+type lokiConn struct {
+	client *http.Client
+	addr   string
+	user   *url.Userinfo
+	tenant string
+	labels []string
+	format string
+}
+
+type lokiLabel struct {
+	name  string
+	value string
+}
+
+type lokiEntry struct {
+	time time.Time
+	line string
+}
+
+type lokiStream struct {
+	labels  []lokiLabel
+	entries []lokiEntry
+}
+
+func newLokiConn(dsn *url.URL, _ Config, transport http.RoundTripper) (Conn, error) {
+	query := dsn.Query()
+
+	c := &lokiConn{
+		client: &http.Client{Transport: transport},
+		user:   dsn.User,
+		tenant: query.Get(lokiTenantParam),
+		labels: DefaultLokiLabels,
+		format: LokiProtobuf,
+	}
+
+	if labels := query.Get(lokiLabelsParam); labels != "" {
+		c.labels = strings.Split(labels, ",")
+	}
+
+	if format := query.Get(lokiFormatParam); format != "" {
+		if format != LokiProtobuf && format != LokiJSON {
+			return nil, ErrBadLokiFormat
+		}
+
+		c.format = format
+	}
+
+	addr := url.URL{
+		Scheme: strings.TrimPrefix(dsn.Scheme, "loki+"),
+		Host:   dsn.Host,
+		Path:   strings.TrimSuffix(dsn.Path, "/"),
+	}
+
+	c.addr = addr.String()
+
+	return c, nil
+}
+
+// Store pushes the batch, any 2xx status is reported as 200.
+func (c *lokiConn) Store(ctx context.Context, batch [][]byte) (code int, err error) {
+	streams := c.streams(batch, time.Now())
+
+	var (
+		body        []byte
+		contentType string
+	)
+
+	if c.format == LokiJSON {
+		body, err = encodeLokiJSON(streams)
+		contentType = jsonContentType
+	} else {
+		body = snappy.Encode(nil, encodeLokiProtobuf(streams))
+		contentType = protobufContentType
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	return c.do(ctx, http.MethodPost, lokiPushURI, body, contentType)
+}
+
+func (c *lokiConn) Ping(ctx context.Context) (code int, err error) {
+	return c.do(ctx, http.MethodGet, lokiReadyURI, nil, "")
+}
+
+func (c *lokiConn) Close() error {
+	return nil
+}
+
+func (c *lokiConn) do(ctx context.Context, method, uri string, body []byte, contentType string) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.addr+uri, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+
+	if contentType != "" {
+		req.Header.Set(contentTypeHeader, contentType)
+	}
+
+	if c.tenant != "" {
+		req.Header.Set(lokiTenantHeader, c.tenant)
+	}
+
+	if c.user != nil {
+		password, _ := c.user.Password()
+		req.SetBasicAuth(c.user.Username(), password)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := resp.Body.Close(); err != nil {
+		return 0, err
+	}
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return http.StatusOK, nil
+	}
+
+	return resp.StatusCode, throttleError(resp)
+}
+
+// streams groups entries by labels, entries of every stream are ordered by time.
+func (c *lokiConn) streams(batch [][]byte, now time.Time) []*lokiStream {
+	var (
+		streams []*lokiStream
+		index   = make(map[string]*lokiStream)
+	)
+
+	for _, entry := range batch {
+		entry = bytes.TrimSpace(entry)
+
+		fields := make(map[string]interface{})
+
+		// Entries which are not json objects are sent to the default stream.
+		_ = json.Unmarshal(entry, &fields)
+
+		labels := lokiLabels(fields, c.labels)
+		key := formatLokiLabels(labels)
+
+		stream, ok := index[key]
+		if !ok {
+			stream = &lokiStream{labels: labels}
+			index[key] = stream
+			streams = append(streams, stream)
+		}
+
+		stamp := now
+
+		if value, ok := fields["time"].(string); ok {
+			if parsed, err := time.Parse(time.RFC3339Nano, value); err == nil {
+				stamp = parsed
+			}
+		}
+
+		stream.entries = append(stream.entries, lokiEntry{time: stamp, line: string(entry)})
+	}
+
+	for _, stream := range streams {
+		entries := stream.entries
+
+		sort.SliceStable(entries, func(i, j int) bool {
+			return entries[i].time.Before(entries[j].time)
+		})
+	}
+
+	return streams
+}
+
+func lokiLabels(fields map[string]interface{}, names []string) []lokiLabel {
+	labels := make([]lokiLabel, 0, len(names))
+
+	for _, name := range names {
+		value, ok := fields[name]
+		if !ok || value == nil {
+			continue
+		}
+
+		var str string
+
+		switch v := value.(type) {
+		case string:
+			str = v
+		case float64:
+			str = strconv.FormatFloat(v, 'f', -1, 64)
+		case bool:
+			str = strconv.FormatBool(v)
+		default:
+			continue
+		}
+
+		if str != "" {
+			labels = append(labels, lokiLabel{name: lokiLabelName(name), value: str})
+		}
+	}
+
+	if len(labels) == 0 {
+		return lokiDefaultStream
+	}
+
+	sort.Slice(labels, func(i, j int) bool {
+		return labels[i].name < labels[j].name
+	})
+
+	return labels
+}
+
+// lokiLabelName replaces characters not allowed in label names with underscores.
+func lokiLabelName(name string) string {
+	label := []byte(name)
+
+	for idx, c := range label {
+		switch {
+		case c == '_', c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		case c >= '0' && c <= '9' && idx > 0:
+		default:
+			label[idx] = '_'
+		}
+	}
+
+	return string(label)
+}
+
+// formatLokiLabels formats labels in LogQL stream selector syntax, e.g. {level="info"}.
+func formatLokiLabels(labels []lokiLabel) string {
+	var b strings.Builder
+
+	b.WriteByte('{')
+
+	for idx, label := range labels {
+		if idx > 0 {
+			b.WriteString(", ")
+		}
+
+		b.WriteString(label.name)
+		b.WriteByte('=')
+		b.WriteString(strconv.Quote(label.value))
+	}
+
+	b.WriteByte('}')
+
+	return b.String()
+}
+
+type lokiJSONStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+func encodeLokiJSON(streams []*lokiStream) ([]byte, error) {
+	payload := struct {
+		Streams []lokiJSONStream `json:"streams"`
+	}{
+		Streams: make([]lokiJSONStream, 0, len(streams)),
+	}
+
+	for _, stream := range streams {
+		item := lokiJSONStream{
+			Stream: make(map[string]string, len(stream.labels)),
+			Values: make([][2]string, 0, len(stream.entries)),
+		}
+
+		for _, label := range stream.labels {
+			item.Stream[label.name] = label.value
+		}
+
+		for _, entry := range stream.entries {
+			item.Values = append(item.Values, [2]string{strconv.FormatInt(entry.time.UnixNano(), 10), entry.line})
+		}
+
+		payload.Streams = append(payload.Streams, item)
+	}
+
+	return json.Marshal(payload)
+}
+
+// encodeLokiProtobuf encodes logproto.PushRequest:
+//
+//	message PushRequest { repeated StreamAdapter streams = 1; }
+//	message StreamAdapter { string labels = 1; repeated EntryAdapter entries = 2; }
+//	message EntryAdapter { google.protobuf.Timestamp timestamp = 1; string line = 2; }
+func encodeLokiProtobuf(streams []*lokiStream) []byte {
+	var buf []byte
+
+	for _, stream := range streams {
+		var msg []byte
+
+		msg = protowire.AppendTag(msg, 1, protowire.BytesType)
+		msg = protowire.AppendString(msg, formatLokiLabels(stream.labels))
+
+		for _, entry := range stream.entries {
+			var stamp []byte
+
+			if seconds := entry.time.Unix(); seconds != 0 {
+				stamp = protowire.AppendTag(stamp, 1, protowire.VarintType)
+				stamp = protowire.AppendVarint(stamp, uint64(seconds))
+			}
+
+			if nanos := entry.time.Nanosecond(); nanos != 0 {
+				stamp = protowire.AppendTag(stamp, 2, protowire.VarintType)
+				stamp = protowire.AppendVarint(stamp, uint64(nanos))
+			}
+
+			var item []byte
+
+			item = protowire.AppendTag(item, 1, protowire.BytesType)
+			item = protowire.AppendBytes(item, stamp)
+			item = protowire.AppendTag(item, 2, protowire.BytesType)
+			item = protowire.AppendString(item, entry.line)
+
+			msg = protowire.AppendTag(msg, 2, protowire.BytesType)
+			msg = protowire.AppendBytes(msg, item)
+		}
+
+		buf = protowire.AppendTag(buf, 1, protowire.BytesType)
+		buf = protowire.AppendBytes(buf, msg)
+	}
+
+	return buf
+}
@@ -0,0 +1,186 @@
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+var lokiBatch = [][]byte{
+	[]byte(`{"source":"api","level":"info","time":"2021-01-02T03:04:06Z","message":"2"}`),
+	[]byte(`{"source":"api","level":"error","time":"2021-01-02T03:04:05Z","message":"1"}`),
+	[]byte(`{"level":"info","source":"api","time":"2021-01-02T03:04:05Z","message":"1"}`),
+	[]byte("plain text"),
+}
+
+func TestLokiConn_JSON(t *testing.T) {
+	var payload struct {
+		Streams []lokiJSONStream `json:"streams"`
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, lokiPushURI, r.URL.Path)
+		assert.Equal(t, jsonContentType, r.Header.Get(contentTypeHeader))
+		assert.Equal(t, "team", r.Header.Get(lokiTenantHeader))
+
+		user, password, ok := r.BasicAuth()
+		assert.True(t, ok)
+		assert.Equal(t, "user", user)
+		assert.Equal(t, "secret", password)
+
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&payload))
+
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	dsn := "loki+http://user:secret@" + ts.Listener.Addr().String() + "?format=json&tenant=team&labels=source,level"
+
+	conn, err := newConn(dsn, Config{}, ts.Client().Transport)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code, err := conn.Store(context.Background(), lokiBatch)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, code)
+
+	now := payload.Streams[2].Values[0][0]
+
+	assert.Equal(t, []lokiJSONStream{
+		{
+			Stream: map[string]string{"level": "info", "source": "api"},
+			Values: [][2]string{
+				{"1609556645000000000", string(lokiBatch[2])},
+				{"1609556646000000000", string(lokiBatch[0])},
+			},
+		},
+		{
+			Stream: map[string]string{"level": "error", "source": "api"},
+			Values: [][2]string{{"1609556645000000000", string(lokiBatch[1])}},
+		},
+		{
+			Stream: map[string]string{"job": "lhw"},
+			Values: [][2]string{{now, "plain text"}},
+		},
+	}, payload.Streams)
+}
+
+func TestLokiConn_Protobuf(t *testing.T) {
+	var streams []string
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == lokiReadyURI {
+			w.WriteHeader(http.StatusOK)
+
+			return
+		}
+
+		assert.Equal(t, protobufContentType, r.Header.Get(contentTypeHeader))
+
+		data, err := ioutil.ReadAll(r.Body)
+		assert.Nil(t, err)
+
+		data, err = snappy.Decode(nil, data)
+		assert.Nil(t, err)
+
+		streams = decodeLokiLabels(t, data)
+
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer ts.Close()
+
+	conn, err := newConn("loki+"+ts.URL, Config{}, ts.Client().Transport)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code, err := conn.Ping(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, code)
+
+	code, err = conn.Store(context.Background(), lokiBatch)
+	assert.Equal(t, &ThrottleError{Delay: DefaultThrottleDelay}, err)
+	assert.Equal(t, http.StatusTooManyRequests, code)
+
+	assert.Equal(t, []string{
+		`{level="info", source="api"}`,
+		`{level="error", source="api"}`,
+		`{job="lhw"}`,
+	}, streams)
+}
+
+func TestEncodeLokiProtobuf(t *testing.T) {
+	stamp := time.Unix(1609556645, 500)
+
+	data := encodeLokiProtobuf([]*lokiStream{{
+		labels:  []lokiLabel{{name: "level", value: "info"}},
+		entries: []lokiEntry{{time: stamp, line: "line"}},
+	}})
+
+	stream, n := protowire.ConsumeBytes(data[1:])
+	assert.Equal(t, len(data)-1, n)
+
+	// labels
+	_, _, n = protowire.ConsumeTag(stream)
+	labels, m := protowire.ConsumeString(stream[n:])
+	assert.Equal(t, `{level="info"}`, labels)
+
+	// entry
+	stream = stream[n+m:]
+	_, _, n = protowire.ConsumeTag(stream)
+	entry, _ := protowire.ConsumeBytes(stream[n:])
+
+	_, _, n = protowire.ConsumeTag(entry)
+	timestamp, m := protowire.ConsumeBytes(entry[n:])
+
+	_, _, k := protowire.ConsumeTag(entry[n+m:])
+	line, _ := protowire.ConsumeString(entry[n+m+k:])
+	assert.Equal(t, "line", line)
+
+	_, _, n = protowire.ConsumeTag(timestamp)
+	seconds, m := protowire.ConsumeVarint(timestamp[n:])
+	assert.Equal(t, uint64(1609556645), seconds)
+
+	_, _, k = protowire.ConsumeTag(timestamp[n+m:])
+	nanos, _ := protowire.ConsumeVarint(timestamp[n+m+k:])
+	assert.Equal(t, uint64(500), nanos)
+}
+
+func TestLokiLabelName(t *testing.T) {
+	assert.Equal(t, "k8s_namespace", lokiLabelName("k8s.namespace"))
+	assert.Equal(t, "_app", lokiLabelName("1app"))
+}
+
+// decodeLokiLabels returns labels of all streams of the push request.
+func decodeLokiLabels(t *testing.T, data []byte) []string {
+	t.Helper()
+
+	var res []string
+
+	for len(data) > 0 {
+		_, _, n := protowire.ConsumeTag(data)
+		stream, m := protowire.ConsumeBytes(data[n:])
+
+		if m < 0 {
+			t.Fatal(protowire.ParseError(m))
+		}
+
+		data = data[n+m:]
+
+		_, _, n = protowire.ConsumeTag(stream)
+		labels, _ := protowire.ConsumeString(stream[n:])
+
+		res = append(res, labels)
+	}
+
+	return res
+}
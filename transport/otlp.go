@@ -0,0 +1,567 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const (
+	SchemeOTLPHTTP  = "otlp+http"
+	SchemeOTLPHTTPS = "otlp+https"
+)
+
+// OTLP payload formats, selected by format query parameter of the dsn.
+const (
+	OTLPProtobuf = "protobuf"
+	OTLPJSON     = "json"
+)
+
+const (
+	otlpLogsURI = "/v1/logs"
+
+	otlpFormatParam = "format"
+
+	otlpScopeName = "github.com/loghole/lhw"
+)
+
+// OpenTelemetry severity numbers of the first value of every range.
+const (
+	otlpSeverityTrace = 1
+	otlpSeverityDebug = 5
+	otlpSeverityInfo  = 9
+	otlpSeverityWarn  = 13
+	otlpSeverityError = 17
+	otlpSeverityFatal = 21
+)
+
+var ErrBadOTLPFormat = errors.New("otlp format invalid")
+
+var otlpSeverities = map[string]int{
+	"trace": otlpSeverityTrace,
+	"debug": otlpSeverityDebug,
+	"info":  otlpSeverityInfo, "notice": otlpSeverityInfo,
+	"warn": otlpSeverityWarn, "warning": otlpSeverityWarn,
+	"error": otlpSeverityError, "err": otlpSeverityError, "dpanic": otlpSeverityError,
+	"fatal": otlpSeverityFatal, "panic": otlpSeverityFatal, "crit": otlpSeverityFatal,
+}
+
+// otlpResourceFields are entry fields mapped to resource attributes.
+var otlpResourceFields = map[string]string{
+	"host":      "host.name",
+	"namespace": "service.namespace",
+	"source":    "service.name",
+}
+
+// otlpRecordFields are entry fields mapped to fields of the log record.
+var otlpRecordFields = map[string]bool{
+	"time":     true,
+	"level":    true,
+	"message":  true,
+	"trace_id": true,
+	"span_id":  true,
+}
+
+// otlpConn exports entries as OpenTelemetry log records with OTLP/HTTP.
+// Records are grouped into resources by host, namespace and source fields,
+// level, message, time, trace_id and span_id are mapped to fields of
+// the record, other entry fields are record attributes.
+type otlpConn struct {
+	client *http.Client
+	addr   string
+	user   *url.Userinfo
+	format string
+}
+
+type otlpAttr struct {
+	key   string
+	value interface{}
+}
+
+type otlpRecord struct {
+	time         time.Time
+	severity     int
+	severityText string
+	body         interface{}
+	traceID      []byte
+	spanID       []byte
+	attrs        []otlpAttr
+}
+
+type otlpResource struct {
+	attrs   []otlpAttr
+	records []*otlpRecord
+}
+
+func newOTLPConn(dsn *url.URL, _ Config, transport http.RoundTripper) (Conn, error) {
+	c := &otlpConn{
+		client: &http.Client{Transport: transport},
+		user:   dsn.User,
+		format: OTLPProtobuf,
+	}
+
+	if format := dsn.Query().Get(otlpFormatParam); format != "" {
+		if format != OTLPProtobuf && format != OTLPJSON {
+			return nil, ErrBadOTLPFormat
+		}
+
+		c.format = format
+	}
+
+	addr := url.URL{
+		Scheme: strings.TrimPrefix(dsn.Scheme, "otlp+"),
+		Host:   dsn.Host,
+		Path:   strings.TrimSuffix(dsn.Path, "/"),
+	}
+
+	c.addr = addr.String()
+
+	return c, nil
+}
+
+// Store exports the batch, any 2xx status is reported as 200.
+func (c *otlpConn) Store(ctx context.Context, batch [][]byte) (code int, err error) {
+	return c.export(ctx, otlpResources(batch, time.Now()))
+}
+
+// Ping exports an empty request, OTLP receivers have no health endpoint.
+func (c *otlpConn) Ping(ctx context.Context) (code int, err error) {
+	return c.export(ctx, nil)
+}
+
+func (c *otlpConn) Close() error {
+	return nil
+}
+
+func (c *otlpConn) export(ctx context.Context, resources []*otlpResource) (int, error) {
+	var (
+		body        []byte
+		contentType string
+		err         error
+	)
+
+	if c.format == OTLPJSON {
+		body, err = encodeOTLPJSON(resources)
+		contentType = jsonContentType
+	} else {
+		body = encodeOTLPProtobuf(resources)
+		contentType = protobufContentType
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.addr+otlpLogsURI, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set(contentTypeHeader, contentType)
+
+	if c.user != nil {
+		if password, ok := c.user.Password(); ok {
+			req.SetBasicAuth(c.user.Username(), password)
+		} else {
+			req.Header.Set(authorizationHeader, "Bearer"+" "+c.user.Username())
+		}
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := resp.Body.Close(); err != nil {
+		return 0, err
+	}
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return http.StatusOK, nil
+	}
+
+	return resp.StatusCode, throttleError(resp)
+}
+
+// otlpResources converts entries to log records grouped by resource attributes.
+func otlpResources(batch [][]byte, now time.Time) []*otlpResource {
+	var (
+		resources []*otlpResource
+		index     = make(map[string]*otlpResource)
+	)
+
+	for _, entry := range batch {
+		resourceAttrs, record := otlpParse(bytes.TrimSpace(entry), now)
+
+		key := otlpResourceKey(resourceAttrs)
+
+		resource, ok := index[key]
+		if !ok {
+			resource = &otlpResource{attrs: resourceAttrs}
+			index[key] = resource
+			resources = append(resources, resource)
+		}
+
+		resource.records = append(resource.records, record)
+	}
+
+	return resources
+}
+
+// otlpResourceKey identifies the resource by its attributes.
+func otlpResourceKey(attrs []otlpAttr) string {
+	var b strings.Builder
+
+	for _, attr := range attrs {
+		value, _ := json.Marshal(attr.value)
+
+		b.WriteString(attr.key)
+		b.WriteByte('=')
+		b.Write(value)
+		b.WriteByte(',')
+	}
+
+	return b.String()
+}
+
+func otlpParse(entry []byte, now time.Time) ([]otlpAttr, *otlpRecord) {
+	record := &otlpRecord{time: now}
+
+	var fields map[string]interface{}
+
+	decoder := json.NewDecoder(bytes.NewReader(entry))
+	decoder.UseNumber()
+
+	// Entries which are not json objects are sent as the record body.
+	if err := decoder.Decode(&fields); err != nil || fields == nil {
+		record.body = string(entry)
+
+		return nil, record
+	}
+
+	if value, ok := fields["time"].(string); ok {
+		if parsed, err := time.Parse(time.RFC3339Nano, value); err == nil {
+			record.time = parsed
+		}
+	}
+
+	if value, ok := fields["level"].(string); ok {
+		record.severityText = value
+		record.severity = otlpSeverities[strings.ToLower(value)]
+	}
+
+	record.body = fields["message"]
+	record.traceID = otlpID(fields["trace_id"], 16)
+	record.spanID = otlpID(fields["span_id"], 8)
+
+	var resourceAttrs []otlpAttr
+
+	for key, value := range fields {
+		switch {
+		case otlpResourceFields[key] != "":
+			resourceAttrs = append(resourceAttrs, otlpAttr{key: otlpResourceFields[key], value: value})
+		case key == "trace_id" && record.traceID == nil, key == "span_id" && record.spanID == nil:
+			// Identifiers which are not valid hex are kept as attributes.
+			record.attrs = append(record.attrs, otlpAttr{key: key, value: value})
+		case otlpRecordFields[key]:
+		default:
+			record.attrs = append(record.attrs, otlpAttr{key: key, value: value})
+		}
+	}
+
+	sortOTLPAttrs(resourceAttrs)
+	sortOTLPAttrs(record.attrs)
+
+	return resourceAttrs, record
+}
+
+// otlpID decodes hex trace or span id, invalid ids are ignored.
+func otlpID(value interface{}, size int) []byte {
+	str, ok := value.(string)
+	if !ok || len(str) != size*2 {
+		return nil
+	}
+
+	id, err := hex.DecodeString(str)
+	if err != nil {
+		return nil
+	}
+
+	return id
+}
+
+func sortOTLPAttrs(attrs []otlpAttr) {
+	sort.Slice(attrs, func(i, j int) bool {
+		return attrs[i].key < attrs[j].key
+	})
+}
+
+// otlpKeyValues returns sorted attributes of the json object.
+func otlpKeyValues(object map[string]interface{}) []otlpAttr {
+	attrs := make([]otlpAttr, 0, len(object))
+
+	for key, value := range object {
+		attrs = append(attrs, otlpAttr{key: key, value: value})
+	}
+
+	sortOTLPAttrs(attrs)
+
+	return attrs
+}
+
+// encodeOTLPProtobuf encodes ExportLogsServiceRequest:
+//
+//	message ExportLogsServiceRequest { repeated ResourceLogs resource_logs = 1; }
+//	message ResourceLogs { Resource resource = 1; repeated ScopeLogs scope_logs = 2; }
+//	message Resource { repeated KeyValue attributes = 1; }
+//	message ScopeLogs { InstrumentationScope scope = 1; repeated LogRecord log_records = 2; }
+//	message LogRecord {
+//	  fixed64 time_unix_nano = 1; SeverityNumber severity_number = 2; string severity_text = 3;
+//	  AnyValue body = 5; repeated KeyValue attributes = 6; bytes trace_id = 9; bytes span_id = 10;
+//	  fixed64 observed_time_unix_nano = 11;
+//	}
+func encodeOTLPProtobuf(resources []*otlpResource) []byte {
+	var buf []byte
+
+	for _, resource := range resources {
+		var attrs []byte
+
+		for _, attr := range resource.attrs {
+			attrs = protowire.AppendTag(attrs, 1, protowire.BytesType)
+			attrs = protowire.AppendBytes(attrs, encodeOTLPKeyValue(attr))
+		}
+
+		var scope []byte
+
+		scope = protowire.AppendTag(scope, 1, protowire.BytesType)
+		scope = protowire.AppendString(scope, otlpScopeName)
+
+		var scopeLogs []byte
+
+		scopeLogs = protowire.AppendTag(scopeLogs, 1, protowire.BytesType)
+		scopeLogs = protowire.AppendBytes(scopeLogs, scope)
+
+		for _, record := range resource.records {
+			scopeLogs = protowire.AppendTag(scopeLogs, 2, protowire.BytesType)
+			scopeLogs = protowire.AppendBytes(scopeLogs, encodeOTLPRecord(record))
+		}
+
+		var msg []byte
+
+		msg = protowire.AppendTag(msg, 1, protowire.BytesType)
+		msg = protowire.AppendBytes(msg, attrs)
+		msg = protowire.AppendTag(msg, 2, protowire.BytesType)
+		msg = protowire.AppendBytes(msg, scopeLogs)
+
+		buf = protowire.AppendTag(buf, 1, protowire.BytesType)
+		buf = protowire.AppendBytes(buf, msg)
+	}
+
+	return buf
+}
+
+func encodeOTLPRecord(record *otlpRecord) []byte {
+	var msg []byte
+
+	msg = protowire.AppendTag(msg, 1, protowire.Fixed64Type)
+	msg = protowire.AppendFixed64(msg, uint64(record.time.UnixNano()))
+
+	if record.severity != 0 {
+		msg = protowire.AppendTag(msg, 2, protowire.VarintType)
+		msg = protowire.AppendVarint(msg, uint64(record.severity))
+	}
+
+	if record.severityText != "" {
+		msg = protowire.AppendTag(msg, 3, protowire.BytesType)
+		msg = protowire.AppendString(msg, record.severityText)
+	}
+
+	if record.body != nil {
+		msg = protowire.AppendTag(msg, 5, protowire.BytesType)
+		msg = protowire.AppendBytes(msg, encodeOTLPValue(record.body))
+	}
+
+	for _, attr := range record.attrs {
+		msg = protowire.AppendTag(msg, 6, protowire.BytesType)
+		msg = protowire.AppendBytes(msg, encodeOTLPKeyValue(attr))
+	}
+
+	if record.traceID != nil {
+		msg = protowire.AppendTag(msg, 9, protowire.BytesType)
+		msg = protowire.AppendBytes(msg, record.traceID)
+	}
+
+	if record.spanID != nil {
+		msg = protowire.AppendTag(msg, 10, protowire.BytesType)
+		msg = protowire.AppendBytes(msg, record.spanID)
+	}
+
+	return msg
+}
+
+// encodeOTLPKeyValue encodes KeyValue { string key = 1; AnyValue value = 2; }.
+func encodeOTLPKeyValue(attr otlpAttr) []byte {
+	var msg []byte
+
+	msg = protowire.AppendTag(msg, 1, protowire.BytesType)
+	msg = protowire.AppendString(msg, attr.key)
+	msg = protowire.AppendTag(msg, 2, protowire.BytesType)
+	msg = protowire.AppendBytes(msg, encodeOTLPValue(attr.value))
+
+	return msg
+}
+
+// encodeOTLPValue encodes AnyValue of json value:
+//
+//	oneof value {
+//	  string string_value = 1; bool bool_value = 2; int64 int_value = 3; double double_value = 4;
+//	  ArrayValue array_value = 5; KeyValueList kvlist_value = 6;
+//	}
+func encodeOTLPValue(value interface{}) []byte {
+	var msg []byte
+
+	switch v := value.(type) {
+	case string:
+		msg = protowire.AppendTag(msg, 1, protowire.BytesType)
+		msg = protowire.AppendString(msg, v)
+	case bool:
+		msg = protowire.AppendTag(msg, 2, protowire.VarintType)
+		msg = protowire.AppendVarint(msg, protowire.EncodeBool(v))
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			msg = protowire.AppendTag(msg, 3, protowire.VarintType)
+			msg = protowire.AppendVarint(msg, uint64(i))
+
+			break
+		}
+
+		f, _ := v.Float64()
+
+		msg = protowire.AppendTag(msg, 4, protowire.Fixed64Type)
+		msg = protowire.AppendFixed64(msg, math.Float64bits(f))
+	case []interface{}:
+		var values []byte
+
+		for _, item := range v {
+			values = protowire.AppendTag(values, 1, protowire.BytesType)
+			values = protowire.AppendBytes(values, encodeOTLPValue(item))
+		}
+
+		msg = protowire.AppendTag(msg, 5, protowire.BytesType)
+		msg = protowire.AppendBytes(msg, values)
+	case map[string]interface{}:
+		var values []byte
+
+		for _, attr := range otlpKeyValues(v) {
+			values = protowire.AppendTag(values, 1, protowire.BytesType)
+			values = protowire.AppendBytes(values, encodeOTLPKeyValue(attr))
+		}
+
+		msg = protowire.AppendTag(msg, 6, protowire.BytesType)
+		msg = protowire.AppendBytes(msg, values)
+	}
+
+	return msg
+}
+
+// encodeOTLPJSON encodes ExportLogsServiceRequest with OTLP json mapping:
+// 64 bit integers are strings, trace and span ids are hex strings.
+func encodeOTLPJSON(resources []*otlpResource) ([]byte, error) {
+	resourceLogs := make([]interface{}, 0, len(resources))
+
+	for _, resource := range resources {
+		records := make([]interface{}, 0, len(resource.records))
+
+		for _, record := range resource.records {
+			item := map[string]interface{}{
+				"timeUnixNano": strconv.FormatInt(record.time.UnixNano(), 10),
+			}
+
+			if record.severity != 0 {
+				item["severityNumber"] = record.severity
+			}
+
+			if record.severityText != "" {
+				item["severityText"] = record.severityText
+			}
+
+			if record.body != nil {
+				item["body"] = otlpJSONValue(record.body)
+			}
+
+			if len(record.attrs) > 0 {
+				item["attributes"] = otlpJSONKeyValues(record.attrs)
+			}
+
+			if record.traceID != nil {
+				item["traceId"] = hex.EncodeToString(record.traceID)
+			}
+
+			if record.spanID != nil {
+				item["spanId"] = hex.EncodeToString(record.spanID)
+			}
+
+			records = append(records, item)
+		}
+
+		resourceLogs = append(resourceLogs, map[string]interface{}{
+			"resource": map[string]interface{}{"attributes": otlpJSONKeyValues(resource.attrs)},
+			"scopeLogs": []interface{}{map[string]interface{}{
+				"scope":      map[string]interface{}{"name": otlpScopeName},
+				"logRecords": records,
+			}},
+		})
+	}
+
+	return json.Marshal(map[string]interface{}{"resourceLogs": resourceLogs})
+}
+
+func otlpJSONKeyValues(attrs []otlpAttr) []interface{} {
+	values := make([]interface{}, 0, len(attrs))
+
+	for _, attr := range attrs {
+		values = append(values, map[string]interface{}{"key": attr.key, "value": otlpJSONValue(attr.value)})
+	}
+
+	return values
+}
+
+func otlpJSONValue(value interface{}) map[string]interface{} {
+	switch v := value.(type) {
+	case string:
+		return map[string]interface{}{"stringValue": v}
+	case bool:
+		return map[string]interface{}{"boolValue": v}
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return map[string]interface{}{"intValue": strconv.FormatInt(i, 10)}
+		}
+
+		f, _ := v.Float64()
+
+		return map[string]interface{}{"doubleValue": f}
+	case []interface{}:
+		values := make([]interface{}, 0, len(v))
+
+		for _, item := range v {
+			values = append(values, otlpJSONValue(item))
+		}
+
+		return map[string]interface{}{"arrayValue": map[string]interface{}{"values": values}}
+	case map[string]interface{}:
+		return map[string]interface{}{"kvlistValue": map[string]interface{}{"values": otlpJSONKeyValues(otlpKeyValues(v))}}
+	default:
+		return map[string]interface{}{}
+	}
+}
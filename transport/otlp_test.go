@@ -0,0 +1,181 @@
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func TestOTLPResources(t *testing.T) {
+	now := time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	resources := otlpResources([][]byte{
+		[]byte(`{"source":"api","level":"Error","message":"failed","time":"2021-01-02T03:04:06Z",` +
+			`"trace_id":"0102030405060708090a0b0c0d0e0f10","span_id":"0102030405060708","code":500}`),
+		[]byte(`{"source":"web","level":"info","message":"ok","trace_id":"bad"}`),
+		[]byte(`{"source":"api","level":"debug","message":{"text":"m"},"ratio":0.5}`),
+		[]byte("plain text"),
+	}, now)
+
+	assert.Equal(t, []*otlpResource{
+		{
+			attrs: []otlpAttr{{key: "service.name", value: "api"}},
+			records: []*otlpRecord{
+				{
+					time:         now.Add(time.Second),
+					severity:     otlpSeverityError,
+					severityText: "Error",
+					body:         "failed",
+					traceID:      []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16},
+					spanID:       []byte{1, 2, 3, 4, 5, 6, 7, 8},
+					attrs:        []otlpAttr{{key: "code", value: json.Number("500")}},
+				},
+				{
+					time:         now,
+					severity:     otlpSeverityDebug,
+					severityText: "debug",
+					body:         map[string]interface{}{"text": "m"},
+					attrs:        []otlpAttr{{key: "ratio", value: json.Number("0.5")}},
+				},
+			},
+		},
+		{
+			attrs: []otlpAttr{{key: "service.name", value: "web"}},
+			records: []*otlpRecord{
+				{
+					time:         now,
+					severity:     otlpSeverityInfo,
+					severityText: "info",
+					body:         "ok",
+					attrs:        []otlpAttr{{key: "trace_id", value: "bad"}},
+				},
+			},
+		},
+		{
+			records: []*otlpRecord{{time: now, body: "plain text"}},
+		},
+	}, resources)
+}
+
+func TestOTLPConn_JSON(t *testing.T) {
+	var payload map[string]interface{}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, otlpLogsURI, r.URL.Path)
+		assert.Equal(t, jsonContentType, r.Header.Get(contentTypeHeader))
+		assert.Equal(t, "Bearer token", r.Header.Get(authorizationHeader))
+
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&payload))
+
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer ts.Close()
+
+	conn, err := newConn("otlp+http://token@"+ts.Listener.Addr().String()+"?format=json", Config{}, ts.Client().Transport)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code, err := conn.Store(context.Background(), [][]byte{
+		[]byte(`{"host":"h1","level":"warn","message":"m","time":"2021-01-02T03:04:05Z","span_id":"0102030405060708","tags":["a",true]}`),
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, code)
+
+	assert.Equal(t, map[string]interface{}{
+		"resourceLogs": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{"attributes": []interface{}{
+				map[string]interface{}{"key": "host.name", "value": map[string]interface{}{"stringValue": "h1"}},
+			}},
+			"scopeLogs": []interface{}{map[string]interface{}{
+				"scope": map[string]interface{}{"name": otlpScopeName},
+				"logRecords": []interface{}{map[string]interface{}{
+					"timeUnixNano":   "1609556645000000000",
+					"severityNumber": float64(otlpSeverityWarn),
+					"severityText":   "warn",
+					"body":           map[string]interface{}{"stringValue": "m"},
+					"spanId":         "0102030405060708",
+					"attributes": []interface{}{map[string]interface{}{
+						"key": "tags",
+						"value": map[string]interface{}{"arrayValue": map[string]interface{}{"values": []interface{}{
+							map[string]interface{}{"stringValue": "a"},
+							map[string]interface{}{"boolValue": true},
+						}}},
+					}},
+				}},
+			}},
+		}},
+	}, payload)
+}
+
+func TestOTLPConn_Protobuf(t *testing.T) {
+	var (
+		body        []byte
+		contentType string
+	)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contentType = r.Header.Get(contentTypeHeader)
+		body, _ = ioutil.ReadAll(r.Body)
+
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	conn, err := newConn("otlp+"+ts.URL, Config{}, ts.Client().Transport)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code, err := conn.Store(context.Background(), [][]byte{
+		[]byte(`{"source":"api","message":"1"}`),
+		[]byte(`{"source":"web","message":"2"}`),
+	})
+	assert.Equal(t, &ThrottleError{Delay: DefaultThrottleDelay}, err)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, protobufContentType, contentType)
+
+	var resources int
+
+	for len(body) > 0 {
+		num, typ, n := protowire.ConsumeTag(body)
+		assert.Equal(t, protowire.Number(1), num)
+		assert.Equal(t, protowire.BytesType, typ)
+
+		_, m := protowire.ConsumeBytes(body[n:])
+		assert.True(t, m > 0)
+
+		body = body[n+m:]
+		resources++
+	}
+
+	assert.Equal(t, 2, resources)
+
+	_, err = newConn("otlp+"+ts.URL+"?format=xml", Config{}, ts.Client().Transport)
+	assert.ErrorIs(t, err, ErrBadOTLPFormat)
+}
+
+func TestOTLPConn_Ping(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, otlpLogsURI, r.URL.Path)
+
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	conn, err := newConn("otlp+"+ts.URL, Config{}, ts.Client().Transport)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code, err := conn.Ping(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, code)
+}
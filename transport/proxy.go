@@ -0,0 +1,255 @@
+package transport
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// Proxy url schemes.
+const (
+	ProxyHTTP   = "http"
+	ProxyHTTPS  = "https"
+	ProxySOCKS5 = "socks5"
+)
+
+const proxyAuthorizationHeader = "Proxy-Authorization"
+
+var (
+	ErrBadProxy     = errors.New("proxy url invalid")
+	ErrProxyConnect = errors.New("proxy connect failed")
+)
+
+// DialFunc opens connections to nodes and proxies, e.g. (&net.Dialer{}).DialContext.
+type DialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// dialer opens connections of all nodes. HTTP requests are proxied by
+// http.Transport, TCP connections of other protocols are tunnelled through
+// the same proxy with CONNECT or SOCKS5, UDP and unix sockets are not proxied.
+type dialer struct {
+	dial  DialFunc
+	proxy func(req *http.Request) (*url.URL, error)
+	// roots verify TLS connections for their hosts, see verifyHost.
+	roots *certFile
+}
+
+func newDialer(config Config) (*dialer, error) {
+	d := &dialer{dial: config.Dialer, roots: config.tlsRoots}
+
+	if d.dial == nil {
+		d.dial = (&net.Dialer{}).DialContext
+	}
+
+	switch {
+	case config.Proxy != "":
+		proxyURL, err := url.Parse(config.Proxy)
+		if err != nil || proxyURL.Host == "" {
+			return nil, ErrBadProxy
+		}
+
+		switch proxyURL.Scheme {
+		case ProxyHTTP, ProxyHTTPS, ProxySOCKS5:
+		default:
+			return nil, ErrBadProxy
+		}
+
+		d.proxy = http.ProxyURL(proxyURL)
+	case config.ProxyFromEnvironment:
+		d.proxy = http.ProxyFromEnvironment
+	}
+
+	return d, nil
+}
+
+// netDialer returns dialer built by the clients pool,
+// connections created outside of the pool build their own dialer.
+func (c Config) netDialer() (*dialer, error) {
+	if c.dialer != nil {
+		return c.dialer, nil
+	}
+
+	return newDialer(c)
+}
+
+// DialContext opens the connection directly or through the proxy.
+func (d *dialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	proxyURL, err := d.proxyURL(network, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case proxyURL == nil:
+		return d.dial(ctx, network, addr)
+	case proxyURL.Scheme == ProxySOCKS5:
+		return d.dialSOCKS5(ctx, proxyURL, network, addr)
+	default:
+		return d.dialConnect(ctx, proxyURL, addr)
+	}
+}
+
+// dialConn opens a connection of stream or datagram based protocols,
+// the connection is secured with TLS when the config is set.
+func (d *dialer) dialConn(ctx context.Context, network, addr string, tlsConfig *tls.Config) (net.Conn, error) {
+	conn, err := d.DialContext(ctx, network, addr)
+	if err != nil || tlsConfig == nil {
+		return conn, err
+	}
+
+	host, _, _ := net.SplitHostPort(addr)
+
+	tlsConfig = verifyHost(tlsConfig, d.roots, host)
+
+	if tlsConfig.ServerName == "" {
+		tlsConfig.ServerName = host
+	}
+
+	return handshake(ctx, tls.Client(conn, tlsConfig))
+}
+
+// proxyURL returns proxy of the connection, connections are proxied
+// as HTTPS requests to the address, so HTTPS_PROXY and NO_PROXY apply.
+func (d *dialer) proxyURL(network, addr string) (*url.URL, error) {
+	if d.proxy == nil || !strings.HasPrefix(network, "tcp") {
+		return nil, nil
+	}
+
+	return d.proxy(&http.Request{URL: &url.URL{Scheme: "https", Host: addr}})
+}
+
+func (d *dialer) dialSOCKS5(ctx context.Context, proxyURL *url.URL, network, addr string) (net.Conn, error) {
+	var auth *proxy.Auth
+
+	if proxyURL.User != nil {
+		auth = &proxy.Auth{User: proxyURL.User.Username()}
+		auth.Password, _ = proxyURL.User.Password()
+	}
+
+	socks, err := proxy.SOCKS5("tcp", proxyAddr(proxyURL), auth, d.dial)
+	if err != nil {
+		return nil, err
+	}
+
+	return socks.(proxy.ContextDialer).DialContext(ctx, network, addr)
+}
+
+// Dial implements proxy.Dialer, so SOCKS5 proxies are reached with the func.
+func (f DialFunc) Dial(network, addr string) (net.Conn, error) {
+	return f(context.Background(), network, addr)
+}
+
+// DialContext implements proxy.ContextDialer.
+func (f DialFunc) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	return f(ctx, network, addr)
+}
+
+// dialConnect opens HTTP CONNECT tunnel to the address.
+func (d *dialer) dialConnect(ctx context.Context, proxyURL *url.URL, addr string) (net.Conn, error) {
+	conn, err := d.dial(ctx, "tcp", proxyAddr(proxyURL))
+	if err != nil {
+		return nil, err
+	}
+
+	if proxyURL.Scheme == ProxyHTTPS {
+		if conn, err = handshake(ctx, tls.Client(conn, &tls.Config{ServerName: proxyURL.Hostname()})); err != nil {
+			return nil, err
+		}
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+
+	if user := proxyURL.User; user != nil {
+		password, _ := user.Password()
+
+		req.Header.Set(proxyAuthorizationHeader,
+			"Basic "+base64.StdEncoding.EncodeToString([]byte(user.Username()+":"+password)))
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	reader := bufio.NewReader(conn)
+
+	resp, err := func() (*http.Response, error) {
+		if err := req.Write(conn); err != nil {
+			return nil, err
+		}
+
+		return http.ReadResponse(reader, req)
+	}()
+	if err != nil {
+		conn.Close()
+
+		return nil, err
+	}
+
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+
+		return nil, fmt.Errorf("%w: %s", ErrProxyConnect, resp.Status)
+	}
+
+	_ = conn.SetDeadline(time.Time{})
+
+	if reader.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, reader: reader}, nil
+	}
+
+	return conn, nil
+}
+
+// handshake completes TLS handshake before the deadline of the context.
+func handshake(ctx context.Context, conn *tls.Conn) (net.Conn, error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	if err := conn.Handshake(); err != nil {
+		conn.Close()
+
+		return nil, err
+	}
+
+	_ = conn.SetDeadline(time.Time{})
+
+	return conn, nil
+}
+
+// proxyAddr returns address of the proxy with default port of its scheme.
+func proxyAddr(proxyURL *url.URL) string {
+	if proxyURL.Port() != "" {
+		return proxyURL.Host
+	}
+
+	port := map[string]string{ProxyHTTP: "80", ProxyHTTPS: "443", ProxySOCKS5: "1080"}[proxyURL.Scheme]
+
+	return net.JoinHostPort(proxyURL.Hostname(), port)
+}
+
+// bufferedConn reads data the proxy sent after the CONNECT response.
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
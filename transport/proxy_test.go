@@ -0,0 +1,422 @@
+package transport
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testProxy is HTTP proxy stand-in, it forwards plain requests and tunnels CONNECT.
+type testProxy struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	requests []string
+	auth     []string
+}
+
+func newTestProxy(t *testing.T) *testProxy {
+	t.Helper()
+
+	p := &testProxy{}
+
+	p.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p.mu.Lock()
+		p.requests = append(p.requests, r.Method+" "+r.Host)
+		p.auth = append(p.auth, r.Header.Get(proxyAuthorizationHeader))
+		p.mu.Unlock()
+
+		if r.Method != http.MethodConnect {
+			r.RequestURI = ""
+
+			resp, err := http.DefaultTransport.RoundTrip(r)
+			if err != nil {
+				w.WriteHeader(http.StatusBadGateway)
+
+				return
+			}
+
+			defer resp.Body.Close()
+
+			w.WriteHeader(resp.StatusCode)
+			_, _ = io.Copy(w, resp.Body)
+
+			return
+		}
+
+		target, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+
+			return
+		}
+
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+
+			return
+		}
+
+		_, _ = conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+
+		pipe(conn, target)
+	}))
+
+	return p
+}
+
+func (p *testProxy) log() (requests, auth []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return append([]string{}, p.requests...), append([]string{}, p.auth...)
+}
+
+// newTestSOCKS5 starts SOCKS5 proxy stand-in without authentication,
+// it sends addresses of CONNECT requests to the channel.
+func newTestSOCKS5(t *testing.T) (net.Listener, <-chan string) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addrs := make(chan string, 10)
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				addr, err := socks5Handshake(conn)
+				if err != nil {
+					conn.Close()
+
+					return
+				}
+
+				addrs <- addr
+
+				target, err := net.Dial("tcp", addr)
+				if err != nil {
+					_, _ = conn.Write([]byte{5, 4, 0, 1, 0, 0, 0, 0, 0, 0})
+					conn.Close()
+
+					return
+				}
+
+				_, _ = conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+
+				pipe(conn, target)
+			}()
+		}
+	}()
+
+	return listener, addrs
+}
+
+// socks5Handshake accepts the client without authentication and reads address of its request.
+func socks5Handshake(conn net.Conn) (string, error) {
+	reader := bufio.NewReader(conn)
+
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return "", err
+	}
+
+	if _, err := io.ReadFull(reader, make([]byte, header[1])); err != nil {
+		return "", err
+	}
+
+	if _, err := conn.Write([]byte{5, 0}); err != nil {
+		return "", err
+	}
+
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(reader, request); err != nil {
+		return "", err
+	}
+
+	var host string
+
+	switch request[3] {
+	case 1:
+		ip := make([]byte, 4)
+		if _, err := io.ReadFull(reader, ip); err != nil {
+			return "", err
+		}
+
+		host = net.IP(ip).String()
+	case 3:
+		size, err := reader.ReadByte()
+		if err != nil {
+			return "", err
+		}
+
+		name := make([]byte, size)
+		if _, err := io.ReadFull(reader, name); err != nil {
+			return "", err
+		}
+
+		host = string(name)
+	default:
+		return "", io.ErrUnexpectedEOF
+	}
+
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(reader, port); err != nil {
+		return "", err
+	}
+
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))), nil
+}
+
+func pipe(a, b net.Conn) {
+	go func() {
+		_, _ = io.Copy(a, b)
+		a.Close()
+	}()
+
+	_, _ = io.Copy(b, a)
+	b.Close()
+}
+
+// newTestGELFListener returns TCP listener and channel of GELF messages sent to it.
+func newTestGELFListener(t *testing.T) (net.Listener, <-chan string) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	messages := make(chan string, 10)
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+
+		defer conn.Close()
+
+		reader := bufio.NewReader(conn)
+
+		for {
+			msg, err := reader.ReadString(0)
+			if err != nil {
+				return
+			}
+
+			messages <- msg
+		}
+	}()
+
+	return listener, messages
+}
+
+func TestNewClientsPool_Proxy(t *testing.T) {
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer collector.Close()
+
+	proxy := newTestProxy(t)
+	defer proxy.Close()
+
+	gelf, messages := newTestGELFListener(t)
+	defer gelf.Close()
+
+	proxyURL := strings.Replace(proxy.URL, "://", "://user:pass@", 1)
+
+	pool, err := NewClientsPoolWithConfig(Config{
+		Servers: []string{collector.URL, "gelf+tcp://" + gelf.Addr().String()},
+		Proxy:   proxyURL,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, client := range pool.(*ClusterPool).clients {
+		code, err := client.SendRequest([]byte(`{"message":"1"}`), time.Second)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, code)
+	}
+
+	assert.Contains(t, <-messages, `"short_message":"1"`)
+
+	requests, auth := proxy.log()
+
+	assert.Equal(t, []string{
+		http.MethodPost + " " + strings.TrimPrefix(collector.URL, "http://"),
+		http.MethodConnect + " " + gelf.Addr().String(),
+	}, requests)
+	assert.Equal(t, []string{"Basic dXNlcjpwYXNz", "Basic dXNlcjpwYXNz"}, auth)
+}
+
+func TestNewClientsPool_SOCKS5(t *testing.T) {
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer collector.Close()
+
+	socks, addrs := newTestSOCKS5(t)
+	defer socks.Close()
+
+	gelf, messages := newTestGELFListener(t)
+	defer gelf.Close()
+
+	pool, err := NewClientsPoolWithConfig(Config{
+		Servers: []string{collector.URL, "gelf+tcp://" + gelf.Addr().String()},
+		Proxy:   "socks5://" + socks.Addr().String(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, client := range pool.(*ClusterPool).clients {
+		code, err := client.SendRequest([]byte(`{"message":"1"}`), time.Second)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, code)
+	}
+
+	assert.Contains(t, <-messages, `"short_message":"1"`)
+	assert.Equal(t, strings.TrimPrefix(collector.URL, "http://"), <-addrs)
+	assert.Equal(t, gelf.Addr().String(), <-addrs)
+}
+
+func TestNewClientsPool_Dialer(t *testing.T) {
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer collector.Close()
+
+	var dialed []string
+
+	pool, err := NewClientsPoolWithConfig(Config{
+		Servers: []string{"http://collector.internal"},
+		Dialer: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			dialed = append(dialed, addr)
+
+			return (&net.Dialer{}).DialContext(ctx, network, collector.Listener.Addr().String())
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := pool.NextLive()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code, err := client.PingRequest(time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, []string{"collector.internal:80"}, dialed)
+}
+
+func TestNewDialer(t *testing.T) {
+	tests := []struct {
+		name          string
+		config        Config
+		network       string
+		expectedProxy string
+		expectedErr   error
+	}{
+		{
+			name:    "NoProxy",
+			network: "tcp",
+		},
+		{
+			name:          "HTTP",
+			config:        Config{Proxy: "http://proxy:3128"},
+			network:       "tcp",
+			expectedProxy: "http://proxy:3128",
+		},
+		{
+			name:    "UDP",
+			config:  Config{Proxy: "socks5://proxy"},
+			network: "udp",
+		},
+		{
+			name:        "BadScheme",
+			config:      Config{Proxy: "ftp://proxy"},
+			expectedErr: ErrBadProxy,
+		},
+		{
+			name:        "NoHost",
+			config:      Config{Proxy: "proxy:3128"},
+			expectedErr: ErrBadProxy,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := newDialer(tt.config)
+			assert.ErrorIs(t, err, tt.expectedErr)
+
+			if err != nil {
+				return
+			}
+
+			proxyURL, err := d.proxyURL(tt.network, "collector:50000")
+			assert.Nil(t, err)
+
+			if tt.expectedProxy == "" {
+				assert.Nil(t, proxyURL)
+			} else {
+				assert.Equal(t, tt.expectedProxy, proxyURL.String())
+			}
+		})
+	}
+}
+
+func TestProxyAddr(t *testing.T) {
+	tests := []struct {
+		name        string
+		input       string
+		expectedRes string
+	}{
+		{
+			name:        "HTTP",
+			input:       "http://proxy",
+			expectedRes: "proxy:80",
+		},
+		{
+			name:        "HTTPS",
+			input:       "https://proxy",
+			expectedRes: "proxy:443",
+		},
+		{
+			name:        "SOCKS5",
+			input:       "socks5://proxy",
+			expectedRes: "proxy:1080",
+		},
+		{
+			name:        "Port",
+			input:       "socks5://[::1]:9050",
+			expectedRes: "[::1]:9050",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxyURL, err := url.Parse(tt.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			assert.Equal(t, tt.expectedRes, proxyAddr(proxyURL))
+		})
+	}
+}
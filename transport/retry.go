@@ -0,0 +1,130 @@
+package transport
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/loghole/lhw/internal"
+)
+
+// DefaultMaxBackoff limits delay between retries of policies without MaxBackoff.
+const DefaultMaxBackoff = 10 * time.Second
+
+// maxBackoffAttempt caps the exponent of the backoff, delays of later
+// attempts are limited by MaxBackoff anyway.
+const maxBackoffAttempt = 64
+
+type RetryPolicy struct {
+	// InitialBackoff is a delay before the first retry.
+	InitialBackoff time.Duration
+	// MaxBackoff limits delay between retries, zero means DefaultMaxBackoff.
+	MaxBackoff time.Duration
+	// Multiplier increases delay after each retry.
+	Multiplier float64
+	// Jitter is a fraction of delay randomly subtracted from it, from 0 to 1.
+	Jitter float64
+	// MaxAttempts limits send attempts of an entry, 0 means unlimited.
+	MaxAttempts int
+	// Budget is a max count of retries allowed in a row, 0 means unlimited.
+	// Each retry spends a token and each successful request returns BudgetRatio
+	// tokens, so BudgetRatio must be positive if the budget is set.
+	Budget      int
+	BudgetRatio float64
+}
+
+// Clock creates timers of retry delays, it allows to replace time in tests.
+type Clock interface {
+	After(d time.Duration) <-chan time.Time
+}
+
+// Retrier computes delays of retries made by the writer and tracks the retry
+// budget, the budget is refilled by successful requests of the transport.
+type Retrier struct {
+	policy RetryPolicy
+	clock  Clock
+
+	mu     sync.Mutex
+	tokens float64
+	rand   func() float64
+}
+
+// NewRetrier creates retrier, nil clock means system clock.
+func NewRetrier(policy RetryPolicy, clock Clock) *Retrier {
+	if clock == nil {
+		clock = internal.SystemClock{}
+	}
+
+	if policy.MaxBackoff <= 0 {
+		policy.MaxBackoff = DefaultMaxBackoff
+	}
+
+	return &Retrier{
+		policy: policy,
+		clock:  clock,
+		tokens: float64(policy.Budget),
+		rand:   rand.Float64, // nolint:gosec // jitter does not need crypto rand.
+	}
+}
+
+// Backoff returns delay before retry after the attempt starting from 0.
+func (r *Retrier) Backoff(attempt int) time.Duration {
+	if attempt > maxBackoffAttempt {
+		attempt = maxBackoffAttempt
+	}
+
+	delay := float64(r.policy.InitialBackoff) * math.Pow(math.Max(r.policy.Multiplier, 1), float64(attempt))
+
+	// Overflowed delay is not a number or infinity.
+	if !(delay <= float64(r.policy.MaxBackoff)) {
+		delay = float64(r.policy.MaxBackoff)
+	}
+
+	if r.policy.Jitter > 0 {
+		r.mu.Lock()
+		delay -= delay * r.policy.Jitter * r.rand()
+		r.mu.Unlock()
+	}
+
+	return time.Duration(delay)
+}
+
+// Wait returns channel which receives after backoff of the attempt.
+func (r *Retrier) Wait(attempt int) <-chan time.Time {
+	return r.clock.After(r.Backoff(attempt))
+}
+
+// Exhausted reports whether an entry failed max allowed attempts.
+func (r *Retrier) Exhausted(failures int) bool {
+	return r.policy.MaxAttempts > 0 && failures >= r.policy.MaxAttempts
+}
+
+// Allow spends a retry token, it reports false if the budget is exhausted.
+func (r *Retrier) Allow() bool {
+	if r.policy.Budget <= 0 {
+		return true
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.tokens < 1 {
+		return false
+	}
+
+	r.tokens--
+
+	return true
+}
+
+// OnSuccess returns tokens to the retry budget.
+func (r *Retrier) OnSuccess() {
+	if r.policy.Budget <= 0 {
+		return
+	}
+
+	r.mu.Lock()
+	r.tokens = math.Min(r.tokens+r.policy.BudgetRatio, float64(r.policy.Budget))
+	r.mu.Unlock()
+}
@@ -0,0 +1,109 @@
+package transport
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetrier_Backoff(t *testing.T) {
+	tests := []struct {
+		name        string
+		policy      RetryPolicy
+		rand        float64
+		expectedRes []time.Duration
+	}{
+		{
+			name:        "Zero",
+			policy:      RetryPolicy{},
+			expectedRes: []time.Duration{0, 0, 0},
+		},
+		{
+			name: "Exponential",
+			policy: RetryPolicy{
+				InitialBackoff: 100 * time.Millisecond,
+				MaxBackoff:     time.Second,
+				Multiplier:     2,
+			},
+			expectedRes: []time.Duration{
+				100 * time.Millisecond,
+				200 * time.Millisecond,
+				400 * time.Millisecond,
+				800 * time.Millisecond,
+				time.Second,
+				time.Second,
+			},
+		},
+		{
+			name: "Jitter",
+			policy: RetryPolicy{
+				InitialBackoff: 100 * time.Millisecond,
+				MaxBackoff:     time.Second,
+				Multiplier:     2,
+				Jitter:         0.5,
+			},
+			rand: 0.5,
+			expectedRes: []time.Duration{
+				75 * time.Millisecond,
+				150 * time.Millisecond,
+				300 * time.Millisecond,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			retrier := NewRetrier(tt.policy, nil)
+			retrier.rand = func() float64 { return tt.rand }
+
+			for attempt, expected := range tt.expectedRes {
+				assert.Equal(t, expected, retrier.Backoff(attempt))
+			}
+		})
+	}
+}
+
+func TestRetrier_BackoffOverflow(t *testing.T) {
+	retrier := NewRetrier(RetryPolicy{InitialBackoff: time.Second, Multiplier: 10}, nil)
+
+	for _, attempt := range []int{1, 10, 100, 1000, 1 << 30} {
+		assert.Equal(t, DefaultMaxBackoff, retrier.Backoff(attempt))
+	}
+}
+
+func TestRetrier_Exhausted(t *testing.T) {
+	retrier := NewRetrier(RetryPolicy{MaxAttempts: 3}, nil)
+
+	assert.False(t, retrier.Exhausted(1))
+	assert.False(t, retrier.Exhausted(2))
+	assert.True(t, retrier.Exhausted(3))
+
+	retrier = NewRetrier(RetryPolicy{}, nil)
+
+	assert.False(t, retrier.Exhausted(1000))
+}
+
+func TestRetrier_Allow(t *testing.T) {
+	retrier := NewRetrier(RetryPolicy{Budget: 2, BudgetRatio: 0.5}, nil)
+
+	assert.True(t, retrier.Allow())
+	assert.True(t, retrier.Allow())
+	assert.False(t, retrier.Allow())
+
+	retrier.OnSuccess()
+
+	assert.False(t, retrier.Allow())
+
+	retrier.OnSuccess()
+
+	assert.True(t, retrier.Allow())
+	assert.False(t, retrier.Allow())
+
+	for i := 0; i < 10; i++ {
+		retrier.OnSuccess()
+	}
+
+	assert.True(t, retrier.Allow())
+	assert.True(t, retrier.Allow())
+	assert.False(t, retrier.Allow())
+}
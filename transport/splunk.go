@@ -0,0 +1,351 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	SchemeSplunkHTTP  = "splunk+http"
+	SchemeSplunkHTTPS = "splunk+https"
+)
+
+// Query parameters of splunk dsn.
+const (
+	splunkIndexParam      = "index"
+	splunkSourceTypeParam = "sourcetype"
+	splunkAckParam        = "ack"
+	splunkAckTimeoutParam = "ack_timeout"
+	splunkChannelParam    = "channel"
+)
+
+const (
+	splunkEventURI  = "/services/collector/event"
+	splunkAckURI    = "/services/collector/ack"
+	splunkHealthURI = "/services/collector/health"
+
+	splunkChannelHeader = "X-Splunk-Request-Channel"
+)
+
+const (
+	DefaultSplunkSourceType  = "_json"
+	DefaultSplunkAckInterval = 100 * time.Millisecond
+	DefaultSplunkAckTimeout  = time.Minute
+)
+
+var (
+	ErrSplunkNotAcknowledged = errors.New("splunk events not acknowledged")
+	ErrBadSplunkAckTimeout   = errors.New("splunk ack timeout invalid")
+)
+
+// splunkConn sends entries of the batch as events of one request to Splunk
+// HTTP Event Collector. With ack=true the batch is stored only after indexers
+// acknowledge it on the channel of the connection. Batches not acknowledged
+// before the ack timeout, ack_timeout=30s, are retried on the same node,
+// so they can be duplicated.
+type splunkConn struct {
+	client         *http.Client
+	addr           string
+	token          string
+	index          string
+	sourceType     string
+	channel        string
+	ack            bool
+	ackInterval    time.Duration
+	ackTimeout     time.Duration
+	requestTimeout time.Duration
+
+	// pending are acks of the channel waited by Store, they are
+	// polled together and closed once indexers acknowledge them.
+	mu      sync.Mutex
+	pending map[int64]chan struct{}
+}
+
+type splunkEvent struct {
+	Time       json.Number     `json:"time,omitempty"`
+	Host       string          `json:"host,omitempty"`
+	Source     string          `json:"source,omitempty"`
+	SourceType string          `json:"sourcetype,omitempty"`
+	Index      string          `json:"index,omitempty"`
+	Event      json.RawMessage `json:"event"`
+}
+
+type splunkResponse struct {
+	AckID int64 `json:"ackId"`
+}
+
+func newSplunkConn(dsn *url.URL, config Config, transport http.RoundTripper) (Conn, error) {
+	query := dsn.Query()
+
+	c := &splunkConn{
+		client:         &http.Client{Transport: transport},
+		token:          "Splunk" + " " + dsn.User.String(),
+		index:          query.Get(splunkIndexParam),
+		sourceType:     query.Get(splunkSourceTypeParam),
+		channel:        query.Get(splunkChannelParam),
+		ackInterval:    DefaultSplunkAckInterval,
+		ackTimeout:     DefaultSplunkAckTimeout,
+		requestTimeout: config.RequestTimeout,
+		pending:        make(map[int64]chan struct{}),
+	}
+
+	if c.sourceType == "" {
+		c.sourceType = DefaultSplunkSourceType
+	}
+
+	if c.ack, _ = strconv.ParseBool(query.Get(splunkAckParam)); c.ack && c.channel == "" {
+		c.channel = newChannelID()
+	}
+
+	if value := query.Get(splunkAckTimeoutParam); value != "" {
+		timeout, err := time.ParseDuration(value)
+		if err != nil || timeout <= 0 {
+			return nil, ErrBadSplunkAckTimeout
+		}
+
+		c.ackTimeout = timeout
+	}
+
+	addr := url.URL{
+		Scheme: strings.TrimPrefix(dsn.Scheme, "splunk+"),
+		Host:   dsn.Host,
+		Path:   strings.TrimSuffix(dsn.Path, "/"),
+	}
+
+	c.addr = addr.String()
+
+	return c, nil
+}
+
+// Store sends events of the batch and waits for indexer acknowledgement
+// if acks are enabled. The wait is limited by the ack timeout instead of
+// the request context, all events not acknowledged in time are returned
+// for retry as slow acks do not mean the node failed.
+func (c *splunkConn) Store(ctx context.Context, batch [][]byte) (code int, err error) {
+	resp, err := c.do(ctx, http.MethodPost, splunkEventURI, c.events(batch, time.Now()))
+	if err != nil {
+		return 0, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return resp.StatusCode, throttleError(resp)
+	}
+
+	if !c.ack {
+		return http.StatusOK, nil
+	}
+
+	var result splunkResponse
+
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return 0, err
+	}
+
+	code, err = c.waitAck(result.AckID)
+	if errors.Is(err, ErrSplunkNotAcknowledged) {
+		partial := &PartialError{Retry: make([]int, len(batch))}
+
+		for idx := range partial.Retry {
+			partial.Retry[idx] = idx
+		}
+
+		return http.StatusOK, partial
+	}
+
+	return code, err
+}
+
+func (c *splunkConn) Ping(ctx context.Context) (code int, err error) {
+	resp, err := c.do(ctx, http.MethodGet, splunkHealthURI, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := resp.Body.Close(); err != nil {
+		return 0, err
+	}
+
+	return resp.StatusCode, nil
+}
+
+func (c *splunkConn) Close() error {
+	return nil
+}
+
+// waitAck polls pending acks of the channel until indexers acknowledge
+// the request or the ack timeout expires.
+func (c *splunkConn) waitAck(id int64) (int, error) {
+	acked := make(chan struct{})
+
+	c.mu.Lock()
+	c.pending[id] = acked
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+	}()
+
+	timeout := time.NewTimer(c.ackTimeout)
+	defer timeout.Stop()
+
+	ticker := time.NewTicker(c.ackInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-acked:
+			return http.StatusOK, nil
+		case <-timeout.C:
+			return 0, ErrSplunkNotAcknowledged
+		case <-ticker.C:
+		}
+
+		if code, err := c.pollAcks(); err != nil || code != http.StatusOK {
+			return code, err
+		}
+	}
+}
+
+// pollAcks requests status of all pending acks of the channel
+// and closes acknowledged ones.
+func (c *splunkConn) pollAcks() (int, error) {
+	c.mu.Lock()
+
+	ids := make([]int64, 0, len(c.pending))
+
+	for id := range c.pending {
+		ids = append(ids, id)
+	}
+
+	c.mu.Unlock()
+
+	body, _ := json.Marshal(map[string][]int64{"acks": ids})
+
+	ctx, cancel := c.pollContext()
+	defer cancel()
+
+	resp, err := c.do(ctx, http.MethodPost, splunkAckURI, body)
+	if err != nil {
+		return 0, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return resp.StatusCode, throttleError(resp)
+	}
+
+	var result struct {
+		Acks map[string]bool `json:"acks"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return 0, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for id, acked := range c.pending {
+		if result.Acks[strconv.FormatInt(id, 10)] {
+			close(acked)
+			delete(c.pending, id)
+		}
+	}
+
+	return http.StatusOK, nil
+}
+
+// pollContext limits one ack request with the request timeout.
+func (c *splunkConn) pollContext() (context.Context, context.CancelFunc) {
+	if c.requestTimeout <= 0 {
+		return context.WithCancel(context.Background())
+	}
+
+	return context.WithTimeout(context.Background(), c.requestTimeout)
+}
+
+func (c *splunkConn) do(ctx context.Context, method, uri string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.addr+uri, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set(authorizationHeader, c.token)
+
+	if body != nil {
+		req.Header.Set(contentTypeHeader, jsonContentType)
+	}
+
+	if c.channel != "" {
+		req.Header.Set(splunkChannelHeader, c.channel)
+	}
+
+	return c.client.Do(req)
+}
+
+// events wraps entries in event envelopes, time, host, source and sourcetype
+// of the event are taken from the entry fields.
+func (c *splunkConn) events(batch [][]byte, now time.Time) []byte {
+	buf := &bytes.Buffer{}
+
+	for _, entry := range batch {
+		entry = bytes.TrimSpace(entry)
+
+		event := splunkEvent{Index: c.index, SourceType: c.sourceType, Time: epochSeconds(now)}
+
+		fields := make(map[string]interface{})
+
+		if err := json.Unmarshal(entry, &fields); err == nil {
+			event.Event = entry
+			event.Host, _ = fields["host"].(string)
+			event.Source, _ = fields["source"].(string)
+
+			if sourceType, ok := fields["sourcetype"].(string); ok && sourceType != "" {
+				event.SourceType = sourceType
+			}
+
+			if value, ok := fields["time"].(string); ok {
+				if parsed, err := time.Parse(time.RFC3339Nano, value); err == nil {
+					event.Time = epochSeconds(parsed)
+				}
+			}
+		} else {
+			// Entries which are not json objects are sent as string events.
+			event.Event, _ = json.Marshal(string(entry))
+		}
+
+		data, _ := json.Marshal(event)
+
+		buf.Write(data)
+		buf.WriteByte('\n')
+	}
+
+	return buf.Bytes()
+}
+
+// newChannelID returns random uuid used as acknowledgement channel.
+func newChannelID() string {
+	id := make([]byte, 16)
+
+	_, _ = rand.Read(id)
+
+	id[6] = id[6]&0x0f | 0x40 // version 4
+	id[8] = id[8]&0x3f | 0x80 // variant 10
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", id[:4], id[4:6], id[6:8], id[8:10], id[10:])
+}
@@ -0,0 +1,154 @@
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplunkConn_events(t *testing.T) {
+	now := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+
+	dsn := "splunk+https://token@127.0.0.1:8088?index=main&sourcetype=lhw"
+
+	conn, err := newConn(dsn, Config{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events := conn.(*splunkConn).events([][]byte{
+		[]byte(`{"host":"web1","source":"api","time":"2021-01-02T03:04:05.123Z","message":"m"}` + "\n"),
+		[]byte(`{"sourcetype":"access","time":1}`),
+		[]byte("plain text"),
+	}, now)
+
+	assert.Equal(t, strings.Join([]string{
+		`{"time":1609556645.123,"host":"web1","source":"api","sourcetype":"lhw","index":"main",` +
+			`"event":{"host":"web1","source":"api","time":"2021-01-02T03:04:05.123Z","message":"m"}}`,
+		`{"time":1614834367,"sourcetype":"access","index":"main","event":{"sourcetype":"access","time":1}}`,
+		`{"time":1614834367,"sourcetype":"lhw","index":"main","event":"plain text"}`,
+	}, "\n")+"\n", string(events))
+}
+
+func TestSplunkConn_Store(t *testing.T) {
+	tests := []struct {
+		name          string
+		query         string
+		ackAfter      int32
+		expectedCode  int
+		expectedErr   error
+		expectedPolls bool
+	}{
+		{
+			name:         "NoAck",
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "ChannelWithoutAck",
+			query:        "?channel=00000000-0000-4000-8000-000000000000",
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:          "Ack",
+			query:         "?ack=true",
+			ackAfter:      2,
+			expectedCode:  http.StatusOK,
+			expectedPolls: true,
+		},
+		{
+			name:          "NotAcknowledged",
+			query:         "?ack=true&ack_timeout=50ms&channel=00000000-0000-4000-8000-000000000000",
+			ackAfter:      1000,
+			expectedCode:  http.StatusOK,
+			expectedErr:   &PartialError{Retry: []int{0, 1}},
+			expectedPolls: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var polls int32
+
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, "Splunk token", r.Header.Get(authorizationHeader))
+
+				switch r.URL.Path {
+				case splunkEventURI:
+					body, _ := ioutil.ReadAll(r.Body)
+					assert.Equal(t, 2, strings.Count(string(body), "\n"))
+
+					_, _ = w.Write([]byte(`{"text":"Success","code":0,"ackId":7}`))
+				case splunkAckURI:
+					assert.NotEmpty(t, r.Header.Get(splunkChannelHeader))
+
+					var req struct {
+						Acks []int64 `json:"acks"`
+					}
+
+					assert.Nil(t, json.NewDecoder(r.Body).Decode(&req))
+					assert.Equal(t, []int64{7}, req.Acks)
+
+					acked := atomic.AddInt32(&polls, 1) >= tt.ackAfter
+
+					_ = json.NewEncoder(w).Encode(map[string]interface{}{"acks": map[string]bool{"7": acked}})
+				default:
+					t.Errorf("unexpected request %s", r.URL.Path)
+				}
+			}))
+			defer ts.Close()
+
+			conn, err := newConn("splunk+http://token@"+ts.Listener.Addr().String()+tt.query, Config{}, ts.Client().Transport)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			conn.(*splunkConn).ackInterval = 10 * time.Millisecond
+
+			// Acks are waited longer than the request context.
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+			defer cancel()
+
+			code, err := conn.Store(ctx, [][]byte{[]byte(`{"message":"1"}`), []byte(`{"message":"2"}`)})
+			assert.Equal(t, tt.expectedErr, err)
+			assert.Equal(t, tt.expectedCode, code)
+			assert.Equal(t, tt.expectedPolls, atomic.LoadInt32(&polls) > 0)
+		})
+	}
+}
+
+func TestNewSplunkConn_BadAckTimeout(t *testing.T) {
+	_, err := newConn("splunk+http://token@127.0.0.1:8088?ack=true&ack_timeout=soon", Config{}, nil)
+	assert.ErrorIs(t, err, ErrBadSplunkAckTimeout)
+}
+
+func TestSplunkConn_Ping(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, splunkHealthURI, r.URL.Path)
+
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	conn, err := newConn("splunk+"+ts.URL, Config{}, ts.Client().Transport)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code, err := conn.Ping(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+}
+
+func TestNewChannelID(t *testing.T) {
+	id := newChannelID()
+
+	assert.Regexp(t, `^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`, id)
+	assert.NotEqual(t, id, newChannelID())
+}
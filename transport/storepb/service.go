@@ -0,0 +1,210 @@
+package storepb
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	ServiceName = "lhw.store.v1.Collector"
+
+	storeMethod       = "/" + ServiceName + "/Store"
+	storeStreamMethod = "/" + ServiceName + "/StoreStream"
+	pingMethod        = "/" + ServiceName + "/Ping"
+)
+
+type CollectorClient interface {
+	Store(ctx context.Context, in *StoreRequest, opts ...grpc.CallOption) (*StoreResponse, error)
+	StoreStream(ctx context.Context, opts ...grpc.CallOption) (Collector_StoreStreamClient, error)
+	Ping(ctx context.Context, in *PingRequest, opts ...grpc.CallOption) (*PingResponse, error)
+}
+
+// nolint:revive,stylecheck // name follows generated code.
+type Collector_StoreStreamClient interface {
+	Send(*StoreRequest) error
+	CloseAndRecv() (*StoreResponse, error)
+	grpc.ClientStream
+}
+
+type collectorClient struct {
+	cc grpc.ClientConnInterface
+}
+
+// NewCollectorClient creates client of the service, the connection
+// must use Codec, e.g. grpc.WithDefaultCallOptions(grpc.ForceCodec(Codec{})).
+func NewCollectorClient(cc grpc.ClientConnInterface) CollectorClient {
+	return &collectorClient{cc: cc}
+}
+
+func (c *collectorClient) Store(ctx context.Context, in *StoreRequest, opts ...grpc.CallOption) (*StoreResponse, error) {
+	out := new(StoreResponse)
+
+	if err := c.cc.Invoke(ctx, storeMethod, in, out, opts...); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+func (c *collectorClient) StoreStream(ctx context.Context, opts ...grpc.CallOption) (Collector_StoreStreamClient, error) {
+	stream, err := c.cc.NewStream(ctx, &serviceDesc.Streams[0], storeStreamMethod, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &storeStreamClient{ClientStream: stream}, nil
+}
+
+func (c *collectorClient) Ping(ctx context.Context, in *PingRequest, opts ...grpc.CallOption) (*PingResponse, error) {
+	out := new(PingResponse)
+
+	if err := c.cc.Invoke(ctx, pingMethod, in, out, opts...); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+type storeStreamClient struct {
+	grpc.ClientStream
+}
+
+func (x *storeStreamClient) Send(m *StoreRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *storeStreamClient) CloseAndRecv() (*StoreResponse, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+
+	m := new(StoreResponse)
+
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+type CollectorServer interface {
+	Store(context.Context, *StoreRequest) (*StoreResponse, error)
+	StoreStream(Collector_StoreStreamServer) error
+	Ping(context.Context, *PingRequest) (*PingResponse, error)
+}
+
+// nolint:revive,stylecheck // name follows generated code.
+type Collector_StoreStreamServer interface {
+	SendAndClose(*StoreResponse) error
+	Recv() (*StoreRequest, error)
+	grpc.ServerStream
+}
+
+// UnimplementedCollectorServer can be embedded to implement only some methods.
+type UnimplementedCollectorServer struct{}
+
+func (UnimplementedCollectorServer) Store(context.Context, *StoreRequest) (*StoreResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method Store not implemented")
+}
+
+func (UnimplementedCollectorServer) StoreStream(Collector_StoreStreamServer) error {
+	return status.Error(codes.Unimplemented, "method StoreStream not implemented")
+}
+
+func (UnimplementedCollectorServer) Ping(context.Context, *PingRequest) (*PingResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method Ping not implemented")
+}
+
+// RegisterCollectorServer registers the service, the server
+// must use Codec, e.g. grpc.ForceServerCodec(Codec{}).
+func RegisterCollectorServer(s grpc.ServiceRegistrar, srv CollectorServer) {
+	s.RegisterService(&serviceDesc, srv)
+}
+
+func storeHandler(
+	srv interface{},
+	ctx context.Context,
+	dec func(interface{}) error,
+	interceptor grpc.UnaryServerInterceptor,
+) (interface{}, error) {
+	in := new(StoreRequest)
+
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+
+	if interceptor == nil {
+		return srv.(CollectorServer).Store(ctx, in)
+	}
+
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: storeMethod}
+
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CollectorServer).Store(ctx, req.(*StoreRequest))
+	}
+
+	return interceptor(ctx, in, info, handler)
+}
+
+func pingHandler(
+	srv interface{},
+	ctx context.Context,
+	dec func(interface{}) error,
+	interceptor grpc.UnaryServerInterceptor,
+) (interface{}, error) {
+	in := new(PingRequest)
+
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+
+	if interceptor == nil {
+		return srv.(CollectorServer).Ping(ctx, in)
+	}
+
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: pingMethod}
+
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CollectorServer).Ping(ctx, req.(*PingRequest))
+	}
+
+	return interceptor(ctx, in, info, handler)
+}
+
+func storeStreamHandler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(CollectorServer).StoreStream(&storeStreamServer{ServerStream: stream})
+}
+
+type storeStreamServer struct {
+	grpc.ServerStream
+}
+
+func (x *storeStreamServer) SendAndClose(m *StoreResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *storeStreamServer) Recv() (*StoreRequest, error) {
+	m := new(StoreRequest)
+
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*CollectorServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{MethodName: "Store", Handler: storeHandler},
+		{MethodName: "Ping", Handler: pingHandler},
+	},
+	Streams: []grpc.StreamDesc{
+		{StreamName: "StoreStream", Handler: storeStreamHandler, ClientStreams: true},
+	},
+	Metadata: "store.proto",
+}
@@ -0,0 +1,164 @@
+// Package storepb implements messages and gRPC service of store.proto.
+// Messages are encoded with protowire, so the package does not depend on
+// generated code and is wire compatible with it.
+package storepb
+
+import (
+	"errors"
+	"fmt"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+var ErrBadMessage = errors.New("bad message")
+
+// Message is implemented by all messages of the service.
+type Message interface {
+	Marshal() ([]byte, error)
+	Unmarshal(data []byte) error
+}
+
+type StoreRequest struct {
+	Entries [][]byte
+}
+
+func (m *StoreRequest) Marshal() ([]byte, error) {
+	var buf []byte
+
+	for _, entry := range m.Entries {
+		buf = protowire.AppendTag(buf, 1, protowire.BytesType)
+		buf = protowire.AppendBytes(buf, entry)
+	}
+
+	return buf, nil
+}
+
+func (m *StoreRequest) Unmarshal(data []byte) error {
+	m.Entries = m.Entries[:0]
+
+	return unmarshal(data, func(num protowire.Number, typ protowire.Type, data []byte) (int, error) {
+		if num != 1 || typ != protowire.BytesType {
+			return 0, nil
+		}
+
+		entry, n := protowire.ConsumeBytes(data)
+		if n < 0 {
+			return 0, protowire.ParseError(n)
+		}
+
+		m.Entries = append(m.Entries, append([]byte{}, entry...))
+
+		return n, nil
+	})
+}
+
+type StoreResponse struct {
+	Accepted uint64
+}
+
+func (m *StoreResponse) Marshal() ([]byte, error) {
+	var buf []byte
+
+	if m.Accepted != 0 {
+		buf = protowire.AppendTag(buf, 1, protowire.VarintType)
+		buf = protowire.AppendVarint(buf, m.Accepted)
+	}
+
+	return buf, nil
+}
+
+func (m *StoreResponse) Unmarshal(data []byte) error {
+	m.Accepted = 0
+
+	return unmarshal(data, func(num protowire.Number, typ protowire.Type, data []byte) (int, error) {
+		if num != 1 || typ != protowire.VarintType {
+			return 0, nil
+		}
+
+		value, n := protowire.ConsumeVarint(data)
+		if n < 0 {
+			return 0, protowire.ParseError(n)
+		}
+
+		m.Accepted = value
+
+		return n, nil
+	})
+}
+
+type PingRequest struct{}
+
+func (m *PingRequest) Marshal() ([]byte, error) { return nil, nil }
+
+func (m *PingRequest) Unmarshal(data []byte) error {
+	return unmarshal(data, skipField)
+}
+
+type PingResponse struct{}
+
+func (m *PingResponse) Marshal() ([]byte, error) { return nil, nil }
+
+func (m *PingResponse) Unmarshal(data []byte) error {
+	return unmarshal(data, skipField)
+}
+
+// fieldFunc consumes value of the known field and returns its length,
+// zero length means the field is unknown and should be skipped.
+type fieldFunc func(num protowire.Number, typ protowire.Type, data []byte) (int, error)
+
+func unmarshal(data []byte, field fieldFunc) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return fmt.Errorf("%w: %v", ErrBadMessage, protowire.ParseError(n))
+		}
+
+		data = data[n:]
+
+		n, err := field(num, typ, data)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrBadMessage, err)
+		}
+
+		if n == 0 {
+			n = protowire.ConsumeFieldValue(num, typ, data)
+			if n < 0 {
+				return fmt.Errorf("%w: %v", ErrBadMessage, protowire.ParseError(n))
+			}
+		}
+
+		data = data[n:]
+	}
+
+	return nil
+}
+
+func skipField(protowire.Number, protowire.Type, []byte) (int, error) {
+	return 0, nil
+}
+
+// Codec encodes messages of the package, it should be forced
+// on clients and servers of the service.
+type Codec struct{}
+
+func (Codec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(Message)
+	if !ok {
+		return nil, fmt.Errorf("%w: unexpected type %T", ErrBadMessage, v)
+	}
+
+	return m.Marshal()
+}
+
+func (Codec) Unmarshal(data []byte, v interface{}) error {
+	m, ok := v.(Message)
+	if !ok {
+		return fmt.Errorf("%w: unexpected type %T", ErrBadMessage, v)
+	}
+
+	return m.Unmarshal(data)
+}
+
+func (Codec) Name() string {
+	return "proto"
+}
@@ -0,0 +1,62 @@
+package storepb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func TestStoreRequest(t *testing.T) {
+	req := &StoreRequest{Entries: [][]byte{[]byte(`{"a":1}`), {}, []byte(`{"b":2}`)}}
+
+	data, err := req.Marshal()
+	assert.Nil(t, err)
+
+	// Unknown fields are skipped.
+	data = protowire.AppendTag(data, 2, protowire.VarintType)
+	data = protowire.AppendVarint(data, 10)
+
+	res := &StoreRequest{}
+
+	assert.Nil(t, res.Unmarshal(data))
+	assert.Equal(t, req, res)
+}
+
+func TestStoreResponse(t *testing.T) {
+	data, err := (&StoreResponse{Accepted: 300}).Marshal()
+	assert.Nil(t, err)
+
+	res := &StoreResponse{}
+
+	assert.Nil(t, res.Unmarshal(data))
+	assert.Equal(t, uint64(300), res.Accepted)
+}
+
+func TestUnmarshalError(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{name: "BadTag", data: []byte{0xff}},
+		{name: "ShortBytes", data: []byte{0x0a, 0x05, 'a'}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.ErrorIs(t, (&StoreRequest{}).Unmarshal(tt.data), ErrBadMessage)
+		})
+	}
+}
+
+func TestCodec(t *testing.T) {
+	_, err := Codec{}.Marshal("string")
+	assert.ErrorIs(t, err, ErrBadMessage)
+
+	data, err := Codec{}.Marshal(&StoreResponse{Accepted: 1})
+	assert.Nil(t, err)
+
+	res := &StoreResponse{}
+
+	assert.Nil(t, Codec{}.Unmarshal(data, res))
+	assert.Equal(t, uint64(1), res.Accepted)
+}
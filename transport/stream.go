@@ -0,0 +1,325 @@
+package transport
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+)
+
+const (
+	streamURI = "/api/v1/stream"
+
+	contentTypeHeader = "Content-Type"
+	ndjsonContentType = "application/x-ndjson"
+)
+
+var ErrStreamClosed = errors.New("stream closed")
+
+// streamConn sends entries over one long-lived request per node. Request
+// body is newline-delimited entries, an empty line ends a batch. The node
+// acknowledges every batch in order with a line containing status code
+// of the batch, so several batches are in flight at the same time.
+type streamConn struct {
+	client *http.Client
+	addr   string
+	token  string
+
+	mu     sync.Mutex
+	stream *stream
+	closed bool
+}
+
+// stream is one open store request.
+type stream struct {
+	writer *io.PipeWriter
+	cancel context.CancelFunc
+
+	// wmu serializes writes, so waiters are queued in the order of batches.
+	wmu sync.Mutex
+
+	mu      sync.Mutex
+	waiters []chan ack
+	err     error
+}
+
+// written is a result of the stream write.
+type written struct {
+	waiter <-chan ack
+	err    error
+}
+
+type ack struct {
+	code int
+	err  error
+}
+
+func newStreamConn(dsn *url.URL, _ Config, transport http.RoundTripper) (Conn, error) {
+	if signsBody(transportAuth(transport)) {
+		return nil, fmt.Errorf("%w: streaming requests can not be signed", ErrUnsupportedAuth)
+	}
+
+	c := &streamConn{
+		client: &http.Client{Transport: transport},
+		token:  "Bearer" + " " + dsn.User.String(),
+	}
+
+	addr := *dsn
+	addr.User = nil
+
+	c.addr = addr.String()
+
+	return c, nil
+}
+
+func (c *streamConn) Store(ctx context.Context, batch [][]byte) (code int, err error) {
+	c.mu.Lock()
+
+	if c.closed {
+		c.mu.Unlock()
+
+		return 0, ErrStreamClosed
+	}
+
+	if c.stream == nil {
+		c.stream = c.open()
+	}
+
+	s := c.stream
+
+	c.mu.Unlock()
+
+	// The write blocks until the node reads the batch, it is not done
+	// under the lock and is interrupted by resetting the stream.
+	done := make(chan written, 1)
+
+	go func() {
+		waiter, err := s.write(encodeStreamBatch(batch))
+
+		done <- written{waiter: waiter, err: err}
+	}()
+
+	var waiter <-chan ack
+
+	select {
+	case res := <-done:
+		if res.err != nil {
+			c.reset(s, res.err)
+
+			if res.waiter == nil {
+				return 0, res.err
+			}
+		}
+
+		waiter = res.waiter
+	case <-ctx.Done():
+		c.reset(s, ctx.Err())
+
+		return 0, ctx.Err()
+	}
+
+	select {
+	case res := <-waiter:
+		return res.code, res.err
+	case <-ctx.Done():
+		// The stream is broken, acknowledgements of later batches
+		// can not be matched with their waiters.
+		c.reset(s, ctx.Err())
+
+		return 0, ctx.Err()
+	}
+}
+
+func (c *streamConn) Ping(ctx context.Context) (code int, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.addr+pingURI, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set(authorizationHeader, c.token)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := resp.Body.Close(); err != nil {
+		return 0, err
+	}
+
+	return resp.StatusCode, nil
+}
+
+func (c *streamConn) Close() error {
+	c.mu.Lock()
+	c.closed = true
+	s := c.stream
+	c.mu.Unlock()
+
+	if s != nil {
+		c.reset(s, ErrStreamClosed)
+	}
+
+	return nil
+}
+
+// open starts the store request, its response is read in background.
+func (c *streamConn) open() *stream {
+	reader, writer := io.Pipe()
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	s := &stream{writer: writer, cancel: cancel}
+
+	go func() {
+		err := c.receive(ctx, s, reader)
+
+		c.reset(s, err)
+	}()
+
+	return s
+}
+
+func (c *streamConn) receive(ctx context.Context, s *stream, body io.ReadCloser) error {
+	defer body.Close()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.addr+streamURI, body)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set(authorizationHeader, c.token)
+	req.Header.Set(contentTypeHeader, ndjsonContentType)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	// Canceled request does not interrupt reading the response while
+	// the request body waits for the node to read it, so the body is closed.
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			resp.Body.Close()
+		case <-done:
+		}
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		// Whole stream is rejected, e.g. with 401 or 429.
+		s.fail(ack{code: resp.StatusCode})
+
+		return nil
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+
+	for scanner.Scan() {
+		code, err := strconv.Atoi(string(bytes.TrimSpace(scanner.Bytes())))
+		if err != nil {
+			return fmt.Errorf("bad stream acknowledgement: %w", err)
+		}
+
+		s.ack(ack{code: code})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	return io.ErrUnexpectedEOF
+}
+
+// reset fails waiters of the stream and drops it, the next batch opens a new one.
+// Closing the pipe and canceling the request interrupt blocked writes.
+func (c *streamConn) reset(s *stream, err error) {
+	c.mu.Lock()
+	if c.stream == s {
+		c.stream = nil
+	}
+	c.mu.Unlock()
+
+	_ = s.writer.CloseWithError(err)
+
+	s.cancel()
+
+	s.fail(ack{err: err})
+}
+
+// write sends the batch, it blocks until the node reads the batch
+// or the stream is reset.
+func (s *stream) write(data []byte) (<-chan ack, error) {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+
+	s.mu.Lock()
+
+	if s.err != nil {
+		s.mu.Unlock()
+
+		return nil, s.err
+	}
+
+	waiter := make(chan ack, 1)
+
+	// Waiter is added before writing, the node may acknowledge
+	// the batch before the write returns.
+	s.waiters = append(s.waiters, waiter)
+	s.mu.Unlock()
+
+	// Failed stream is reset by the caller, so the waiter receives either
+	// the error or the status the stream was rejected with.
+	if _, err := s.writer.Write(data); err != nil {
+		return waiter, err
+	}
+
+	return waiter, nil
+}
+
+func (s *stream) ack(res ack) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.waiters) == 0 {
+		return
+	}
+
+	s.waiters[0] <- res
+	s.waiters = s.waiters[1:]
+}
+
+// fail sends the result to all waiters and rejects next writes.
+func (s *stream) fail(res ack) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err == nil {
+		s.err = res.err
+		if s.err == nil {
+			s.err = ErrStreamClosed
+		}
+	}
+
+	for _, waiter := range s.waiters {
+		waiter <- res
+	}
+
+	s.waiters = nil
+}
+
+// encodeStreamBatch writes entries one per line followed by an empty line.
+func encodeStreamBatch(batch [][]byte) []byte {
+	return append(encodeLines(batch), '\n')
+}
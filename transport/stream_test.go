@@ -0,0 +1,218 @@
+package transport
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type stubStreamServer struct {
+	mu       sync.Mutex
+	batches  [][]string
+	streams  int
+	code     int
+	rejected bool
+	// stalled server never reads the stream body.
+	stalled bool
+}
+
+func (s *stubStreamServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == pingURI {
+		w.WriteHeader(http.StatusOK)
+
+		return
+	}
+
+	s.mu.Lock()
+	s.streams++
+	rejected := s.rejected
+	s.mu.Unlock()
+
+	if rejected {
+		w.WriteHeader(http.StatusUnauthorized)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.(http.Flusher).Flush()
+
+	if s.stalled {
+		<-r.Context().Done()
+
+		return
+	}
+
+	var (
+		scanner = bufio.NewScanner(r.Body)
+		batch   []string
+	)
+
+	for scanner.Scan() {
+		if line := scanner.Text(); line != "" {
+			batch = append(batch, line)
+
+			continue
+		}
+
+		s.mu.Lock()
+		s.batches = append(s.batches, batch)
+		code := s.code
+		s.mu.Unlock()
+
+		batch = nil
+
+		_, _ = w.Write([]byte(strconv.Itoa(code) + "\n"))
+		w.(http.Flusher).Flush()
+	}
+}
+
+func startStreamServer(t *testing.T, server *stubStreamServer) (*httptest.Server, Conn) {
+	t.Helper()
+
+	ts := httptest.NewUnstartedServer(server)
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+
+	t.Cleanup(ts.Close)
+
+	conn, err := newConn(ts.URL, Config{Streaming: true}, ts.Client().Transport)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { conn.Close() })
+
+	return ts, conn
+}
+
+func TestStreamConn(t *testing.T) {
+	server := &stubStreamServer{code: http.StatusOK}
+	_, conn := startStreamServer(t, server)
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			code, err := conn.Store(context.Background(), [][]byte{
+				[]byte(strconv.Itoa(i)),
+				[]byte("{\n\"multi\": \"line\"\n}\n"),
+			})
+			assert.Nil(t, err)
+			assert.Equal(t, http.StatusOK, code)
+		}(i)
+	}
+
+	wg.Wait()
+
+	code, err := conn.Ping(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, code)
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	assert.Equal(t, 1, server.streams, "batches should share one stream")
+	assert.Len(t, server.batches, 10)
+
+	for _, batch := range server.batches {
+		assert.Len(t, batch, 2)
+		assert.Equal(t, `{ "multi": "line" }`, batch[1])
+	}
+}
+
+func TestStreamConn_Ack(t *testing.T) {
+	server := &stubStreamServer{code: http.StatusTooManyRequests}
+	ts, conn := startStreamServer(t, server)
+
+	client, err := NewNodeClient(ts.URL, nil, WithConn(conn))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code, err := client.SendBatchRequest([][]byte{[]byte("1")}, time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusTooManyRequests, code)
+	assert.True(t, client.IsThrottled())
+}
+
+func TestStreamConn_Rejected(t *testing.T) {
+	server := &stubStreamServer{rejected: true}
+	_, conn := startStreamServer(t, server)
+
+	code, err := conn.Store(context.Background(), [][]byte{[]byte("1")})
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	code, err = conn.Store(context.Background(), [][]byte{[]byte("2")})
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	assert.Nil(t, conn.Close())
+
+	_, err = conn.Store(context.Background(), [][]byte{[]byte("3")})
+	assert.ErrorIs(t, err, ErrStreamClosed)
+}
+
+func TestStreamConn_Stalled(t *testing.T) {
+	server := &stubStreamServer{stalled: true}
+	_, conn := startStreamServer(t, server)
+
+	// The batch exceeds flow control window of the node.
+	batch := [][]byte{bytes.Repeat([]byte("1"), 8<<20)}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	_, err := conn.Store(ctx, batch)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	stored := make(chan error, 1)
+
+	go func() {
+		_, err := conn.Store(ctx, batch)
+
+		stored <- err
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+
+	closed := make(chan error, 1)
+
+	go func() { closed <- conn.Close() }()
+
+	select {
+	case err := <-closed:
+		assert.Nil(t, err)
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("close should not wait for the stalled write")
+	}
+
+	select {
+	case err := <-stored:
+		assert.ErrorIs(t, err, ErrStreamClosed)
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("store should be interrupted by close")
+	}
+}
+
+func TestEncodeStreamBatch(t *testing.T) {
+	res := encodeStreamBatch([][]byte{[]byte(" {\"a\":1}\n"), []byte("  "), []byte("{\n\"b\":2}")})
+
+	assert.Equal(t, "{\"a\":1}\n{ \"b\":2}\n\n", string(res))
+}
@@ -0,0 +1,307 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	SchemeSyslogTCP = "syslog+tcp"
+	SchemeSyslogUDP = "syslog+udp"
+	SchemeSyslogTLS = "syslog+tls"
+)
+
+// Syslog message formats, selected by format query parameter of the dsn.
+const (
+	SyslogRFC5424 = "rfc5424"
+	SyslogRFC3164 = "rfc3164"
+)
+
+// Query parameters of syslog dsn.
+const (
+	syslogFormatParam   = "format"
+	syslogFacilityParam = "facility"
+	syslogAppParam      = "app"
+)
+
+const (
+	syslogVersion      = "1"
+	syslogNilValue     = "-"
+	syslogDefaultApp   = "lhw"
+	syslogMaxHostname  = 255
+	syslogMaxAppName   = 48
+	syslogFacilityUser = 1
+	syslogSeverityInfo = 6
+
+	syslogRFC3164Stamp = time.Stamp
+	syslogRFC5424Stamp = "2006-01-02T15:04:05.000000Z07:00"
+)
+
+var (
+	ErrBadSyslogFormat   = errors.New("syslog format invalid")
+	ErrBadSyslogFacility = errors.New("syslog facility invalid")
+)
+
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5,
+	"lpr": 6, "news": 7, "uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+var syslogSeverities = map[string]int{
+	"emerg": 0, "alert": 1, "fatal": 2, "panic": 2, "dpanic": 2, "crit": 2,
+	"err": 3, "error": 3, "warn": 4, "warning": 4, "notice": 5,
+	"info": 6, "debug": 7,
+}
+
+// syslogConn sends entries as syslog messages, over TCP and TLS messages
+// are framed with octet counting, over UDP every message is a datagram.
+// Fields host, source and level of the entry are mapped to hostname,
+// app-name and severity, the whole entry is the message.
+type syslogConn struct {
+	network   string
+	addr      string
+	tlsConfig *tls.Config
+	dialer    *dialer
+	format    string
+	facility  int
+	app       string
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func newSyslogConn(dsn *url.URL, config Config, _ http.RoundTripper) (Conn, error) {
+	c := &syslogConn{
+		network:  "tcp",
+		addr:     dsn.Host,
+		format:   SyslogRFC5424,
+		facility: syslogFacilityUser,
+		app:      syslogDefaultApp,
+	}
+
+	switch dsn.Scheme {
+	case SchemeSyslogUDP:
+		c.network = "udp"
+	case SchemeSyslogTLS:
+		tlsConfig, err := config.clientTLSConfig(dsn.Hostname())
+		if err != nil {
+			return nil, err
+		}
+
+		c.tlsConfig = tlsConfig
+	}
+
+	dialer, err := config.netDialer()
+	if err != nil {
+		return nil, err
+	}
+
+	c.dialer = dialer
+
+	query := dsn.Query()
+
+	if format := query.Get(syslogFormatParam); format != "" {
+		if format != SyslogRFC5424 && format != SyslogRFC3164 {
+			return nil, ErrBadSyslogFormat
+		}
+
+		c.format = format
+	}
+
+	if name := query.Get(syslogFacilityParam); name != "" {
+		facility, ok := syslogFacilities[name]
+		if !ok {
+			return nil, ErrBadSyslogFacility
+		}
+
+		c.facility = facility
+	}
+
+	if app := query.Get(syslogAppParam); app != "" {
+		c.app = app
+	}
+
+	return c, nil
+}
+
+func (c *syslogConn) Store(ctx context.Context, batch [][]byte) (code int, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetWriteDeadline(deadline)
+	}
+
+	for _, entry := range batch {
+		if err := c.write(conn, c.message(entry, time.Now())); err != nil {
+			c.closeConn()
+
+			return 0, err
+		}
+	}
+
+	return http.StatusOK, nil
+}
+
+// Ping dials the relay if the connection is not established.
+func (c *syslogConn) Ping(ctx context.Context) (code int, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, err := c.dial(ctx); err != nil {
+		return 0, err
+	}
+
+	return http.StatusOK, nil
+}
+
+func (c *syslogConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.closeConn()
+
+	return nil
+}
+
+func (c *syslogConn) dial(ctx context.Context) (net.Conn, error) {
+	if c.conn != nil {
+		return c.conn, nil
+	}
+
+	conn, err := c.dialer.dialConn(ctx, c.network, c.addr, c.tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	c.conn = conn
+
+	return conn, nil
+}
+
+func (c *syslogConn) closeConn() {
+	if c.conn != nil {
+		_ = c.conn.Close()
+		c.conn = nil
+	}
+}
+
+// write sends the message as a datagram or as a frame with octet counting.
+func (c *syslogConn) write(conn net.Conn, msg []byte) error {
+	if c.network == "udp" {
+		_, err := conn.Write(msg)
+
+		return err
+	}
+
+	frame := make([]byte, 0, len(msg)+8)
+	frame = strconv.AppendInt(frame, int64(len(msg)), 10)
+	frame = append(frame, ' ')
+	frame = append(frame, msg...)
+
+	_, err := conn.Write(frame)
+
+	return err
+}
+
+type syslogFields struct {
+	Host   string `json:"host"`
+	Source string `json:"source"`
+	Level  string `json:"level"`
+	Time   string `json:"time"`
+}
+
+// message formats the entry as syslog message.
+func (c *syslogConn) message(entry []byte, now time.Time) []byte {
+	entry = bytes.TrimSpace(entry)
+
+	var fields syslogFields
+
+	// Entries which are not json objects are sent with default header fields.
+	_ = json.Unmarshal(entry, &fields)
+
+	severity, ok := syslogSeverities[strings.ToLower(fields.Level)]
+	if !ok {
+		severity = syslogSeverityInfo
+	}
+
+	stamp := now
+
+	if parsed, err := time.Parse(time.RFC3339Nano, fields.Time); err == nil {
+		stamp = parsed
+	}
+
+	app := fields.Source
+	if app == "" {
+		app = c.app
+	}
+
+	buf := bytes.NewBuffer(make([]byte, 0, len(entry)+128))
+
+	buf.WriteByte('<')
+	buf.WriteString(strconv.Itoa(c.facility*8 + severity))
+	buf.WriteByte('>')
+
+	if c.format == SyslogRFC3164 {
+		buf.WriteString(stamp.Format(syslogRFC3164Stamp))
+		buf.WriteByte(' ')
+		buf.WriteString(syslogToken(fields.Host, syslogMaxHostname))
+		buf.WriteByte(' ')
+		buf.WriteString(syslogToken(app, syslogMaxAppName))
+		buf.WriteString(": ")
+		buf.Write(entry)
+
+		return buf.Bytes()
+	}
+
+	buf.WriteString(syslogVersion)
+	buf.WriteByte(' ')
+	buf.WriteString(stamp.Format(syslogRFC5424Stamp))
+	buf.WriteByte(' ')
+	buf.WriteString(syslogToken(fields.Host, syslogMaxHostname))
+	buf.WriteByte(' ')
+	buf.WriteString(syslogToken(app, syslogMaxAppName))
+	// Process id, message id and structured data are not set.
+	buf.WriteString(" - - - ")
+	buf.Write(entry)
+
+	return buf.Bytes()
+}
+
+// syslogToken returns header field limited to printable ascii without spaces.
+func syslogToken(value string, max int) string {
+	if value == "" {
+		return syslogNilValue
+	}
+
+	token := []byte(value)
+
+	for idx, c := range token {
+		if c <= ' ' || c > '~' {
+			token[idx] = '_'
+		}
+	}
+
+	if len(token) > max {
+		token = token[:max]
+	}
+
+	return string(token)
+}
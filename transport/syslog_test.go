@@ -0,0 +1,157 @@
+package transport
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSyslogConn_message(t *testing.T) {
+	now := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+
+	tests := []struct {
+		name        string
+		dsn         string
+		entry       string
+		expectedRes string
+	}{
+		{
+			name:        "RFC5424",
+			dsn:         "syslog+tcp://127.0.0.1:514",
+			entry:       `{"host":"web 1","source":"api","level":"error","time":"2021-01-02T03:04:05.123456789Z"}` + "\n",
+			expectedRes: `<11>1 2021-01-02T03:04:05.123456Z web_1 api - - - {"host":"web 1","source":"api","level":"error","time":"2021-01-02T03:04:05.123456789Z"}`,
+		},
+		{
+			name:        "RFC3164",
+			dsn:         "syslog+udp://127.0.0.1:514?format=rfc3164&facility=local0",
+			entry:       `{"host":"web1","level":"warn","message":"m"}`,
+			expectedRes: `<132>Mar  4 05:06:07 web1 lhw: {"host":"web1","level":"warn","message":"m"}`,
+		},
+		{
+			name:        "NotJSON",
+			dsn:         "syslog+tcp://127.0.0.1:514?app=service",
+			entry:       "plain text",
+			expectedRes: `<14>1 2021-03-04T05:06:07.000000Z - service - - - plain text`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dsn, err := url.Parse(tt.dsn)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			conn, err := newSyslogConn(dsn, Config{}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			assert.Equal(t, tt.expectedRes, string(conn.(*syslogConn).message([]byte(tt.entry), now)))
+		})
+	}
+}
+
+func TestNewSyslogConn_Error(t *testing.T) {
+	_, err := newConn("syslog+tcp://127.0.0.1:514?format=rfc1", Config{}, nil)
+	assert.ErrorIs(t, err, ErrBadSyslogFormat)
+
+	_, err = newConn("syslog+tcp://127.0.0.1:514?facility=local9", Config{}, nil)
+	assert.ErrorIs(t, err, ErrBadSyslogFacility)
+}
+
+func TestSyslogConn_TCP(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer listener.Close()
+
+	frames := make(chan string, 2)
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+
+		defer conn.Close()
+
+		reader := bufio.NewReader(conn)
+
+		for {
+			length, err := reader.ReadString(' ')
+			if err != nil {
+				return
+			}
+
+			size, err := strconv.Atoi(length[:len(length)-1])
+			if err != nil {
+				return
+			}
+
+			msg := make([]byte, size)
+			if _, err := io.ReadFull(reader, msg); err != nil {
+				return
+			}
+
+			frames <- string(msg)
+		}
+	}()
+
+	conn, err := newConn("syslog+tcp://"+listener.Addr().String(), Config{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer conn.Close()
+
+	code, err := conn.Ping(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, code)
+
+	code, err = conn.Store(context.Background(), [][]byte{[]byte(`{"level":"debug"}`), []byte("second message")})
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, code)
+
+	assert.Regexp(t, `^<15>1 \S+ - lhw - - - {"level":"debug"}$`, <-frames)
+	assert.Regexp(t, `^<14>1 \S+ - lhw - - - second message$`, <-frames)
+}
+
+func TestSyslogConn_UDP(t *testing.T) {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer listener.Close()
+
+	conn, err := newConn("syslog+udp://"+listener.LocalAddr().String(), Config{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer conn.Close()
+
+	code, err := conn.Store(context.Background(), [][]byte{[]byte("1"), []byte("2")})
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, code)
+
+	buf := make([]byte, 1024)
+
+	for _, expected := range []string{"1", "2"} {
+		_ = listener.SetReadDeadline(time.Now().Add(time.Second))
+
+		n, _, err := listener.ReadFrom(buf)
+		assert.Nil(t, err)
+		assert.Regexp(t, `^<14>1 \S+ - lhw - - - `+expected+`$`, string(buf[:n]))
+	}
+}
@@ -0,0 +1,260 @@
+package transport
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	ErrBadRootCAs          = errors.New("root CAs file contains no certificates")
+	ErrNoPeerCertificates  = errors.New("server presented no certificates")
+	ErrBadClientCertConfig = errors.New("client certificate and key files must be set together")
+	ErrNoServerName        = errors.New("server name to verify is unknown")
+)
+
+// newTLSConfig returns TLS config of client connections. Client certificate
+// and root CAs are read from files on every handshake if the files changed,
+// so rotated certificates are used by new connections without restart.
+// Root CAs file is returned to bind verification of the config to node
+// hosts with verifyHost, it is nil if the file is not used.
+func newTLSConfig(config Config) (*tls.Config, *certFile, error) {
+	tlsConfig := &tls.Config{} // nolint:gosec // default min version.
+
+	if config.TLSConfig != nil {
+		tlsConfig = config.TLSConfig.Clone()
+	}
+
+	if config.Insecure {
+		tlsConfig.InsecureSkipVerify = true
+	}
+
+	if config.ServerName != "" {
+		tlsConfig.ServerName = config.ServerName
+	}
+
+	if (config.ClientCert == "") != (config.ClientKey == "") {
+		return nil, nil, ErrBadClientCertConfig
+	}
+
+	if config.ClientCert != "" {
+		cert := &certFile{certPath: config.ClientCert, keyPath: config.ClientKey}
+
+		if _, err := cert.certificate(); err != nil {
+			return nil, nil, err
+		}
+
+		tlsConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return cert.certificate()
+		}
+	}
+
+	if config.RootCAs == "" || tlsConfig.InsecureSkipVerify {
+		return tlsConfig, nil, nil
+	}
+
+	roots := &certFile{certPath: config.RootCAs}
+
+	if _, err := roots.pool(); err != nil {
+		return nil, nil, err
+	}
+
+	// Default verification uses static RootCAs, so the chain is verified
+	// with the current pool instead.
+	tlsConfig.InsecureSkipVerify = true // nolint:gosec // verified below.
+	tlsConfig.VerifyConnection = verifyConnection(roots, tlsConfig.ServerName)
+
+	return tlsConfig, roots, nil
+}
+
+// verifyHost returns copy of the config which verifies certificates with
+// the roots for the host, the server name of the config overrides the host.
+// crypto/tls does not send ip hosts as server names, so handshakes with ip
+// nodes are verified only if the config is bound to their host.
+func verifyHost(tlsConfig *tls.Config, roots *certFile, host string) *tls.Config {
+	tlsConfig = tlsConfig.Clone()
+
+	if tlsConfig == nil || roots == nil {
+		return tlsConfig
+	}
+
+	if tlsConfig.ServerName != "" {
+		host = tlsConfig.ServerName
+	}
+
+	tlsConfig.VerifyConnection = verifyConnection(roots, host)
+
+	return tlsConfig
+}
+
+// clientTLSConfig returns copy of TLS config built by the clients pool
+// verifying certificates for the host, connections created outside of
+// the pool build their own config.
+func (c Config) clientTLSConfig(host string) (*tls.Config, error) {
+	tlsConfig, roots := c.tlsConfig, c.tlsRoots
+
+	if tlsConfig == nil {
+		var err error
+
+		if tlsConfig, roots, err = newTLSConfig(c); err != nil {
+			return nil, err
+		}
+	}
+
+	return verifyHost(tlsConfig, roots, host), nil
+}
+
+// verifyConnection returns function verifying server certificates with
+// the current pool of the roots for the host or the server name of the
+// handshake if the host is empty.
+func verifyConnection(roots *certFile, host string) func(state tls.ConnectionState) error {
+	return func(state tls.ConnectionState) error {
+		pool, err := roots.pool()
+		if err != nil {
+			return err
+		}
+
+		name := host
+		if name == "" {
+			name = state.ServerName
+		}
+
+		if name == "" {
+			return ErrNoServerName
+		}
+
+		return verifyChain(state, pool, name)
+	}
+}
+
+// verifyChain verifies server certificates the way crypto/tls does
+// with the pool as root CAs, the name is a host name or an ip address.
+func verifyChain(state tls.ConnectionState, roots *x509.CertPool, name string) error {
+	if len(state.PeerCertificates) == 0 {
+		return ErrNoPeerCertificates
+	}
+
+	opts := x509.VerifyOptions{
+		DNSName:       name,
+		Roots:         roots,
+		Intermediates: x509.NewCertPool(),
+	}
+
+	for _, cert := range state.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+
+	_, err := state.PeerCertificates[0].Verify(opts)
+
+	return err
+}
+
+// certFile keeps certificate or CA pool read from files and reloads it
+// when modification time or size of the files changes.
+type certFile struct {
+	certPath string
+	keyPath  string
+
+	mu      sync.Mutex
+	watch   fileWatch
+	cert    *tls.Certificate
+	roots   *x509.CertPool
+	lastErr error
+}
+
+func (f *certFile) certificate() (*tls.Certificate, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !f.watch.changed(f.certPath, f.keyPath) {
+		return f.cert, f.lastErr
+	}
+
+	cert, err := tls.LoadX509KeyPair(f.certPath, f.keyPath)
+	if err != nil {
+		f.lastErr = err
+
+		return nil, err
+	}
+
+	f.cert, f.lastErr = &cert, nil
+
+	return f.cert, nil
+}
+
+func (f *certFile) pool() (*x509.CertPool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !f.watch.changed(f.certPath) {
+		return f.roots, f.lastErr
+	}
+
+	data, err := ioutil.ReadFile(f.certPath)
+	if err != nil {
+		f.lastErr = err
+
+		return nil, err
+	}
+
+	roots := x509.NewCertPool()
+
+	if !roots.AppendCertsFromPEM(data) {
+		f.lastErr = ErrBadRootCAs
+
+		return nil, ErrBadRootCAs
+	}
+
+	f.roots, f.lastErr = roots, nil
+
+	return roots, nil
+}
+
+// fileWatch detects changes of files by modification time and size.
+type fileWatch struct {
+	stamp []fileStamp
+}
+
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// changed reports whether the files changed since the last call,
+// files which can not be read are reported as changed.
+func (w *fileWatch) changed(paths ...string) bool {
+	stamp := make([]fileStamp, 0, len(paths))
+
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			w.stamp = nil
+
+			return true
+		}
+
+		stamp = append(stamp, fileStamp{modTime: info.ModTime(), size: info.Size()})
+	}
+
+	if len(stamp) == len(w.stamp) {
+		same := true
+
+		for idx := range stamp {
+			if !stamp[idx].modTime.Equal(w.stamp[idx].modTime) || stamp[idx].size != w.stamp[idx].size {
+				same = false
+			}
+		}
+
+		if same {
+			return false
+		}
+	}
+
+	w.stamp = stamp
+
+	return true
+}
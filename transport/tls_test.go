@@ -0,0 +1,334 @@
+package transport
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+
+	ca := &testCA{}
+
+	ca.cert, ca.key, ca.pem = newTestCert(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "ca"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil)
+
+	return ca
+}
+
+// issue returns PEM encoded certificate and key signed by the CA,
+// names are host names or ip addresses.
+func (ca *testCA) issue(t *testing.T, usage x509.ExtKeyUsage, names ...string) (certPEM, keyPEM []byte) {
+	t.Helper()
+
+	template := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "node"},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{usage},
+	}
+
+	for _, name := range names {
+		if ip := net.ParseIP(name); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, name)
+		}
+	}
+
+	_, key, certPEM := newTestCert(t, template, ca)
+
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return certPEM, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+}
+
+func (ca *testCA) server(t *testing.T, names ...string) *httptest.Server {
+	t.Helper()
+
+	cert, err := tls.X509KeyPair(ca.issue(t, x509.ExtKeyUsageServerAuth, names...))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.cert)
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	ts.TLS = &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    clientCAs,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+	ts.StartTLS()
+
+	return ts
+}
+
+func newTestCert(t *testing.T, template *x509.Certificate, parent *testCA) (*x509.Certificate, *ecdsa.PrivateKey, []byte) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template.SerialNumber = serial
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+
+	issuer, signer := template, key
+	if parent != nil {
+		issuer, signer = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, issuer, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cert, key, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+// writeFile writes the file and moves its modification time,
+// so rewrites within timestamp resolution are noticed.
+func writeFile(t *testing.T, path string, data []byte, modTime time.Time) {
+	t.Helper()
+
+	if err := ioutil.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNewTLSConfig_MutualTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lhw-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	var (
+		caFile   = filepath.Join(dir, "ca.pem")
+		certFile = filepath.Join(dir, "cert.pem")
+		keyFile  = filepath.Join(dir, "key.pem")
+		now      = time.Now()
+	)
+
+	oldCA := newTestCA(t)
+	certPEM, keyPEM := oldCA.issue(t, x509.ExtKeyUsageClientAuth)
+
+	writeFile(t, caFile, oldCA.pem, now)
+	writeFile(t, certFile, certPEM, now)
+	writeFile(t, keyFile, keyPEM, now)
+
+	config, _, err := newTLSConfig(Config{RootCAs: caFile, ClientCert: certFile, ClientKey: keyFile, ServerName: "collector"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(ts *httptest.Server) error {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: config}}
+
+		resp, err := client.Get(ts.URL)
+		if err != nil {
+			return err
+		}
+
+		return resp.Body.Close()
+	}
+
+	oldServer := oldCA.server(t, "collector")
+	defer oldServer.Close()
+
+	assert.Nil(t, get(oldServer))
+
+	otherName := oldCA.server(t, "other")
+	defer otherName.Close()
+
+	assert.NotNil(t, get(otherName))
+
+	// Certificates are rotated, new connections use the new files.
+	newCA := newTestCA(t)
+	certPEM, keyPEM = newCA.issue(t, x509.ExtKeyUsageClientAuth)
+
+	writeFile(t, caFile, newCA.pem, now.Add(time.Minute))
+	writeFile(t, certFile, certPEM, now.Add(time.Minute))
+	writeFile(t, keyFile, keyPEM, now.Add(time.Minute))
+
+	newServer := newCA.server(t, "collector")
+	defer newServer.Close()
+
+	assert.Nil(t, get(newServer))
+
+	rotated := oldCA.server(t, "collector")
+	defer rotated.Close()
+
+	assert.NotNil(t, get(rotated))
+}
+
+func TestNewTLSConfig_Error(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lhw-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	caFile := filepath.Join(dir, "ca.pem")
+
+	writeFile(t, caFile, []byte("not a certificate"), time.Now())
+
+	tests := []struct {
+		name        string
+		config      Config
+		expectedErr error
+	}{
+		{
+			name:        "BadRootCAs",
+			config:      Config{RootCAs: caFile},
+			expectedErr: ErrBadRootCAs,
+		},
+		{
+			name:        "NoRootCAs",
+			config:      Config{RootCAs: filepath.Join(dir, "missing.pem")},
+			expectedErr: os.ErrNotExist,
+		},
+		{
+			name:        "NoClientKey",
+			config:      Config{ClientCert: caFile},
+			expectedErr: ErrBadClientCertConfig,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := newTLSConfig(tt.config)
+			assert.ErrorIs(t, err, tt.expectedErr)
+		})
+	}
+}
+
+func TestNewTLSConfig_Insecure(t *testing.T) {
+	base := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	config, _, err := newTLSConfig(Config{TLSConfig: base, Insecure: true, ServerName: "collector"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.True(t, config.InsecureSkipVerify)
+	assert.Equal(t, "collector", config.ServerName)
+	assert.Equal(t, uint16(tls.VersionTLS12), config.MinVersion)
+	assert.False(t, base.InsecureSkipVerify)
+}
+
+func TestNewClientsPool_VerifyHost(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lhw-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	var (
+		caFile   = filepath.Join(dir, "ca.pem")
+		certFile = filepath.Join(dir, "cert.pem")
+		keyFile  = filepath.Join(dir, "key.pem")
+		ca       = newTestCA(t)
+	)
+
+	certPEM, keyPEM := ca.issue(t, x509.ExtKeyUsageClientAuth)
+
+	writeFile(t, caFile, ca.pem, time.Now())
+	writeFile(t, certFile, certPEM, time.Now())
+	writeFile(t, keyFile, keyPEM, time.Now())
+
+	tests := []struct {
+		name    string
+		names   []string
+		wantErr bool
+	}{
+		{
+			name:    "IPAddress",
+			names:   []string{"127.0.0.1"},
+			wantErr: false,
+		},
+		{
+			name:    "OtherName",
+			names:   []string{"other"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := ca.server(t, tt.names...)
+			defer ts.Close()
+
+			pool, err := NewClientsPoolWithConfig(Config{
+				Servers:    []string{ts.URL},
+				RootCAs:    caFile,
+				ClientCert: certFile,
+				ClientKey:  keyFile,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			defer pool.Close()
+
+			client, err := pool.NextLive()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = client.PingRequest(time.Second)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
@@ -4,12 +4,14 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
 
 	"github.com/loghole/lhw/internal"
+	"github.com/loghole/lhw/test"
 )
 
 func TestNew(t *testing.T) {
@@ -128,6 +130,7 @@ func TestHttpTransport_SendBulk(t *testing.T) {
 				requestTimeout: time.Second,
 				pingInterval:   time.Second,
 				successCodes:   map[int]bool{200: true},
+				retrier:        NewRetrier(RetryPolicy{}, nil),
 				deadSignal:     make(internal.Signal, 1),
 				liveSignal:     make(internal.Signal, 1),
 			},
@@ -145,6 +148,7 @@ func TestHttpTransport_SendBulk(t *testing.T) {
 				requestTimeout: time.Second,
 				pingInterval:   time.Second,
 				successCodes:   map[int]bool{200: true},
+				retrier:        NewRetrier(RetryPolicy{}, nil),
 				deadSignal:     make(internal.Signal, 1),
 				liveSignal:     make(internal.Signal, 1),
 			},
@@ -167,6 +171,7 @@ func TestHttpTransport_SendBulk(t *testing.T) {
 				requestTimeout: time.Second,
 				pingInterval:   time.Second,
 				successCodes:   map[int]bool{200: true},
+				retrier:        NewRetrier(RetryPolicy{}, nil),
 				deadSignal:     make(internal.Signal, 1),
 				liveSignal:     make(internal.Signal, 1),
 			},
@@ -221,6 +226,7 @@ func TestHttpTransport_pingDeadNodes(t *testing.T) {
 				requestTimeout: time.Second,
 				pingInterval:   time.Second,
 				successCodes:   map[int]bool{200: true},
+				retrier:        NewRetrier(RetryPolicy{}, nil),
 				deadSignal:     make(internal.Signal, 1),
 				liveSignal:     make(internal.Signal, 1),
 			},
@@ -236,6 +242,7 @@ func TestHttpTransport_pingDeadNodes(t *testing.T) {
 				requestTimeout: time.Second,
 				pingInterval:   time.Second,
 				successCodes:   map[int]bool{200: true},
+				retrier:        NewRetrier(RetryPolicy{}, nil),
 				deadSignal:     make(internal.Signal, 1),
 				liveSignal:     make(internal.Signal, 1),
 			},
@@ -282,6 +289,43 @@ func TestHttpTransport_pingDeadNodes(t *testing.T) {
 	}
 }
 
+func TestHttpTransport_Close(t *testing.T) {
+	conns := []*stubConn{{}, {}}
+
+	transport := &httpTransport{
+		clientsPool: &ClusterPool{clients: []*NodeClient{
+			{status: isDead, conn: conns[0], client: &http.Client{}},
+			{status: isDead, conn: conns[1], client: &http.Client{}},
+		}},
+		requestTimeout: time.Second,
+		pingInterval:   time.Hour,
+		successCodes:   map[int]bool{},
+		deadSignal:     make(internal.Signal, 1),
+		liveSignal:     make(internal.Signal, 1),
+		done:           make(chan struct{}),
+	}
+
+	stopped := make(chan struct{})
+
+	go func() {
+		transport.pingDeadNodes()
+		close(stopped)
+	}()
+
+	assert.Nil(t, transport.Close())
+	assert.Nil(t, transport.Close())
+
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Error("pinging is not stopped")
+	}
+
+	for _, conn := range conns {
+		assert.Equal(t, int32(1), atomic.LoadInt32(&conn.closed))
+	}
+}
+
 func bodyString(r *http.Request) string {
 	data, err := ioutil.ReadAll(r.Body)
 	if err != nil {
@@ -290,3 +334,157 @@ func bodyString(r *http.Request) string {
 
 	return string(data)
 }
+
+func TestEncodeBatch(t *testing.T) {
+	tests := []struct {
+		name        string
+		input       [][]byte
+		expectedRes string
+	}{
+		{
+			name:        "Empty",
+			input:       nil,
+			expectedRes: `[]`,
+		},
+		{
+			name:        "Single",
+			input:       [][]byte{[]byte(`{"message":"1"}` + "\n")},
+			expectedRes: `[{"message":"1"}]`,
+		},
+		{
+			name:        "Multiple",
+			input:       [][]byte{[]byte(`{"message":"1"}` + "\n"), []byte(`{"message":"2"}`)},
+			expectedRes: `[{"message":"1"},{"message":"2"}]`,
+		},
+		{
+			name:        "NotJSON",
+			input:       [][]byte{[]byte(`{"message":"1"}`), []byte(`plain "text"` + "\n"), []byte(`{"message":`)},
+			expectedRes: `[{"message":"1"},"plain \"text\"","{\"message\":"]`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expectedRes, string(encodeBatch(tt.input)))
+		})
+	}
+}
+
+func TestHttpTransport_SendFailover(t *testing.T) {
+	var requests int32
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	clock := test.NewFakeClock(time.Now())
+
+	clients := make([]*NodeClient, 3)
+	for idx := range clients {
+		clients[idx] = &NodeClient{status: isLive, addr: ts.URL, client: ts.Client()}
+	}
+
+	transport := &httpTransport{
+		clientsPool:    &ClusterPool{clients: clients},
+		requestTimeout: time.Second,
+		successCodes:   map[int]bool{200: true},
+		retrier:        NewRetrier(RetryPolicy{InitialBackoff: time.Second, Budget: 1, BudgetRatio: 1}, clock),
+		deadSignal:     make(internal.Signal, 1),
+		liveSignal:     make(internal.Signal, 1),
+	}
+
+	// Every live node is tried once without delays, retries are made by the writer.
+	err := transport.Send([]byte(`{"message":"some message"}`))
+	assert.EqualError(t, err, ErrNoAvailableClients.Error())
+	assert.ErrorIs(t, err, ErrUnexpectedStatus)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&requests))
+	assert.Empty(t, clock.Delays())
+	assert.True(t, transport.retrier.Allow(), "transport should not spend the retry budget")
+
+	// Absence of live nodes is not a node failure.
+	err = transport.Send([]byte(`{"message":"some message"}`))
+	assert.Equal(t, ErrNoAvailableClients, err)
+}
+
+func TestHttpTransport_SendThrottled(t *testing.T) {
+	throttled := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(retryAfterHeader, "10")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer throttled.Close()
+
+	live := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer live.Close()
+
+	clients := []*NodeClient{
+		{status: isLive, addr: throttled.URL, client: throttled.Client()},
+		{status: isLive, addr: live.URL, client: live.Client(), lastUseTime: time.Now().UnixNano()},
+	}
+
+	transport := &httpTransport{
+		connStatus:     isLive,
+		clientsPool:    &ClusterPool{clients: clients},
+		requestTimeout: time.Second,
+		successCodes:   map[int]bool{200: true},
+		retrier:        NewRetrier(RetryPolicy{}, nil),
+		deadSignal:     make(internal.Signal, 1),
+		liveSignal:     make(internal.Signal, 1),
+	}
+
+	err := transport.Send([]byte(`{"message":"some message"}`))
+	assert.Nil(t, err)
+	assert.Equal(t, isLive, clients[0].status, "throttled client should not be marked as dead")
+	assert.True(t, clients[0].IsThrottled())
+	assert.Equal(t, time.Duration(0), transport.ThrottleDelay())
+
+	transport.clientsPool = &SinglePool{client: clients[0]}
+
+	err = transport.Send([]byte(`{"message":"some message"}`))
+	assert.EqualError(t, err, ErrThrottled.Error())
+	assert.True(t, transport.IsConnected(), "throttled transport should stay connected")
+	assert.True(t, transport.ThrottleDelay() > 9*time.Second)
+}
+
+func TestHttpTransport_Hooks(t *testing.T) {
+	status := http.StatusInternalServerError
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	defer ts.Close()
+
+	hooks := &test.RecordHooks{}
+	client := &NodeClient{status: isLive, addr: ts.URL, name: ts.URL, client: ts.Client()}
+
+	transport := &httpTransport{
+		clientsPool:    &SinglePool{client: client},
+		requestTimeout: time.Second,
+		successCodes:   map[int]bool{200: true},
+		retrier:        NewRetrier(RetryPolicy{}, nil),
+		hooks:          hooks,
+		deadSignal:     make(internal.Signal, 1),
+		liveSignal:     make(internal.Signal, 1),
+	}
+
+	err := transport.Send([]byte(`{"message":"some message"}`))
+	assert.EqualError(t, err, ErrNoAvailableClients.Error())
+
+	status = http.StatusOK
+
+	go transport.pingDeadNodes()
+
+	select {
+	case <-time.After(time.Second):
+		t.Error("reconnection failed")
+	case <-transport.IsReconnected():
+	}
+
+	assert.Equal(t, []string{
+		"send_error " + ts.URL + ": unexpected status code: 500",
+		"node_down " + ts.URL,
+		"node_up " + ts.URL,
+	}, hooks.Events())
+}
@@ -0,0 +1,173 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+)
+
+const (
+	SchemeUnix    = "unix"
+	SchemeUnixRaw = "unix+raw"
+)
+
+// unixHost replaces host of requests sent to unix sockets.
+const unixHost = "unix"
+
+var ErrBadUnixSocket = errors.New("unix socket path invalid")
+
+// unixTransport sends HTTP requests of unix:///path/to.sock nodes to the socket,
+// so local agents receive the same requests as collector nodes.
+type unixTransport struct {
+	transport *http.Transport
+}
+
+// newUnixTransport returns transport for the node if its url has unix scheme.
+func newUnixTransport(server string, d *dialer) (http.RoundTripper, bool, error) {
+	dsn, err := url.Parse(server)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if dsn.Scheme != SchemeUnix {
+		return nil, false, nil
+	}
+
+	if dsn.Path == "" {
+		return nil, false, ErrBadUnixSocket
+	}
+
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return d.dialConn(ctx, "unix", dsn.Path, nil)
+		},
+	}
+
+	return &unixTransport{transport: transport}, true, nil
+}
+
+func (t *unixTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+
+	req.URL.Scheme = "http"
+	req.URL.Host = unixHost
+	req.Host = unixHost
+
+	return t.transport.RoundTrip(req)
+}
+
+// unixRawConn writes entries to unix+raw:///path/to.sock one per line,
+// the agent does not acknowledge entries, so written entries are stored.
+type unixRawConn struct {
+	path   string
+	dialer *dialer
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func newUnixRawConn(dsn *url.URL, config Config, _ http.RoundTripper) (Conn, error) {
+	if dsn.Path == "" {
+		return nil, ErrBadUnixSocket
+	}
+
+	dialer, err := config.netDialer()
+	if err != nil {
+		return nil, err
+	}
+
+	return &unixRawConn{path: dsn.Path, dialer: dialer}, nil
+}
+
+func (c *unixRawConn) Store(ctx context.Context, batch [][]byte) (code int, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetWriteDeadline(deadline)
+	}
+
+	if _, err := conn.Write(encodeLines(batch)); err != nil {
+		c.closeConn()
+
+		return 0, err
+	}
+
+	return http.StatusOK, nil
+}
+
+// Ping dials the socket if the connection is not established.
+func (c *unixRawConn) Ping(ctx context.Context) (code int, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, err := c.dial(ctx); err != nil {
+		return 0, err
+	}
+
+	return http.StatusOK, nil
+}
+
+func (c *unixRawConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.closeConn()
+
+	return nil
+}
+
+func (c *unixRawConn) dial(ctx context.Context) (net.Conn, error) {
+	if c.conn != nil {
+		return c.conn, nil
+	}
+
+	conn, err := c.dialer.dialConn(ctx, "unix", c.path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	c.conn = conn
+
+	return conn, nil
+}
+
+func (c *unixRawConn) closeConn() {
+	if c.conn != nil {
+		_ = c.conn.Close()
+		c.conn = nil
+	}
+}
+
+// encodeLines writes non-empty entries one per line.
+func encodeLines(batch [][]byte) []byte {
+	size := len(batch)
+
+	for _, entry := range batch {
+		size += len(entry)
+	}
+
+	buf := bytes.NewBuffer(make([]byte, 0, size))
+
+	for _, entry := range batch {
+		entry = bytes.TrimSpace(entry)
+		if len(entry) == 0 {
+			continue
+		}
+
+		// Line breaks are json whitespace, so they are replaced to keep one entry per line.
+		buf.Write(bytes.ReplaceAll(entry, []byte{'\n'}, []byte{' '}))
+		buf.WriteByte('\n')
+	}
+
+	return buf.Bytes()
+}
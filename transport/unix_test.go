@@ -0,0 +1,145 @@
+package transport
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func unixSocketPath(t *testing.T) string {
+	// Socket paths are limited to about 100 bytes, test temp dirs can be longer.
+	dir, err := ioutil.TempDir("", "lhw")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	return filepath.Join(dir, "agent.sock")
+}
+
+func TestUnixTransport(t *testing.T) {
+	path := unixSocketPath(t)
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	batches := make(chan []string, 1)
+
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == pingURI {
+			return
+		}
+
+		assert.Equal(t, storeURI, r.URL.Path)
+		assert.Equal(t, "Bearer token", r.Header.Get(authorizationHeader))
+
+		var batch []json.RawMessage
+
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&batch))
+
+		entries := make([]string, 0, len(batch))
+
+		for _, entry := range batch {
+			entries = append(entries, string(entry))
+		}
+
+		batches <- entries
+	})}
+
+	go server.Serve(listener)
+	defer server.Close()
+
+	transport, err := New(Config{
+		Servers:        []string{"unix://token@" + path},
+		RequestTimeout: time.Second,
+		PingInterval:   time.Second,
+		SuccessCodes:   []int{http.StatusOK},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Nil(t, transport.SendBatch([][]byte{[]byte(`{"message":"1"}`), []byte(`{"message":"2"}`)}))
+	assert.Equal(t, []string{`{"message":"1"}`, `{"message":"2"}`}, <-batches)
+}
+
+func TestUnixRawConn(t *testing.T) {
+	path := unixSocketPath(t)
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer listener.Close()
+
+	lines := make(chan string, 3)
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+
+		defer conn.Close()
+
+		scanner := bufio.NewScanner(conn)
+
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+
+	conn, err := newConn("unix+raw://"+path, Config{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer conn.Close()
+
+	code, err := conn.Ping(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, code)
+
+	code, err = conn.Store(context.Background(), [][]byte{[]byte("{\n\"message\":\"1\"}\n"), []byte(""), []byte(`{"message":"2"}`)})
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, code)
+
+	assert.Equal(t, `{ "message":"1"}`, <-lines)
+	assert.Equal(t, `{"message":"2"}`, <-lines)
+}
+
+func TestNewUnixTransport(t *testing.T) {
+	tests := []struct {
+		name        string
+		server      string
+		expectedOK  bool
+		expectedErr error
+	}{
+		{name: "HTTP", server: "http://127.0.0.1:50000"},
+		{name: "Unix", server: "unix:///var/run/loghole.sock", expectedOK: true},
+		{name: "NoPath", server: "unix://token@", expectedErr: ErrBadUnixSocket},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ok, err := newUnixTransport(tt.server, &dialer{})
+			assert.Equal(t, tt.expectedOK, ok)
+			assert.Equal(t, tt.expectedErr, err)
+		})
+	}
+
+	_, err := newConn("unix+raw://", Config{}, nil)
+	assert.ErrorIs(t, err, ErrBadUnixSocket)
+}
@@ -1,19 +1,40 @@
 package lhw
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/loghole/lhw/internal"
+	"github.com/loghole/lhw/metrics"
 	"github.com/loghole/lhw/transport"
 )
 
-var ErrWriteFailed = errors.New("[loghole-writer] write data to queue failed")
+// drainPollInterval is an interval of checking writer state in Flush.
+const drainPollInterval = 50 * time.Millisecond
+
+var (
+	ErrWriteFailed   = errors.New("[loghole-writer] write data to queue failed")
+	ErrEntryTooLarge = errors.New("[loghole-writer] entry too large")
+)
 
 // The url can contain secret token e.g. https://secret_token@localhost:50000
 // Comma separated arrays are also supported, e.g. urlA, urlB.
+// Schemes grpc:// and grpcs:// send entries to the gRPC collector service,
+// syslog+tcp://, syslog+udp:// and syslog+tls:// send them to syslog relays,
+// loki+http:// and loki+https:// push them to Grafana Loki,
+// es+http:// and es+https:// index them with Elasticsearch bulk API,
+// otlp+http:// and otlp+https:// export them to OpenTelemetry collectors,
+// kafka://broker1,broker2/topic and kafka+tls:// produce them to a Kafka topic,
+// gelf+udp://, gelf+tcp://, gelf+http:// and gelf+https:// send them to Graylog,
+// unix:///path/to.sock sends HTTP requests to a local agent over the socket
+// and unix+raw:///path/to.sock writes entries to it one per line,
+// splunk+http:// and splunk+https:// send them to Splunk HTTP Event Collector.
 // Options start with the defaults but can be overridden.
 func NewWriter(url string, options ...Option) (writer *Writer, err error) {
 	opts := GetDefaultOptions()
@@ -30,12 +51,53 @@ func NewWriter(url string, options ...Option) (writer *Writer, err error) {
 	}
 
 	writer = &Writer{
-		logger: opts.Logger,
-		queue:  internal.NewQueue(opts.QueueCap),
+		logger:        opts.Logger,
+		batchSize:     opts.BatchSize,
+		batchBytes:    opts.BatchBytes,
+		flushInterval: opts.FlushInterval,
+		maxEntrySize:  opts.MaxEntrySize,
+		truncate:      opts.TruncateEntries,
+		retrier:       transport.NewRetrier(opts.RetryPolicy, nil),
+		metrics:       metrics.New(opts.Metrics),
+		hooks:         opts.Hooks,
+		highWatermark: opts.QueueHighWatermark,
+		flushSignal:   make(internal.Signal, 1),
+		sendSlots:     make(chan struct{}, opts.MaxInFlight),
+		abort:         make(chan struct{}),
+
+		fallback:          opts.Fallback,
+		fallbackAfter:     opts.FallbackAfter,
+		fallbackWatermark: opts.FallbackWatermark,
+		fallbackReship:    opts.FallbackReship,
+		divertSignal:      make(internal.Signal, 1),
+	}
+
+	if _, ok := opts.Fallback.(Replayer); opts.FallbackReship && !ok {
+		return nil, ErrBadFallback
+	}
+
+	if writer.highWatermark == 0 {
+		writer.highWatermark = opts.QueueCap * 4 / 5
+	}
+
+	writer.queue, err = newBuffer(opts, writer.metrics, writer.evict)
+	if err != nil {
+		return nil, err
 	}
 
-	writer.transport, err = transport.New(opts.transportConfig())
+	// Entries left in the spool by the previous writer are sent by this one.
+	writer.pending = int64(writer.queue.Len())
+
+	config := opts.transportConfig()
+	config.Retrier = writer.retrier
+	config.Metrics = writer.metrics
+	config.Hooks = opts.Hooks
+
+	writer.transport, err = transport.New(config)
 	if err != nil {
+		writer.queue.Close()
+		writer.stopSpool()
+
 		return nil, err
 	}
 
@@ -48,76 +110,550 @@ func NewWriter(url string, options ...Option) (writer *Writer, err error) {
 
 type Writer struct {
 	transport transport.Transport
-	queue     *internal.Queue
+	queue     internal.Buffer
 	logger    Logger
 
+	batchSize     int
+	batchBytes    int
+	flushInterval time.Duration
+	maxEntrySize  int
+	truncate      bool
+	retrier       *transport.Retrier
+	metrics       *metrics.Metrics
+	hooks         Hooks
+	highWatermark int
+
+	fallback          io.Writer
+	fallbackAfter     time.Duration
+	fallbackWatermark int
+	fallbackReship    bool
+	divertSignal      internal.Signal
+	// outageSince is a time the worker found the transport disconnected.
+	outageSince time.Time
+	// reshipPending is set when the fallback holds entries to re-ship.
+	reshipPending int32
+
+	// aboveWatermark is set while the queue depth is above the high watermark.
+	aboveWatermark int32
+
+	// read is a sequence number of the next entry taken from the queue by the worker.
+	read uint64
+
+	// pending is a count of entries pushed to the queue but not delivered,
+	// dropped or diverted yet, entries are counted before they are pushed,
+	// so they are never missed by Flush on the way from the queue to a batch.
+	pending   int64
+	delivered int64
+	dropped   int64
+
+	flushSignal internal.Signal
+	// sendSlots limits count of batches sent at the same time, nil means no limit.
+	sendSlots chan struct{}
+	abort     chan struct{}
+	abortOnce sync.Once
+
 	wg sync.WaitGroup
 }
 
+// DrainReport describes the result of Flush or Shutdown.
+type DrainReport struct {
+	// Delivered is a count of entries sent while draining.
+	Delivered int64
+	// Abandoned is a count of entries dropped or not sent
+	// before the context expired.
+	Abandoned int64
+}
+
 // Write writes the data to the queue if it is not full.
+// Entries larger than max entry size are truncated or rejected.
 func (w *Writer) Write(p []byte) (n int, err error) {
-	return w.write(append([]byte{}, p...))
+	entry, err := w.limit(p)
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := w.write(entry); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// limit returns a copy of the entry truncated to max entry size,
+// it rejects too large entries if truncation is disabled.
+func (w *Writer) limit(p []byte) ([]byte, error) {
+	if w.maxEntrySize <= 0 || len(p) <= w.maxEntrySize {
+		return append([]byte{}, p...), nil
+	}
+
+	if !w.truncate {
+		w.metrics.Dropped(metrics.ReasonTooLarge, 1)
+
+		if w.hooks != nil {
+			w.hooks.OnDrop(append([]byte{}, p...), DropTooLarge)
+		}
+
+		return nil, ErrEntryTooLarge
+	}
+
+	return internal.Truncate(p, w.maxEntrySize), nil
 }
 
 // write writes the data to the queue if it is not full.
 func (w *Writer) write(p []byte) (n int, err error) {
-	if err := w.queue.Push(p); err != nil {
+	if err := w.push(p); err != nil {
+		w.metrics.Dropped(metrics.ReasonQueueFull, 1)
+
+		if w.hooks != nil {
+			w.hooks.OnDrop(p, DropQueueFull)
+		}
+
 		return 0, fmt.Errorf("%w: %v", ErrWriteFailed, err)
 	}
 
+	w.metrics.Written(1)
+	w.checkWatermark()
+
+	if w.fallback != nil && w.fallbackWatermark > 0 && w.queue.Len() >= w.fallbackWatermark {
+		w.divertSignal.Send()
+	}
+
 	return len(p), nil
 }
 
+// push pushes the entry to the queue counting it as pending.
+func (w *Writer) push(p []byte) error {
+	atomic.AddInt64(&w.pending, 1)
+
+	if err := w.queue.Push(p); err != nil {
+		atomic.AddInt64(&w.pending, -1)
+
+		return err
+	}
+
+	return nil
+}
+
+// checkWatermark calls the hook once the queue depth reaches the high watermark.
+func (w *Writer) checkWatermark() {
+	if w.hooks == nil || w.highWatermark <= 0 {
+		return
+	}
+
+	depth := w.queue.Len()
+
+	if depth < w.highWatermark {
+		atomic.StoreInt32(&w.aboveWatermark, 0)
+
+		return
+	}
+
+	if atomic.CompareAndSwapInt32(&w.aboveWatermark, 0, 1) {
+		w.hooks.OnQueueHighWatermark(depth)
+	}
+}
+
 // Close flushes any buffered log entries.
+// It waits until all entries are sent, use Shutdown to limit the waiting time.
 func (w *Writer) Close() error {
+	_, err := w.Shutdown(context.Background())
+
+	return err
+}
+
+// Flush sends buffered entries and waits until all of them are delivered
+// or the context expires. Entries not delivered in time stay in the queue.
+func (w *Writer) Flush(ctx context.Context) (DrainReport, error) {
+	delivered, dropped := atomic.LoadInt64(&w.delivered), atomic.LoadInt64(&w.dropped)
+
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+
+	for atomic.LoadInt64(&w.pending) > 0 {
+		w.flushSignal.Send()
+
+		select {
+		case <-ctx.Done():
+			return w.report(delivered, dropped), ctx.Err()
+		case <-ticker.C:
+		}
+	}
+
+	return w.report(delivered, dropped), nil
+}
+
+// Shutdown stops accepting new entries and sends buffered ones until all of
+// them are delivered or the context expires. Entries not sent before the
+// context expired are abandoned, with disk spool they are kept on disk
+// and sent by the next writer with the same spool, the spool is unlocked
+// once sends in progress are aborted. The transport is closed in both cases.
+func (w *Writer) Shutdown(ctx context.Context) (DrainReport, error) {
+	delivered, dropped := atomic.LoadInt64(&w.delivered), atomic.LoadInt64(&w.dropped)
+
 	w.queue.Close()
-	w.wg.Wait()
 
-	return nil
+	done := make(chan struct{})
+
+	go func() {
+		w.wg.Wait()
+		w.stopSpool()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		report := w.report(delivered, dropped)
+
+		return report, w.transport.Close()
+	case <-ctx.Done():
+		w.abortOnce.Do(func() { close(w.abort) })
+
+		report := w.report(delivered, dropped)
+
+		if err := w.transport.Close(); err != nil && w.logger != nil {
+			w.logger.Printf("[error] close transport failed: %v", err)
+		}
+
+		return report, ctx.Err()
+	}
+}
+
+func (w *Writer) report(delivered, dropped int64) DrainReport {
+	return DrainReport{
+		Delivered: atomic.LoadInt64(&w.delivered) - delivered,
+		Abandoned: atomic.LoadInt64(&w.dropped) - dropped + atomic.LoadInt64(&w.pending),
+	}
 }
 
+// worker accumulates queued entries into batches and sends a batch
+// when it reaches the size limits or the flush interval expires.
 func (w *Writer) worker() {
 	defer w.wg.Done()
 
-	for data := range w.queue.Read() {
-		if !w.transport.IsConnected() {
-			<-w.transport.IsReconnected()
+	var (
+		batch = internal.NewBatch(w.batchSize, w.batchBytes)
+		timer = time.NewTimer(w.flushInterval)
+	)
+
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-w.abort:
+			w.abandon(batch.Flush())
+			w.abandonQueued()
+
+			return
+		default:
 		}
 
+		select {
+		case data, ok := <-w.queue.Read():
+			if !ok {
+				w.flush(batch)
+
+				return
+			}
+
+			if !batch.Fits(data) {
+				w.flush(batch)
+			}
+
+			if batch.Len() == 0 {
+				resetTimer(timer, w.flushInterval)
+			}
+
+			batch.Append(data)
+			w.read++
+
+			if batch.IsFull() {
+				w.flush(batch)
+			}
+		case <-w.flushSignal:
+			w.flush(batch)
+		case <-timer.C:
+			w.flush(batch)
+
+			timer.Reset(w.flushInterval)
+		case <-w.abort:
+		}
+	}
+}
+
+func (w *Writer) flush(batch *internal.Batch) {
+	if atomic.LoadInt32(&w.reshipPending) == 1 && w.transport.IsConnected() {
+		atomic.StoreInt32(&w.reshipPending, 0)
+
 		w.wg.Add(1)
 
-		go w.send(data)
+		go w.reship()
+	}
+
+	if batch.Len() == 0 {
+		return
+	}
+
+	// Entries of the batch are the last ones taken from the queue.
+	first := w.read - uint64(batch.Len())
+
+	if !w.waitConnected(batch, first) {
+		return
+	}
+
+	// Slow down dispatching while the collector asks to pause sending.
+	if delay := w.transport.ThrottleDelay(); delay > 0 {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+
+		select {
+		case <-timer.C:
+		case <-w.abort:
+			w.abandon(batch.Flush())
+
+			return
+		}
+	}
+
+	if w.sendSlots != nil {
+		select {
+		case w.sendSlots <- struct{}{}:
+		case <-w.abort:
+			w.abandon(batch.Flush())
+
+			return
+		}
 	}
+
+	w.wg.Add(1)
+
+	go w.send(batch.Flush(), first)
 }
 
-func (w *Writer) send(data []byte) {
+// send sends the batch and retries it with backoff until it is delivered,
+// the retry policy gives up or the writer is aborted. Failures caused by
+// the absence of live nodes or throttling are not counted as attempts and
+// do not increase the backoff, waiting for live nodes ends on reconnection.
+// Entries are acknowledged to the queue starting from the sequence number
+// first once all of them are delivered or dropped, aborted entries are not.
+func (w *Writer) send(batch [][]byte, first uint64) {
 	defer w.wg.Done()
+	defer w.release(batch)
+
+	if w.sendSlots != nil {
+		defer func() { <-w.sendSlots }()
+	}
+
+	var (
+		size     = len(batch)
+		failures int
+	)
+
+	for {
+		err := w.transport.SendBatch(batch)
+		if err == nil {
+			w.deliver(len(batch))
+			w.queue.Ack(first, size)
+
+			return
+		}
+
+		if w.logger != nil {
+			w.logger.Printf("[error] send data failed: %v", err)
+		}
 
-	err := w.transport.Send(data)
-	if err == nil {
+		// Only entries failed with temporary errors are retried.
+		var partial *transport.PartialError
+		if errors.As(err, &partial) {
+			w.deliver(len(batch) - len(partial.Retry) - len(partial.Rejected))
+			w.drop(pickEntries(batch, partial.Rejected), metrics.ReasonRejected)
+
+			if batch = pickEntries(batch, partial.Retry); len(batch) == 0 {
+				w.queue.Ack(first, size)
+
+				return
+			}
+		}
+
+		var (
+			wait      <-chan time.Time
+			reconnect <-chan struct{}
+			failed    *transport.NodesFailedError
+		)
+
+		switch {
+		case errors.Is(err, transport.ErrThrottled):
+			wait = time.After(w.transport.ThrottleDelay())
+		case errors.Is(err, transport.ErrNoAvailableClients) && !errors.As(err, &failed):
+			wait = w.retrier.Wait(failures)
+			reconnect = w.transport.IsReconnected()
+		default:
+			failures++
+
+			if w.retrier.Exhausted(failures) || !w.retrier.Allow() {
+				if w.logger != nil {
+					w.logger.Printf("[error] drop %d entries after %d attempts", len(batch), failures)
+				}
+
+				w.drop(batch, metrics.ReasonSendFailed)
+				w.queue.Ack(first, size)
+
+				return
+			}
+
+			wait = w.retrier.Wait(failures - 1)
+		}
+
+		select {
+		case <-wait:
+			w.metrics.Retry()
+		case <-reconnect:
+			w.metrics.Retry()
+		case <-w.abort:
+			w.drop(batch, metrics.ReasonAborted)
+
+			return
+		}
+	}
+}
+
+// deliver counts entries sent to the collector.
+func (w *Writer) deliver(count int) {
+	if count <= 0 {
+		return
+	}
+
+	atomic.AddInt64(&w.delivered, int64(count))
+	atomic.AddInt64(&w.pending, -int64(count))
+	w.metrics.Delivered(count)
+}
+
+// drop discards entries taken from the queue.
+func (w *Writer) drop(entries [][]byte, reason string) {
+	if len(entries) == 0 {
 		return
 	}
 
-	if w.logger != nil {
-		w.logger.Printf("[error] send data failed: %v", err)
+	w.metrics.Dropped(reason, len(entries))
+	atomic.AddInt64(&w.dropped, int64(len(entries)))
+
+	if w.hooks != nil {
+		for _, entry := range entries {
+			w.hooks.OnDrop(entry, reason)
+		}
 	}
+	atomic.AddInt64(&w.pending, -int64(len(entries)))
+}
 
-	// if sending failed, return data to queue if it is not full.
-	_, err = w.write(data)
-	if err == nil {
+// abandonQueued drops entries left in the memory queue when the writer
+// is aborted, the spool keeps them on disk for the next writer.
+func (w *Writer) abandonQueued() {
+	if _, ok := w.queue.(*internal.Spool); ok {
 		return
 	}
 
-	if w.logger != nil {
-		w.logger.Printf("[error] %v", err)
+	for {
+		select {
+		case data, ok := <-w.queue.Read():
+			if !ok {
+				return
+			}
+
+			w.abandon([][]byte{data})
+		default:
+			return
+		}
+	}
+}
+
+// stopSpool stops reading of the disk spool and unlocks its directory,
+// it is called when no entries are sent anymore.
+func (w *Writer) stopSpool() {
+	if spool, ok := w.queue.(*internal.Spool); ok {
+		spool.Stop()
+	}
+}
+
+// evict counts the entry evicted from the queue by the overflow policy.
+func (w *Writer) evict(entry []byte) {
+	w.metrics.Dropped(metrics.ReasonQueueFull, 1)
+	atomic.AddInt64(&w.dropped, 1)
+	atomic.AddInt64(&w.pending, -1)
+
+	if w.hooks != nil {
+		w.hooks.OnDrop(entry, DropQueueFull)
+	}
+}
+
+// abandon drops entries taken from the queue when the writer is aborted.
+func (w *Writer) abandon(entries [][]byte) {
+	w.drop(entries, metrics.ReasonAborted)
+	w.release(entries)
+}
+
+// release frees space taken in the queue by entries which are sent or dropped.
+func (w *Writer) release(entries [][]byte) {
+	for _, entry := range entries {
+		w.queue.Release(entry)
+	}
+}
+
+// pickEntries returns entries of the batch with the indexes.
+func pickEntries(batch [][]byte, indexes []int) [][]byte {
+	entries := make([][]byte, 0, len(indexes))
+
+	for _, idx := range indexes {
+		if idx >= 0 && idx < len(batch) {
+			entries = append(entries, batch[idx])
+		}
+	}
+
+	return entries
+}
+
+// resetTimer restarts the timer, draining its channel if it already fired.
+func resetTimer(timer *time.Timer, d time.Duration) {
+	if !timer.Stop() {
+		select {
+		case <-timer.C:
+		default:
+		}
 	}
+
+	timer.Reset(d)
+}
+
+// newBuffer returns disk-backed spool if spool directory is set
+// or in-memory queue otherwise.
+func newBuffer(opts *Options, m *metrics.Metrics, onEvict func([]byte)) (internal.Buffer, error) {
+	if opts.SpoolDir != "" {
+		config := opts.spoolConfig()
+		config.Metrics = m
+
+		return internal.NewSpool(config)
+	}
+
+	config := opts.queueConfig()
+	config.Metrics = m
+	config.OnEvict = onEvict
+
+	return internal.NewQueueWithConfig(config), nil
 }
 
 func processURLString(url string) []string {
-	urls := strings.Split(url, ",")
+	var urls []string
+
+	for _, val := range strings.Split(url, ",") {
+		val = strings.TrimSpace(val)
+
+		// Brokers of kafka url are comma separated too, e.g. kafka://broker1,broker2/topic.
+		if last := len(urls) - 1; last >= 0 && !strings.Contains(val, "://") &&
+			strings.HasPrefix(urls[last], transport.SchemeKafka) {
+			urls[last] += "," + val
+
+			continue
+		}
 
-	for idx, val := range urls {
-		urls[idx] = strings.TrimSpace(val)
+		urls = append(urls, val)
 	}
 
 	return urls
@@ -1,11 +1,16 @@
 package lhw
 
 import (
+	"context"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
 	"github.com/loghole/lhw/internal"
+	"github.com/loghole/lhw/test"
+	"github.com/loghole/lhw/transport"
 )
 
 func TestWriter_Write(t *testing.T) {
@@ -69,3 +74,572 @@ func TestWriter_Write(t *testing.T) {
 		})
 	}
 }
+
+func TestWriter_worker(t *testing.T) {
+	tests := []struct {
+		name            string
+		batchSize       int
+		batchBytes      int
+		flushInterval   time.Duration
+		entries         int
+		expectedBatches int64
+	}{
+		{
+			name:            "BatchSize",
+			batchSize:       10,
+			batchBytes:      1 << 20,
+			flushInterval:   time.Hour,
+			entries:         100,
+			expectedBatches: 10,
+		},
+		{
+			name:            "BatchBytes",
+			batchSize:       100,
+			batchBytes:      len("test message") * 5,
+			flushInterval:   time.Hour,
+			entries:         100,
+			expectedBatches: 20,
+		},
+		{
+			name:            "FlushOnClose",
+			batchSize:       1000,
+			batchBytes:      1 << 20,
+			flushInterval:   time.Hour,
+			entries:         100,
+			expectedBatches: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transport := &test.StubTransport{}
+
+			writer := &Writer{
+				transport:     transport,
+				queue:         internal.NewQueue(tt.entries),
+				batchSize:     tt.batchSize,
+				batchBytes:    tt.batchBytes,
+				flushInterval: tt.flushInterval,
+			}
+
+			for i := 0; i < tt.entries; i++ {
+				_, err := writer.Write([]byte("test message"))
+				assert.Nil(t, err)
+			}
+
+			writer.wg.Add(1)
+
+			go writer.worker()
+
+			assert.Nil(t, writer.Close())
+
+			assert.Equal(t, int64(tt.entries), transport.Counter)
+			assert.Equal(t, tt.expectedBatches, transport.Batches)
+		})
+	}
+}
+
+func TestWriter_workerFlushInterval(t *testing.T) {
+	transport := &test.StubTransport{}
+
+	writer := &Writer{
+		transport:     transport,
+		queue:         internal.NewQueue(10),
+		batchSize:     10,
+		batchBytes:    1 << 20,
+		flushInterval: 50 * time.Millisecond,
+	}
+
+	writer.wg.Add(1)
+
+	go writer.worker()
+
+	_, err := writer.Write([]byte("test message"))
+	assert.Nil(t, err)
+
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt64(&transport.Batches) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	assert.Nil(t, writer.Close())
+	assert.Equal(t, int64(1), transport.Counter)
+}
+
+func TestWriter_spoolReplay(t *testing.T) {
+	dir := t.TempDir()
+
+	opts := GetDefaultOptions()
+	opts.SpoolDir = dir
+
+	queue, err := newBuffer(opts, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = (&Writer{queue: queue}).Write([]byte("test message"))
+	assert.Nil(t, err)
+
+	// The writer is stopped before sending.
+	queue.(*internal.Spool).Stop()
+
+	// Writer with the same spool sends entries left by the previous one.
+	queue, err = newBuffer(opts, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	transport := &test.StubTransport{}
+
+	writer := &Writer{
+		transport:     transport,
+		queue:         queue,
+		batchSize:     opts.BatchSize,
+		batchBytes:    opts.BatchBytes,
+		flushInterval: opts.FlushInterval,
+	}
+
+	writer.wg.Add(1)
+
+	go writer.worker()
+
+	assert.Nil(t, writer.Close())
+	assert.Equal(t, int64(1), transport.Counter)
+}
+
+func TestWriter_spoolShutdownAborted(t *testing.T) {
+	opts := GetDefaultOptions()
+	opts.SpoolDir = t.TempDir()
+
+	queue, err := newBuffer(opts, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writer := &Writer{
+		transport:     &test.StubTransport{Disconnected: true},
+		queue:         queue,
+		batchSize:     2,
+		batchBytes:    opts.BatchBytes,
+		flushInterval: time.Hour,
+		flushSignal:   make(internal.Signal, 1),
+		abort:         make(chan struct{}),
+	}
+
+	writer.wg.Add(1)
+
+	go writer.worker()
+
+	for i := 0; i < 5; i++ {
+		_, err := writer.Write([]byte("test message"))
+		assert.Nil(t, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	report, err := writer.Shutdown(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, DrainReport{Abandoned: 5}, report)
+
+	// Entries which were not delivered are kept in the spool,
+	// it is unlocked once the reader of the aborted writer exits.
+	assert.Eventually(t, func() bool {
+		queue, err = newBuffer(opts, nil, nil)
+
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+
+	assert.Equal(t, 5, queue.Len())
+
+	queue.(*internal.Spool).Stop()
+}
+
+func TestWriter_Flush(t *testing.T) {
+	transport := &test.StubTransport{}
+
+	writer := &Writer{
+		transport:     transport,
+		queue:         internal.NewQueue(100),
+		batchSize:     1000,
+		batchBytes:    1 << 20,
+		flushInterval: time.Hour,
+		flushSignal:   make(internal.Signal, 1),
+		abort:         make(chan struct{}),
+	}
+
+	writer.wg.Add(1)
+
+	go writer.worker()
+
+	for i := 0; i < 10; i++ {
+		_, err := writer.Write([]byte("test message"))
+		assert.Nil(t, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	report, err := writer.Flush(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, DrainReport{Delivered: 10}, report)
+	assert.Equal(t, int64(10), atomic.LoadInt64(&transport.Counter))
+
+	assert.Nil(t, writer.Close())
+}
+
+func TestWriter_Shutdown(t *testing.T) {
+	tests := []struct {
+		name           string
+		transport      *test.StubTransport
+		wantErr        bool
+		expectedReport DrainReport
+		expectedErr    string
+	}{
+		{
+			name:           "Delivered",
+			transport:      &test.StubTransport{},
+			expectedReport: DrainReport{Delivered: 10},
+		},
+		{
+			name:           "Abandoned",
+			transport:      &test.StubTransport{Disconnected: true},
+			wantErr:        true,
+			expectedReport: DrainReport{Abandoned: 10},
+			expectedErr:    context.DeadlineExceeded.Error(),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			writer := &Writer{
+				transport:     tt.transport,
+				queue:         internal.NewQueue(100),
+				batchSize:     1000,
+				batchBytes:    1 << 20,
+				flushInterval: time.Hour,
+				flushSignal:   make(internal.Signal, 1),
+				abort:         make(chan struct{}),
+			}
+
+			writer.wg.Add(1)
+
+			go writer.worker()
+
+			for i := 0; i < 10; i++ {
+				_, err := writer.Write([]byte("test message"))
+				assert.Nil(t, err)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+
+			report, err := writer.Shutdown(ctx)
+			if (err != nil) != tt.wantErr {
+				t.Error(err)
+			}
+
+			if tt.wantErr {
+				assert.EqualError(t, err, tt.expectedErr)
+			}
+
+			assert.Equal(t, tt.expectedReport, report)
+			assert.Equal(t, int32(1), atomic.LoadInt32(&tt.transport.Closed))
+		})
+	}
+}
+
+func TestWriter_sendRetry(t *testing.T) {
+	tests := []struct {
+		name              string
+		transport         *test.StubTransport
+		policy            transport.RetryPolicy
+		expectedDelivered int64
+		expectedDropped   int64
+		expectedDelays    []time.Duration
+	}{
+		{
+			name:              "Delivered",
+			transport:         &test.StubTransport{Failures: 2},
+			policy:            transport.RetryPolicy{InitialBackoff: time.Second, Multiplier: 2, MaxAttempts: 3},
+			expectedDelivered: 2,
+			expectedDelays:    []time.Duration{time.Second, 2 * time.Second},
+		},
+		{
+			name:            "MaxAttempts",
+			transport:       &test.StubTransport{Failures: 5},
+			policy:          transport.RetryPolicy{InitialBackoff: time.Second, Multiplier: 2, MaxAttempts: 3},
+			expectedDropped: 2,
+			expectedDelays:  []time.Duration{time.Second, 2 * time.Second},
+		},
+		{
+			name: "Partial",
+			transport: &test.StubTransport{Errors: []error{
+				&transport.PartialError{Retry: []int{1}},
+			}},
+			policy:            transport.RetryPolicy{InitialBackoff: time.Second, Multiplier: 2, MaxAttempts: 3},
+			expectedDelivered: 2,
+			expectedDelays:    []time.Duration{time.Second},
+		},
+		{
+			name: "PartialRejected",
+			transport: &test.StubTransport{Errors: []error{
+				&transport.PartialError{Rejected: []int{0}},
+			}},
+			policy:            transport.RetryPolicy{InitialBackoff: time.Second, Multiplier: 2, MaxAttempts: 3},
+			expectedDelivered: 1,
+			expectedDropped:   1,
+			expectedDelays:    []time.Duration{},
+		},
+		{
+			name: "NodesFailed",
+			transport: &test.StubTransport{Errors: []error{
+				&transport.NodesFailedError{Err: test.ErrStubSend},
+				&transport.NodesFailedError{Err: test.ErrStubSend},
+				&transport.NodesFailedError{Err: test.ErrStubSend},
+			}},
+			policy:          transport.RetryPolicy{InitialBackoff: time.Second, Multiplier: 2, MaxAttempts: 3},
+			expectedDropped: 2,
+			expectedDelays:  []time.Duration{time.Second, 2 * time.Second},
+		},
+		{
+			name: "NoAvailableClients",
+			transport: &test.StubTransport{Errors: []error{
+				transport.ErrNoAvailableClients,
+				transport.ErrNoAvailableClients,
+			}},
+			policy:            transport.RetryPolicy{InitialBackoff: time.Second, Multiplier: 2, MaxAttempts: 1},
+			expectedDelivered: 2,
+			expectedDelays:    []time.Duration{time.Second, time.Second},
+		},
+		{
+			name:            "Budget",
+			transport:       &test.StubTransport{Failures: 5},
+			policy:          transport.RetryPolicy{InitialBackoff: time.Second, Multiplier: 2, Budget: 1},
+			expectedDropped: 2,
+			expectedDelays:  []time.Duration{time.Second},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := test.NewFakeClock(time.Now())
+
+			writer := &Writer{
+				transport: tt.transport,
+				queue:     internal.NewQueue(1),
+				retrier:   transport.NewRetrier(tt.policy, clock),
+				pending:   2,
+			}
+
+			writer.wg.Add(1)
+			writer.send([][]byte{[]byte("1"), []byte("2")}, 0)
+
+			assert.Equal(t, tt.expectedDelivered, writer.delivered)
+			assert.Equal(t, tt.expectedDropped, writer.dropped)
+			assert.Equal(t, int64(0), writer.pending)
+			assert.Equal(t, tt.expectedDelays, clock.Delays())
+		})
+	}
+}
+
+func TestWriter_flushMaxInFlight(t *testing.T) {
+	var (
+		stub  = &test.StubTransport{}
+		queue = internal.NewQueueWithConfig(internal.QueueConfig{Capacity: 4, MaxBytes: 100})
+		batch = internal.NewBatch(10, 100)
+	)
+
+	writer := &Writer{
+		transport: stub,
+		queue:     queue,
+		retrier:   transport.NewRetrier(transport.RetryPolicy{}, nil),
+		sendSlots: make(chan struct{}, 1),
+		abort:     make(chan struct{}),
+	}
+
+	for _, entry := range []string{"1", "2"} {
+		assert.Nil(t, queue.Push([]byte(entry)))
+
+		batch.Append(<-queue.Read())
+		writer.read++
+		writer.pending++
+	}
+
+	// All send slots are busy.
+	writer.sendSlots <- struct{}{}
+
+	done := make(chan struct{})
+
+	go func() {
+		writer.flush(batch)
+		close(done)
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+
+	// Entries taken from the queue still count against its size.
+	assert.Equal(t, int64(0), atomic.LoadInt64(&stub.Batches))
+	assert.Equal(t, int64(2), queue.Size())
+
+	<-writer.sendSlots
+	<-done
+	writer.wg.Wait()
+
+	assert.Equal(t, int64(1), atomic.LoadInt64(&stub.Batches))
+	assert.Equal(t, int64(0), queue.Size())
+	assert.Len(t, writer.sendSlots, 0)
+}
+
+func TestWriter_WriteMaxEntrySize(t *testing.T) {
+	tests := []struct {
+		name        string
+		truncate    bool
+		input       string
+		wantErr     bool
+		expectedN   int
+		expectedRes string
+		expectedErr string
+	}{
+		{
+			name:        "Small",
+			input:       `{"message":"msg"}`,
+			expectedN:   17,
+			expectedRes: `{"message":"msg"}`,
+		},
+		{
+			name:        "Reject",
+			input:       `{"message":"long message"}`,
+			wantErr:     true,
+			expectedErr: ErrEntryTooLarge.Error(),
+		},
+		{
+			name:        "Truncate",
+			truncate:    true,
+			input:       `{"message":"long message"}`,
+			expectedN:   26,
+			expectedRes: `{"message":"long..."}` + "\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			writer := &Writer{
+				queue:        internal.NewQueue(1),
+				maxEntrySize: 22,
+				truncate:     tt.truncate,
+			}
+
+			n, err := writer.Write([]byte(tt.input))
+			if (err != nil) != tt.wantErr {
+				t.Error(err)
+			}
+
+			assert.Equal(t, tt.expectedN, n)
+
+			if tt.wantErr {
+				assert.EqualError(t, err, tt.expectedErr)
+
+				return
+			}
+
+			assert.Equal(t, tt.expectedRes, string(<-writer.queue.Read()))
+		})
+	}
+}
+
+func TestWriter_Hooks(t *testing.T) {
+	hooks := &test.RecordHooks{}
+
+	writer := &Writer{
+		transport:     &test.StubTransport{Failures: 5},
+		queue:         internal.NewQueue(2),
+		retrier:       transport.NewRetrier(transport.RetryPolicy{MaxAttempts: 1}, nil),
+		maxEntrySize:  5,
+		hooks:         hooks,
+		highWatermark: 2,
+	}
+
+	_, err := writer.Write([]byte("1"))
+	assert.Nil(t, err)
+
+	_, err = writer.Write([]byte("2"))
+	assert.Nil(t, err)
+
+	_, err = writer.Write([]byte("3"))
+	assert.ErrorIs(t, err, ErrWriteFailed)
+
+	_, err = writer.Write([]byte("too large"))
+	assert.ErrorIs(t, err, ErrEntryTooLarge)
+
+	<-writer.queue.Read()
+	<-writer.queue.Read()
+
+	_, err = writer.Write([]byte("4"))
+	assert.Nil(t, err)
+
+	_, err = writer.Write([]byte("5"))
+	assert.Nil(t, err)
+
+	writer.pending = 1
+	writer.wg.Add(1)
+	writer.send([][]byte{[]byte("6")}, 0)
+
+	assert.Equal(t, []string{
+		"high_watermark 2",
+		"drop 3: queue_full",
+		"drop too large: too_large",
+		"high_watermark 2",
+		"drop 6: send_failed",
+	}, hooks.Events())
+}
+
+func TestWriter_HooksDropOldest(t *testing.T) {
+	hooks := &test.RecordHooks{}
+
+	writer := &Writer{hooks: hooks, abort: make(chan struct{})}
+	writer.queue = internal.NewQueueWithConfig(internal.QueueConfig{
+		Capacity: 2,
+		Overflow: internal.DropOldest,
+		OnEvict:  writer.evict,
+	})
+
+	for _, entry := range []string{"1", "2", "3"} {
+		_, err := writer.Write([]byte(entry))
+		assert.Nil(t, err)
+	}
+
+	// Entries left in the queue are dropped when the writer is aborted.
+	writer.abandonQueued()
+
+	assert.Equal(t, []string{
+		"drop 1: queue_full",
+		"drop 2: aborted",
+		"drop 3: aborted",
+	}, hooks.Events())
+	assert.Equal(t, DrainReport{Abandoned: 3}, writer.report(0, 0))
+}
+
+func TestProcessURLString(t *testing.T) {
+	tests := []struct {
+		name        string
+		url         string
+		expectedRes []string
+	}{
+		{
+			name:        "Single",
+			url:         "http://token@127.0.0.1:50000",
+			expectedRes: []string{"http://token@127.0.0.1:50000"},
+		},
+		{
+			name:        "Several",
+			url:         "http://127.0.0.1:50000, grpc://127.0.0.1:50001",
+			expectedRes: []string{"http://127.0.0.1:50000", "grpc://127.0.0.1:50001"},
+		},
+		{
+			name:        "Kafka",
+			url:         "kafka://broker1,broker2:9093/logs?key=source, http://127.0.0.1:50000",
+			expectedRes: []string{"kafka://broker1,broker2:9093/logs?key=source", "http://127.0.0.1:50000"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expectedRes, processURLString(tt.url))
+		})
+	}
+}